@@ -0,0 +1,220 @@
+package ultraViolet
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned by TryLockFile for a file whose lock is held.
+var ErrLocked = errors.New("file locked by another process")
+
+// LockFile takes the advisory lock of f, waiting for it if another open
+// file holds it. Writers of data files take it while appending, so that
+// uv processes appending to the same file take turns.
+func LockFile(f *os.File) error {
+	return lockFile(f, true)
+}
+
+// TryLockFile takes the advisory lock of f as LockFile does, but returns
+// ErrLocked instead of waiting for it.
+func TryLockFile(f *os.File) error {
+	return lockFile(f, false)
+}
+
+// UnlockFile releases the lock of f. Closing f releases it too.
+func UnlockFile(f *os.File) error {
+	return unlockFile(f)
+}
+
+// SyncPolicy is when the Snapshots appended to a data file are synced to
+// disk: after each of them with SyncAlways, when the operating system
+// sees fit with SyncNever, or else after those appended a SyncPolicy
+// after the last sync, and on Close.
+type SyncPolicy time.Duration
+
+const (
+	SyncAlways SyncPolicy = 0
+	SyncNever  SyncPolicy = -1
+)
+
+// ParseSyncPolicy parses "always", "never" or a duration such as "5m".
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "never":
+		return SyncNever, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid fsync policy %q; use always, never or an interval such as 5m", s)
+	}
+	return SyncPolicy(d), nil
+}
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncNever:
+		return "never"
+	}
+	return time.Duration(p).String()
+}
+
+// Due reports whether Snapshots appended since a sync at synced must be
+// synced now.
+func (p SyncPolicy) Due(synced time.Time) bool {
+	return p == SyncAlways || p > 0 && time.Since(synced) >= time.Duration(p)
+}
+
+// AppendFile appends Snapshots to a data file that other processes may
+// append to as well, such as `uv track` run from cron while `uv daemon`
+// writes the same day. Each line is written in a single write while
+// holding the lock of the file, after catching up with the Snapshots
+// the others appended since, so that lines never interleave and deltas
+// apply to the Snapshot before them.
+type AppendFile struct {
+	// Fsync is when the Snapshots appended are synced to disk.
+	Fsync SyncPolicy
+	// KeyframeInterval is the KeyframeInterval of the DeltaEncoder of a
+	// file in FormatDelta.
+	KeyframeInterval int
+
+	path   string
+	format string
+	f      *os.File
+	enc    Encoder
+	// end is the size of the file after the last Snapshot appended.
+	end    int64
+	dirty  bool
+	synced time.Time
+}
+
+// OpenAppendFile opens the data file in path, creating it if necessary,
+// to append Snapshots to it in format, which is one of Formats, or "" to
+// carry on in the format of the file, as AppendEncoder does.
+func OpenAppendFile(path, format string) (*AppendFile, error) {
+	if format != "" {
+		if _, err := NewEncoder(nil, format); err != nil {
+			return nil, err
+		}
+	}
+	a := &AppendFile{path: path, format: format}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AppendFile) open() error {
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	a.f, a.enc, a.dirty = f, nil, false
+	return nil
+}
+
+// Append appends snap to the file.
+func (a *AppendFile) Append(snap *Snapshot) error {
+	if a.f == nil {
+		return os.ErrClosed
+	}
+	if err := a.lock(); err != nil {
+		return err
+	}
+	f := a.f
+	defer unlockFile(f)
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if a.enc == nil || fi.Size() != a.end {
+		// the file was appended to by someone else: carry on from its
+		// last Snapshot.
+		enc, err := AppendEncoder(f, fi.Size(), f, a.format)
+		if err != nil {
+			return err
+		}
+		if delta, ok := enc.(*DeltaEncoder); ok {
+			delta.KeyframeInterval = a.KeyframeInterval
+		}
+		a.enc = enc
+	}
+	a.dirty = true
+	if err := a.enc.Encode(snap); err != nil {
+		// what made it to the file is read again next time.
+		a.enc = nil
+		return err
+	}
+	if fi, err = f.Stat(); err != nil {
+		a.enc = nil
+		return err
+	}
+	a.end = fi.Size()
+	if a.Fsync.Due(a.synced) {
+		return a.Sync()
+	}
+	return nil
+}
+
+// lock takes the lock of the file. If the file was replaced while
+// waiting for it, e.g. by `uv fsck --repair`, or removed, the file in
+// path is opened instead.
+func (a *AppendFile) lock() error {
+	for {
+		if err := lockFile(a.f, true); err != nil {
+			return err
+		}
+		fi, err := a.f.Stat()
+		if err != nil {
+			unlockFile(a.f)
+			return err
+		}
+		current, err := os.Stat(a.path)
+		if err == nil && os.SameFile(fi, current) {
+			return nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			unlockFile(a.f)
+			return err
+		}
+		a.f.Close()
+		if err := a.open(); err != nil {
+			a.f = nil
+			return err
+		}
+	}
+}
+
+// Sync syncs the Snapshots appended since the last sync to disk.
+func (a *AppendFile) Sync() error {
+	if a.f == nil || !a.dirty {
+		return nil
+	}
+	if err := a.f.Sync(); err != nil {
+		return err
+	}
+	a.dirty, a.synced = false, time.Now()
+	return nil
+}
+
+// Close syncs the file, unless Fsync is SyncNever, and closes it.
+func (a *AppendFile) Close() error {
+	if a.f == nil {
+		return nil
+	}
+	var err error
+	if a.Fsync != SyncNever {
+		err = a.Sync()
+	}
+	if cerr := a.f.Close(); err == nil {
+		err = cerr
+	}
+	a.f = nil
+	return err
+}
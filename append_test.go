@@ -0,0 +1,186 @@
+package ultraViolet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSyncPolicy(t *testing.T) {
+	cases := []struct {
+		In     string
+		Policy SyncPolicy
+		Err    bool
+	}{
+		{In: "always", Policy: SyncAlways},
+		{In: "never", Policy: SyncNever},
+		{In: "10m", Policy: SyncPolicy(10 * time.Minute)},
+		{In: "0s", Err: true},
+		{In: "-1m", Err: true},
+		{In: "sometimes", Err: true},
+	}
+	for i, c := range cases {
+		p, err := ParseSyncPolicy(c.In)
+		if (err != nil) != c.Err || !c.Err && p != c.Policy {
+			t.Errorf("case%d: %v %v", i, p, err)
+		}
+		if again, err := ParseSyncPolicy(p.String()); !c.Err && (err != nil || again != p) {
+			t.Errorf("case%d: %s parsed as %v %v", i, p, again, err)
+		}
+	}
+}
+
+// appendTestSnapshot returns the n-th Snapshot of writer, whose windows
+// change with every Snapshot.
+func appendTestSnapshot(writer, n int) *Snapshot {
+	name := fmt.Sprintf("writer%d", writer)
+	return &Snapshot{
+		Time: time.Date(2017, 1, 1, 9, 0, 0, 0, time.UTC).Add(time.Duration(n) * time.Second),
+		Windows: []*Window{
+			{ID: writer, Name: name},
+			{ID: 100 + n%3, Name: fmt.Sprintf("%s: %d", name, n)},
+		},
+		Active: writer,
+	}
+}
+
+func TestAppendFileConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uv-append")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data.json")
+
+	const writers, snaps = 8, 30
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			format := Formats[writer%len(Formats)]
+			var f *AppendFile
+			var err error
+			for n := 0; n < snaps; n++ {
+				// half of the writers open the file for every
+				// Snapshot, as `uv track` does.
+				if f == nil || writer%4 < 2 {
+					if f != nil {
+						f.Close()
+					}
+					if f, err = OpenAppendFile(path, format); err != nil {
+						errs <- err
+						return
+					}
+					f.Fsync = SyncNever
+					f.KeyframeInterval = 5
+				}
+				if err := f.Append(appendTestSnapshot(writer, n)); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- f.Close()
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stream, err := ReadStream(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.Snapshots) != writers*snaps {
+		t.Fatalf("%d snapshots", len(stream.Snapshots))
+	}
+	// the Snapshots of each writer are in order, and deltas were applied
+	// to the right ones.
+	next := make([]int, writers)
+	for _, snap := range stream.Snapshots {
+		writer := snap.Active
+		want := appendTestSnapshot(writer, next[writer])
+		if got, want := snap.Print(), want.Print(); got != want {
+			t.Fatalf("writer%d: %s, want %s", writer, got, want)
+		}
+		next[writer]++
+	}
+}
+
+func TestAppendFileReplaced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uv-append")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data.json")
+
+	f, err := OpenAppendFile(path, FormatDelta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Append(appendTestSnapshot(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	// repaired under the writer's feet, keeping the Snapshot.
+	if _, err := RepairFile(path, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Append(appendTestSnapshot(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := ReadStream(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.Snapshots) != 2 || stream.Snapshots[1].Print() != appendTestSnapshot(1, 1).Print() {
+		t.Errorf("after a repair:\n%s", b)
+	}
+}
+
+func TestTryLockFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "uv-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	other, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	if err := TryLockFile(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := TryLockFile(other); err != ErrLocked {
+		t.Errorf("locked twice: %v", err)
+	}
+	if err := UnlockFile(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := TryLockFile(other); err != nil {
+		t.Errorf("after unlocking: %v", err)
+	}
+}
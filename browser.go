@@ -0,0 +1,314 @@
+package ultraViolet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BrowserHostName is the name of the native messaging host the browser
+// extension in extras/browser connects to.
+const BrowserHostName = "io.github.aimof.ultra_violet"
+
+// browserStateRefresh is how often a BrowserHost saves the tabs again
+// while they don't change, and browserStateTimeout the age after which
+// the BrowserCollector ignores them, such as those left behind by a
+// browser that crashed.
+var browserStateRefresh = time.Minute
+
+const browserStateTimeout = 5 * time.Minute
+
+// maxBrowserMessage is the size above which messages from the browser
+// extension are rejected; they hold a handful of URLs.
+const maxBrowserMessage = 1 << 20
+
+// BrowserTab is the active tab of a browser window, as reported by the
+// browser extension.
+type BrowserTab struct {
+	// Window is the browser's ID of the window, which is unrelated to
+	// the IDs of Windows.
+	Window  int    `json:"window"`
+	Focused bool   `json:"focused"`
+	URL     string `json:"url"`
+	Title   string `json:"title"`
+}
+
+// browserMessage is a message of the browser extension. It lists the
+// active tab of every window, and is sent whenever one of them changes.
+type browserMessage struct {
+	Tabs []BrowserTab `json:"tabs"`
+}
+
+// browserState is what a BrowserHost saves for the BrowserCollector.
+type browserState struct {
+	// PID is the ID of the browser process, which owns its windows.
+	PID  int
+	Time time.Time
+	Tabs []BrowserTab
+}
+
+// BrowserStateDir returns the directory where BrowserHosts save the tabs
+// of the browsers by default, creating it if needed (see privateDir).
+func BrowserStateDir() (string, error) {
+	dir := runtimeDir()
+	return dir, privateDir(dir)
+}
+
+// runtimeDir returns the directory for the files uv shares between its
+// processes: $XDG_RUNTIME_DIR/ultra-violet, or a directory of the user's
+// in the temporary directory.
+func runtimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "ultra-violet")
+	}
+	return filepath.Join(os.TempDir(), "ultra-violet-"+strconv.Itoa(os.Getuid()))
+}
+
+// privateDir creates dir if needed, and returns an error unless it is a
+// directory of the user's that only they can access: another user could
+// make the runtime directory first in the temporary directory, to read
+// the files uv shares there or plant some.
+func privateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if err := checkPrivateDir(dir, fi); err != nil {
+		return fmt.Errorf("refusing the runtime directory: %s", err)
+	}
+	return nil
+}
+
+// BrowserHost is the native messaging host of the browser extension in
+// extras/browser. The browser starts it and sends it the active tabs of
+// its windows, which it saves in Dir for the BrowserCollector.
+type BrowserHost struct {
+	// Dir is where the tabs are saved, in a file named after PID.
+	Dir string
+	// PID is the ID of the browser process, which started the host.
+	PID int
+}
+
+// Serve reads messages from the browser on r and answers them on w
+// following the native messaging protocol, until r is closed. The saved
+// tabs are removed when it returns.
+func (h *BrowserHost) Serve(r io.Reader, w io.Writer) error {
+	if err := os.MkdirAll(h.Dir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(h.Dir, fmt.Sprintf("browser-%d.json", h.PID))
+
+	// the tabs are saved again every browserStateRefresh, so that the
+	// BrowserCollector can tell them from those of a crashed browser.
+	var (
+		mu    sync.Mutex
+		tabs  []BrowserTab
+		saved bool
+		done  bool
+	)
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(browserStateRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				mu.Lock()
+				if saved && !done {
+					h.save(path, tabs)
+				}
+				mu.Unlock()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		mu.Lock()
+		done = true
+		os.Remove(path)
+		mu.Unlock()
+	}()
+
+	for {
+		b, err := readNativeMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reply := map[string]interface{}{"ok": true}
+		var m browserMessage
+		if err := json.Unmarshal(b, &m); err != nil {
+			reply = map[string]interface{}{"ok": false, "error": err.Error()}
+		} else {
+			mu.Lock()
+			err := h.save(path, m.Tabs)
+			tabs, saved = m.Tabs, err == nil
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		if err := writeNativeMessage(w, reply); err != nil {
+			return err
+		}
+	}
+}
+
+// save replaces the tabs saved in path, through a temporary file so that
+// the BrowserCollector never reads half a file.
+func (h *BrowserHost) save(path string, tabs []BrowserTab) error {
+	b, err := json.Marshal(&browserState{PID: h.PID, Time: time.Now(), Tabs: tabs})
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readNativeMessage reads a message of the native messaging protocol: its
+// length as a 32-bit integer in native byte order (little endian on all
+// the platforms uv supports), followed by as much JSON.
+func readNativeMessage(r io.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if n > maxBrowserMessage {
+		return nil, fmt.Errorf("browser: message of %d bytes is too long", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// writeNativeMessage writes v as a message of the native messaging
+// protocol.
+func writeNativeMessage(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	msg := make([]byte, 4, 4+len(b))
+	binary.LittleEndian.PutUint32(msg, uint32(len(b)))
+	_, err = w.Write(append(msg, b...))
+	return err
+}
+
+// BrowserCollector fills in the URL of browser windows from the tabs
+// saved by BrowserHosts. A window gets the URL of the active tab whose
+// title starts the window's name (browsers append their name to it), of
+// the browser whose process owns the window if its PID is known. Tabs
+// saved more than browserStateTimeout ago are ignored.
+type BrowserCollector struct {
+	// Dir is the directory of the saved tabs. If empty,
+	// BrowserStateDir() is used.
+	Dir string
+}
+
+var _ Collector = (*BrowserCollector)(nil)
+
+func (c *BrowserCollector) Collect(snap *Snapshot) error {
+	dir := c.Dir
+	if dir == "" {
+		var err error
+		if dir, err = BrowserStateDir(); err != nil {
+			return err
+		}
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "browser-*.json"))
+	if err != nil {
+		return err
+	}
+	var states []*browserState
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// the browser just exited.
+				continue
+			}
+			return err
+		}
+		var state browserState
+		if err := json.Unmarshal(b, &state); err != nil {
+			return fmt.Errorf("browser: invalid tabs in %s: %s", path, err)
+		}
+		if time.Since(state.Time) > browserStateTimeout {
+			continue
+		}
+		states = append(states, &state)
+	}
+	if len(states) == 0 {
+		return nil
+	}
+
+	for _, w := range snap.Windows {
+		var best *BrowserTab
+		for _, state := range states {
+			if w.PID > 0 && state.PID > 0 && w.PID != state.PID {
+				continue
+			}
+			for i, tab := range state.Tabs {
+				if tab.Title == "" || !strings.HasPrefix(w.Name, tab.Title) {
+					continue
+				}
+				if best == nil || len(tab.Title) > len(best.Title) {
+					best = &state.Tabs[i]
+				}
+			}
+		}
+		if best != nil {
+			w.URL = best.URL
+		}
+	}
+	return nil
+}
+
+// Domain returns the host name of the URL of w without "www.", or "" if
+// it has none.
+func (w *Window) Domain() string {
+	if w.URL == "" {
+		return ""
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Hostname(), "www.")
+}
+
+// DomainLabel is a label function for NewTimeline and NewAggTime that
+// labels browser windows after the domain of their URL, and other
+// windows after their application.
+func DomainLabel(w *Window) string {
+	if w != nil {
+		if domain := w.Domain(); domain != "" {
+			return domain
+		}
+	}
+	return appID(w)
+}
@@ -0,0 +1,245 @@
+package ultraViolet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// browserHostHarness runs a BrowserHost on pipes, as the browser does on
+// its stdin and stdout.
+type browserHostHarness struct {
+	t      *testing.T
+	stdin  *io.PipeWriter
+	stdout *io.PipeReader
+	done   chan error
+}
+
+func newBrowserHostHarness(t *testing.T, h *BrowserHost) *browserHostHarness {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	harness := &browserHostHarness{t: t, stdin: inW, stdout: outR, done: make(chan error, 1)}
+	go func() {
+		err := h.Serve(inR, outW)
+		outW.Close()
+		harness.done <- err
+	}()
+	return harness
+}
+
+// send sends a raw message and returns the reply.
+func (h *browserHostHarness) send(msg string) map[string]interface{} {
+	if err := binary.Write(h.stdin, binary.LittleEndian, uint32(len(msg))); err != nil {
+		h.t.Fatal(err)
+	}
+	if _, err := io.WriteString(h.stdin, msg); err != nil {
+		h.t.Fatal(err)
+	}
+	b, err := readNativeMessage(h.stdout)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	var reply map[string]interface{}
+	if err := json.Unmarshal(b, &reply); err != nil {
+		h.t.Fatal(err)
+	}
+	return reply
+}
+
+// close closes the host's stdin, as the browser does when it exits.
+func (h *browserHostHarness) close() error {
+	h.stdin.Close()
+	return <-h.done
+}
+
+func TestBrowserHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uv-browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d time.Duration) { browserStateRefresh = d }(browserStateRefresh)
+	browserStateRefresh = 10 * time.Millisecond
+
+	h := newBrowserHostHarness(t, &BrowserHost{Dir: dir, PID: 4242})
+	if reply := h.send(`{"tabs":[{"window":1,"focused":true,"url":"https://www.example.org/a","title":"Example"}]}`); reply["ok"] != true {
+		t.Errorf("reply: %v", reply)
+	}
+	if reply := h.send(`{"tabs":`); reply["ok"] != false || reply["error"] == nil {
+		t.Errorf("invalid reply: %v", reply)
+	}
+
+	path := filepath.Join(dir, "browser-4242.json")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state browserState
+	if err := json.Unmarshal(b, &state); err != nil {
+		t.Fatal(err)
+	}
+	expected := []BrowserTab{{Window: 1, Focused: true, URL: "https://www.example.org/a", Title: "Example"}}
+	if state.PID != 4242 || !reflect.DeepEqual(state.Tabs, expected) {
+		t.Errorf("state: %+v", state)
+	}
+
+	// the unchanged tabs are saved again.
+	time.Sleep(50 * time.Millisecond)
+	var again browserState
+	if b, err := ioutil.ReadFile(path); err != nil || json.Unmarshal(b, &again) != nil || !again.Time.After(state.Time) || !reflect.DeepEqual(again.Tabs, expected) {
+		t.Errorf("refreshed state: %+v %v", again, err)
+	}
+
+	if err := h.close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("state left behind: %v", err)
+	}
+}
+
+func TestBrowserHostTooLong(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uv-browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	in := bytes.NewReader([]byte{0xff, 0xff, 0xff, 0x7f})
+	if err := (&BrowserHost{Dir: dir, PID: 1}).Serve(in, ioutil.Discard); err == nil {
+		t.Error("no error")
+	}
+	in = bytes.NewReader([]byte{10, 0, 0, 0, '{'})
+	if err := (&BrowserHost{Dir: dir, PID: 1}).Serve(in, ioutil.Discard); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated: %v", err)
+	}
+}
+
+func TestBrowserCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uv-browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	chrome := &BrowserHost{Dir: dir, PID: 100}
+	if err := chrome.save(filepath.Join(dir, "browser-100.json"), []BrowserTab{
+		{Window: 1, Focused: true, URL: "https://github.com/aimof/ultra-violet", Title: "aimof/ultra-violet"},
+		{Window: 2, URL: "https://github.com/aimof/ultra-violet/issues", Title: "aimof/ultra-violet issues"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	firefox := &BrowserHost{Dir: dir, PID: 200}
+	if err := firefox.save(filepath.Join(dir, "browser-200.json"), []BrowserTab{
+		{Window: 1, Focused: true, URL: "https://www.example.org/", Title: "Example Domain"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the tabs of a browser that crashed an hour ago.
+	stale, _ := json.Marshal(&browserState{PID: 300, Time: time.Now().Add(-time.Hour), Tabs: []BrowserTab{{Window: 1, Focused: true, URL: "https://stale.example.org/", Title: "Example Domain"}}})
+	if err := ioutil.WriteFile(filepath.Join(dir, "browser-300.json"), stale, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := &Snapshot{Windows: []*Window{
+		&Window{ID: 1, Name: "aimof/ultra-violet - Google Chrome", PID: 100},
+		&Window{ID: 2, Name: "aimof/ultra-violet issues - Google Chrome", PID: 100},
+		&Window{ID: 3, Name: "Example Domain — Mozilla Firefox", PID: 200},
+		// the title of a Firefox tab in a window of another process.
+		&Window{ID: 4, Name: "Example Domain - Notes", PID: 300},
+		// PID unknown.
+		&Window{ID: 5, Name: "Example Domain — Mozilla Firefox"},
+	}}
+	if err := (&BrowserCollector{Dir: dir}).Collect(snap); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"https://github.com/aimof/ultra-violet",
+		"https://github.com/aimof/ultra-violet/issues",
+		"https://www.example.org/",
+		"",
+		"https://www.example.org/",
+	}
+	for i, w := range snap.Windows {
+		if w.URL != expected[i] {
+			t.Errorf("window%d: %q", w.ID, w.URL)
+		}
+	}
+
+	if err := (&BrowserCollector{Dir: filepath.Join(dir, "none")}).Collect(snap); err != nil {
+		t.Errorf("no tabs: %v", err)
+	}
+}
+
+func TestPrivateDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file modes")
+	}
+	tmp, err := ioutil.TempDir("", "uv-runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer setenv("XDG_RUNTIME_DIR", tmp)()
+
+	dir, err := BrowserStateDir()
+	if err != nil || dir != filepath.Join(tmp, "ultra-violet") {
+		t.Fatalf("dir: %s %v", dir, err)
+	}
+	if fi, err := os.Stat(dir); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("mode: %v %v", fi.Mode(), err)
+	}
+
+	// made by someone else beforehand, readable by others.
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := privateDir(dir); err == nil {
+		t.Error("directory readable by others")
+	}
+	// a link to another directory.
+	os.Remove(dir)
+	other := filepath.Join(tmp, "other")
+	if err := os.Mkdir(other, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(other, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := privateDir(dir); err == nil {
+		t.Error("symbolic link")
+	}
+}
+
+func TestWindowDomain(t *testing.T) {
+	cases := []struct {
+		url      string
+		expected string
+	}{
+		{"https://www.example.org/a?b", "example.org"},
+		{"http://localhost:8080/", "localhost"},
+		{"about:blank", ""},
+		{"", ""},
+	}
+	for i, c := range cases {
+		w := &Window{Name: "Page - Mozilla Firefox", URL: c.url}
+		if w.Domain() != c.expected {
+			t.Errorf("case%d: %s", i, w.Domain())
+		}
+		if label := DomainLabel(w); c.expected != "" && label != c.expected || c.expected == "" && label != "Mozilla Firefox" {
+			t.Errorf("case%d: label %s", i, label)
+		}
+		if w.Info().SubApp != c.expected {
+			t.Errorf("case%d: info %v", i, w.Info())
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/aimof/ultra-violet"
+)
+
+// BrowserHostCmd is the subcommand started by the browser as the native
+// messaging host of the extension in extras/browser.
+type BrowserHostCmd struct {
+	Dir string `long:"dir" description:"directory where the tabs are saved for uv track and uv daemon (default: $XDG_RUNTIME_DIR/ultra-violet)"`
+}
+
+var browserHostCmd BrowserHostCmd
+
+// Execute serves the browser on stdin and stdout. The arguments the
+// browser passes (the extension's origin or ID) are ignored.
+func (c *BrowserHostCmd) Execute(args []string) error {
+	// stdout carries the replies to the browser.
+	log.SetOutput(os.Stderr)
+	dir := c.Dir
+	if dir == "" {
+		var err error
+		if dir, err = ultraViolet.BrowserStateDir(); err != nil {
+			return err
+		}
+	}
+	h := &ultraViolet.BrowserHost{Dir: dir, PID: os.Getppid()}
+	return h.Serve(os.Stdin, os.Stdout)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/aimof/ultra-violet"
+)
+
+// ConvertCmd is the subcommand that rewrites a data file in another
+// format.
+type ConvertCmd struct {
+	In       string `long:"in" short:"i" description:"input file" required:"true"`
+	Out      string `long:"out" short:"o" description:"output file (default: stdout)"`
+	Format   string `long:"format" short:"f" description:"format of the output file (json, delta)" default:"delta"`
+	Keyframe int    `long:"keyframe-interval" description:"snapshots between two keyframes in the delta format" default:"120"`
+}
+
+var convertCmd ConvertCmd
+
+func (c *ConvertCmd) Execute(args []string) error {
+	if _, err := ultraViolet.NewEncoder(nil, c.Format); err != nil {
+		return err
+	}
+	in, err := os.Open(c.In)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, tmp := os.Stdout, c.Out+".convert"
+	if c.Out != "" {
+		// however it is named, the input file must not be replaced.
+		inInfo, err := in.Stat()
+		if err != nil {
+			return err
+		}
+		if outInfo, err := os.Stat(c.Out); err == nil && os.SameFile(inInfo, outInfo) {
+			return errors.New("the output file must not be the input file")
+		}
+		// the output file is only replaced once it is complete.
+		if out, err = os.Create(tmp); err != nil {
+			return err
+		}
+		defer os.Remove(tmp)
+		defer out.Close()
+	}
+	enc, err := ultraViolet.NewEncoder(out, c.Format)
+	if err != nil {
+		return err
+	}
+	if delta, ok := enc.(*ultraViolet.DeltaEncoder); ok {
+		delta.KeyframeInterval = c.Keyframe
+	}
+
+	dec := ultraViolet.NewDecoder(in)
+	dec.Skip = func(err *ultraViolet.LineError) {
+		err.File = c.In
+		warnSkipped(err)
+	}
+	for {
+		snap, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(snap); err != nil {
+			return err
+		}
+	}
+	if c.Out == "" {
+		return nil
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.Out)
+}
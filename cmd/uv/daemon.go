@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/aimof/ultra-violet"
+	"github.com/aimof/ultra-violet/storage"
+)
+
+// DaemonCmd is the subcommand that keeps a single Tracker alive and
+// samples application usage until it receives SIGINT or SIGTERM.
+type DaemonCmd struct {
+	Dir      string        `long:"dir" short:"d" description:"data directory" default:"."`
+	Interval time.Duration `long:"interval" short:"n" description:"time between two samples" default:"30s"`
+	Jitter   time.Duration `long:"jitter" description:"maximum random delay added to every interval" default:"5s"`
+	Events   bool          `long:"events" short:"e" description:"record a snapshot whenever the focus or a window title changes; --interval becomes the heartbeat"`
+	Tracker  string        `long:"tracker" short:"t" description:"tracker to use instead of the one detected from the session"`
+	Display  []string      `long:"display" description:"X display to track instead of $DISPLAY; repeat to track several displays (linux tracker)"`
+
+	IdleThreshold time.Duration `long:"idle-threshold" description:"time without keyboard or mouse input after which you are idle" default:"5m"`
+	Tmux          bool          `long:"tmux" description:"record the tmux session, window and pane command of the focused terminal"`
+	Timeout       time.Duration `long:"timeout" description:"time after which a sample is abandoned" default:"30s"`
+	Editor        string        `long:"editor" description:"unix socket or loopback host:port to receive editor heartbeats on, or none (default: $XDG_RUNTIME_DIR/ultra-violet/editor.sock)"`
+	Format        string        `long:"format" short:"f" description:"format of the data files (json, delta) (default: the format each file is in, delta for new files)"`
+	Fsync         string        `long:"fsync" description:"sync the data files to disk: always, never, or at most every interval such as 10m; snapshots are synced to a write-ahead log meanwhile" default:"10m"`
+
+	collectors []ultraViolet.Collector
+	markers    chan *ultraViolet.Snapshot
+}
+
+var daemonCmd DaemonCmd
+
+func (c *DaemonCmd) Execute(args []string) error {
+	if c.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	if c.Timeout <= 0 {
+		return errors.New("timeout must be positive")
+	}
+	dir, err := filepath.Abs(c.Dir)
+	if err != nil {
+		return err
+	}
+	store, err := storage.Open(dir)
+	if err != nil {
+		return err
+	}
+	if store.Sync, err = ultraViolet.ParseSyncPolicy(c.Fsync); err != nil {
+		return err
+	}
+	w, err := store.NewWriter(c.Format)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	t, err := getTracker(c.Tracker, c.Display)
+	if err != nil {
+		return err
+	}
+	if closer, ok := t.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if !isPlayback(c.Tracker) {
+		c.collectors = getCollectors(c.Display, c.IdleThreshold, c.Tmux)
+	}
+	if c.Editor != "none" && !isPlayback(c.Tracker) {
+		editor, err := c.listenEditor()
+		if err != nil {
+			// editor plugins are optional; keep sampling without them.
+			log.Println(err)
+		} else {
+			c.collectors = append(c.collectors, editor)
+		}
+	}
+	for _, collector := range c.collectors {
+		if closer, ok := collector.(io.Closer); ok {
+			defer closer.Close()
+		}
+	}
+	stopEvents := make(chan struct{})
+	defer close(stopEvents)
+	c.watchEvents(stopEvents)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	if c.Events {
+		watcher, ok := t.(ultraViolet.Watcher)
+		if !ok {
+			return errors.New("the tracker does not support --events")
+		}
+		return c.watch(watcher, t, w, sig)
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	timer := time.NewTimer(0)
+	for {
+		select {
+		case <-timer.C:
+			if err := c.sample(t, w); err == ultraViolet.ErrPlaybackDone {
+				return w.Close()
+			} else if err != nil {
+				log.Println(err)
+			}
+			timer.Reset(c.next(rnd))
+		case marker := <-c.markers:
+			if err := w.Write(marker); err != nil {
+				log.Println(err)
+			}
+			if marker.Event == ultraViolet.EventUnlock || marker.Event == ultraViolet.EventResume {
+				// don't wait for the next interval to see what the
+				// user came back to.
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(0)
+			}
+		case s := <-sig:
+			log.Printf("received %s, taking a last snapshot", s)
+			timer.Stop()
+			if err := c.sample(t, w); err != nil && err != ultraViolet.ErrPlaybackDone {
+				log.Println(err)
+			}
+			return w.Close()
+		}
+	}
+}
+
+// watch writes the snapshots sent by watcher until a signal is received.
+func (c *DaemonCmd) watch(watcher ultraViolet.Watcher, t ultraViolet.Tracker, w *storage.Writer, sig <-chan os.Signal) error {
+	snaps := make(chan *ultraViolet.Snapshot)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- watcher.Watch(snaps, c.Interval, stop) }()
+	for {
+		select {
+		case snap := <-snaps:
+			ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+			collect(ctx, snap, c.collectors)
+			cancel()
+			if err := w.Write(snap); err != nil {
+				log.Println(err)
+			}
+		case marker := <-c.markers:
+			if err := w.Write(marker); err != nil {
+				log.Println(err)
+			}
+		case err := <-done:
+			if err == ultraViolet.ErrPlaybackDone {
+				return w.Close()
+			}
+			if err == nil {
+				err = errors.New("the tracker stopped watching")
+			}
+			return err
+		case s := <-sig:
+			log.Printf("received %s, taking a last snapshot", s)
+			close(stop)
+			if err := <-done; err != nil {
+				log.Println(err)
+			}
+			if err := c.sample(t, w); err != nil {
+				log.Println(err)
+			}
+			return w.Close()
+		}
+	}
+}
+
+// listenEditor starts receiving editor heartbeats on --editor, or else on
+// the default socket.
+func (c *DaemonCmd) listenEditor() (*ultraViolet.EditorServer, error) {
+	addr := c.Editor
+	if addr == "" {
+		var err error
+		if addr, err = ultraViolet.EditorSocketPath(); err != nil {
+			return nil, err
+		}
+	}
+	editor := &ultraViolet.EditorServer{}
+	if err := editor.Listen(addr); err != nil {
+		return nil, err
+	}
+	return editor, nil
+}
+
+// watchEvents starts watching the events of the collectors that support
+// it. Their markers are sent to c.markers until stop is closed.
+func (c *DaemonCmd) watchEvents(stop <-chan struct{}) {
+	c.markers = make(chan *ultraViolet.Snapshot)
+	for _, collector := range c.collectors {
+		if watcher, ok := collector.(ultraViolet.EventWatcher); ok {
+			go func() {
+				if err := watcher.WatchEvents(c.markers, stop); err != nil {
+					log.Printf("not recording lock and sleep events: %s", err)
+				}
+			}()
+		}
+	}
+}
+
+// next returns the delay until the next sample.
+func (c *DaemonCmd) next(rnd *rand.Rand) time.Duration {
+	if c.Jitter <= 0 {
+		return c.Interval
+	}
+	return c.Interval + time.Duration(rnd.Int63n(int64(c.Jitter)+1))
+}
+
+// sample takes a snapshot with t and hands it to w. If t fails while the
+// screen is locked (as some trackers do on the lock screen), a snapshot
+// without windows records that the screen was locked.
+func (c *DaemonCmd) sample(t ultraViolet.Tracker, w *storage.Writer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	snap, err := ultraViolet.SnapContext(ctx, t)
+	if err != nil {
+		locked := &ultraViolet.Snapshot{Time: time.Now()}
+		collect(ctx, locked, c.collectors)
+		if !locked.Locked {
+			return err
+		}
+		return w.Write(locked)
+	}
+	collect(ctx, snap, c.collectors)
+	return w.Write(snap)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aimof/ultra-violet"
+	"github.com/aimof/ultra-violet/storage"
+)
+
+// FsckCmd is the subcommand that checks data files for lines that can't
+// be read, and sets them aside.
+type FsckCmd struct {
+	Dir    string `long:"dir" short:"d" description:"data directory of uv daemon whose files to check, besides the files given"`
+	Repair bool   `long:"repair" description:"rewrite the files with broken lines without them, moving the lines to FILE.quarantine"`
+	Format string `long:"format" short:"f" description:"format of the repaired files (json, delta); by default, each file keeps its format"`
+}
+
+var fsckCmd FsckCmd
+
+func (c *FsckCmd) Execute(args []string) error {
+	if c.Format != "" {
+		if _, err := ultraViolet.NewEncoder(nil, c.Format); err != nil {
+			return err
+		}
+	}
+	files := args
+	if c.Dir != "" {
+		store, err := storage.Open(c.Dir)
+		if err != nil {
+			return err
+		}
+		segments, err := store.Segments(time.Time{}, time.Time{})
+		if err != nil {
+			return err
+		}
+		for _, seg := range segments {
+			files = append(files, seg.Path)
+		}
+	}
+	if len(files) == 0 {
+		return errors.New("no data files to check; give their paths or --dir")
+	}
+
+	broken := 0
+	for _, path := range files {
+		report, err := checkFile(path)
+		if err != nil {
+			return err
+		}
+		for _, err := range report.Errors {
+			fmt.Println(err)
+		}
+		fmt.Printf("%s: %d snapshots, %d broken lines\n", path, report.Snapshots, len(report.Errors))
+		if len(report.Errors) == 0 {
+			continue
+		}
+		broken++
+		if c.Repair {
+			if _, err := ultraViolet.RepairFile(path, c.Format); err != nil {
+				return err
+			}
+			// the index of a segment locates lines by their offsets.
+			if err := os.Remove(path + ".idx"); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			fmt.Printf("%s: repaired; the broken lines are in %s.quarantine\n", path, path)
+		}
+	}
+	if broken > 0 && !c.Repair {
+		return fmt.Errorf("%d of %d files have broken lines; run uv fsck --repair to set them aside", broken, len(files))
+	}
+	return nil
+}
+
+// checkFile checks the data file in path.
+func checkFile(path string) (*ultraViolet.CheckReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	report, err := ultraViolet.Check(f, nil, "", nil)
+	for _, lineErr := range report.Errors {
+		lineErr.File = path
+	}
+	if lineErr, ok := err.(*ultraViolet.LineError); ok {
+		lineErr.File = path
+	}
+	return report, err
+}
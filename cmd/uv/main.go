@@ -1,17 +1,19 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"runtime"
+	"strings"
 	"time"
 
 	"github.com/aimof/ultra-violet"
+	"github.com/aimof/ultra-violet/storage"
 	"github.com/jessevdk/go-flags"
 )
 
@@ -33,19 +35,35 @@ Example usage:
 
   uv dep
   uv track -o <file>
+  uv track --tracker i3
+  uv daemon -d <dir>
   uv show  -i <file> -w stats > viz.html
+  uv show  -d <dir> --since 2018-01-01 --until 2018-01-31 -w stats > viz.html
+  uv fsck  -d <dir> --repair
 
 `
 
 	if _, err := CLI.AddCommand("track", "record current windows", "Record current window metadata as JSON printed to stdout or a file. If a filename is specified and the file already exists, Thyme will append the new snapshot data to the existing data.", &trackCmd); err != nil {
 		log.Fatal(err)
 	}
+	if _, err := CLI.AddCommand("daemon", "keep recording windows", "Keep sampling the current windows at a fixed interval until interrupted. Snapshots are appended to one JSON file per day under <dir>/data/YYYY/MM/DD.json, the same layout used by `uv watch`.", &daemonCmd); err != nil {
+		log.Fatal(err)
+	}
 	if _, err := CLI.AddCommand("show", "visualize data", "Generate an HTML page visualizing the data from a file written to by `uv track`.", &showCmd); err != nil {
 		log.Fatal(err)
 	}
+	if _, err := CLI.AddCommand("convert", "convert data files", "Rewrite a data file written by `uv track` or `uv daemon` in another format: json writes every snapshot in full, delta only the windows that changed since the previous one.", &convertCmd); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := CLI.AddCommand("fsck", "check data files", "Check data files written by `uv track` or `uv daemon` for lines that can't be read, e.g. cut short by a crash, and report them by line number. With --repair, the files are rewritten without them, and the lines are set aside in FILE.quarantine.", &fsckCmd); err != nil {
+		log.Fatal(err)
+	}
 	if _, err := CLI.AddCommand("dep", "dep install instructions", "Show installation instructions for required external dependencies (which vary depending on your OS and windowing system).", &depCmd); err != nil {
 		log.Fatal(err)
 	}
+	if _, err := CLI.AddCommand("browser-host", "browser extension host", "Receive the active tabs of a browser from the extension in extras/browser, so that snapshots record the URLs of browser windows. The browser starts this command; see extras/browser/README.md.", &browserHostCmd); err != nil {
+		log.Fatal(err)
+	}
 	if _, err := CLI.AddCommand("watch", "'Friend Copmputer' is watching you", "Allow 'Friend Computer' to watch your activities. Happiness is Mandatory.", &watchCmd); err != nil {
 		log.Fatal(err)
 	}
@@ -53,25 +71,49 @@ Example usage:
 
 // TrackCmd is the subcommand that tracks application usage.
 type TrackCmd struct {
-	Out string `long:"out" short:"o" description:"output file"`
+	Out     string   `long:"out" short:"o" description:"output file"`
+	Tracker string   `long:"tracker" short:"t" description:"tracker to use instead of the one detected from the session (x11, i3, hyprland, gnome, kwin, linux, darwin, replay, synthetic)"`
+	Display []string `long:"display" description:"X display to track instead of $DISPLAY; repeat to track several displays (linux tracker)"`
+
+	IdleThreshold time.Duration `long:"idle-threshold" description:"time without keyboard or mouse input after which you are idle" default:"5m"`
+	Tmux          bool          `long:"tmux" description:"record the tmux session, window and pane command of the focused terminal"`
+	Timeout       time.Duration `long:"timeout" description:"time after which taking the snapshot is abandoned" default:"30s"`
+	Format        string        `long:"format" short:"f" description:"format of the output file (json, delta) (default: the format the file is in, delta for a new file)"`
+	Fsync         string        `long:"fsync" description:"sync the output file to disk: always or never" default:"always"`
 }
 
 var trackCmd TrackCmd
 
 func (c *TrackCmd) Execute(args []string) error {
-	err := track(c.Out)
+	if c.Timeout <= 0 {
+		return errors.New("timeout must be positive")
+	}
+	fsync, err := ultraViolet.ParseSyncPolicy(c.Fsync)
+	if err != nil {
+		return err
+	}
+	err = track(c.Tracker, c.Display, c.IdleThreshold, c.Tmux, c.Timeout, c.Out, c.Format, fsync)
 	return err
 }
 
-func track(outFile string) error {
-	t, err := getTracker()
+func track(trackerName string, displays []string, idleThreshold time.Duration, tmux bool, timeout time.Duration, outFile, format string, fsync ultraViolet.SyncPolicy) error {
+	t, err := getTracker(trackerName, displays)
 	if err != nil {
 		return err
 	}
-	snap, err := t.Snap()
+	// e.g. the kwin tracker unloads its script.
+	if closer, ok := t.(io.Closer); ok {
+		defer closer.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	snap, err := ultraViolet.SnapContext(ctx, t)
 	if err != nil {
 		return err
 	}
+	if !isPlayback(trackerName) {
+		collect(ctx, snap, getCollectors(displays, idleThreshold, tmux))
+	}
 
 	if outFile == "" {
 		out, err := json.MarshalIndent(snap, "", "  ")
@@ -80,24 +122,17 @@ func track(outFile string) error {
 		}
 		fmt.Println(string(out))
 	} else {
-		f, err := os.OpenFile(outFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		// other uv processes may be appending to the same file.
+		f, err := ultraViolet.OpenAppendFile(outFile, format)
 		if err != nil {
 			return err
 		}
-		defer f.Close()
-
-		b, err := json.Marshal(snap)
-		if err != nil {
-			return err
-		}
-
-		if _, err = f.Write(b); err != nil {
-			return err
-		}
-
-		if _, err = f.WriteString("\n"); err != nil {
+		f.Fsync = fsync
+		if err := f.Append(snap); err != nil {
+			f.Close()
 			return err
 		}
+		return f.Close()
 	}
 
 	return nil
@@ -105,7 +140,9 @@ func track(outFile string) error {
 
 // WatchCmd allows your 'Friend Computer' watch your activities.
 type WatchCmd struct {
-	Dir string `long:"dir" short:"d" description:"data and log directory"`
+	Dir     string   `long:"dir" short:"d" description:"data and log directory"`
+	Tracker string   `long:"tracker" short:"t" description:"tracker to use instead of the one detected from the session"`
+	Display []string `long:"display" description:"X display to track instead of $DISPLAY; repeat to track several displays (linux tracker)"`
 }
 
 var watchCmd WatchCmd
@@ -127,30 +164,32 @@ func (c *WatchCmd) Execute(args []string) error {
 		log.Fatalln(err)
 	}
 
-	t := time.Now()
-	dataDir := "./data/" + t.Format("2006/01")
-	if _, err = os.Stat(dataDir); err != nil {
-		if err = os.MkdirAll(dataDir, 0775); err != nil {
-			log.Fatalln(err)
-		}
+	dataFilePath, err := (&storage.Store{Dir: "."}).SegmentPath(time.Now())
+	if err != nil {
+		log.Fatalln(err)
 	}
-	dataFilePath := dataDir + t.Format("/02.json")
 
 	outFilePath := workDir + "/uv.html"
 
-	if err := track(dataFilePath); err != nil {
+	if err := track(c.Tracker, c.Display, 0, false, 30*time.Second, dataFilePath, "", ultraViolet.SyncAlways); err != nil {
 		return err
 	}
 
-	stream, err := readStream(dataFilePath)
+	in, err := os.Open(dataFilePath)
 	if err != nil {
 		return err
 	} else {
+		defer in.Close()
 		f, err := os.OpenFile(outFilePath, os.O_WRONLY|os.O_CREATE, 0644)
 		if err != nil {
 			return err
 		}
-		if err := ultraViolet.Stats(&stream, f); err != nil {
+		d := ultraViolet.NewDecoder(in)
+		d.Skip = func(err *ultraViolet.LineError) {
+			err.File = dataFilePath
+			log.Println(err)
+		}
+		if err := ultraViolet.StatsFrom(d, f); err != nil {
 			return err
 		}
 		f.Close()
@@ -162,14 +201,26 @@ func (c *WatchCmd) Execute(args []string) error {
 // ShowCmd is the subcommand that reads the data emitted by the track
 // subcommand and displays the data to the user.
 type ShowCmd struct {
-	In   string `long:"in" short:"i" description:"input file"`
-	What string `long:"what" short:"w" description:"what to show {list,stats}" default:"list"`
+	In    string `long:"in" short:"i" description:"input file"`
+	Dir   string `long:"dir" short:"d" description:"data directory of uv daemon to read instead of a file"`
+	Since string `long:"since" description:"show snapshots from this day (YYYY-MM-DD) or time (RFC 3339) on"`
+	Until string `long:"until" description:"show snapshots until the end of this day (YYYY-MM-DD) or until this time (RFC 3339)"`
+	What  string `long:"what" short:"w" description:"what to show {list,stats}" default:"list"`
 }
 
 var showCmd ShowCmd
 
 func (c *ShowCmd) Execute(args []string) error {
-	if c.In == "" {
+	from, err := parseTime(c.Since, false)
+	if err != nil {
+		return err
+	}
+	to, err := parseTime(c.Until, true)
+	if err != nil {
+		return err
+	}
+
+	if c.In == "" && c.Dir == "" {
 		var snap ultraViolet.Snapshot
 		if err := json.NewDecoder(os.Stdin).Decode(&snap); err != nil {
 			return err
@@ -178,52 +229,85 @@ func (c *ShowCmd) Execute(args []string) error {
 			fmt.Printf("%+v\n", w.Info())
 		}
 	} else {
-		stream, err := readStream(c.In)
-		if err != nil {
-			return err
+		// the snapshots are read as they are shown, so that months of
+		// them fit in memory.
+		var src ultraViolet.SnapshotSource
+		if c.Dir != "" {
+			store, err := storage.Open(c.Dir)
+			if err != nil {
+				return err
+			}
+			cursor, err := store.Cursor(from, to)
+			if err != nil {
+				return err
+			}
+			defer cursor.Close()
+			cursor.Skip = warnSkipped
+			src = cursor
+		} else {
+			f, err := os.Open(c.In)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			d := ultraViolet.NewDecoder(f)
+			d.Skip = func(err *ultraViolet.LineError) {
+				err.File = c.In
+				warnSkipped(err)
+			}
+			src = ultraViolet.Between(d, from, to)
 		}
 
 		switch c.What {
 		case "stats":
-			if err := ultraViolet.Stats(&stream, os.Stdout); err != nil {
+			if err := ultraViolet.StatsFrom(src, os.Stdout); err != nil {
 				return err
 			}
 		case "list":
 			fallthrough
 		default:
-			ultraViolet.List(&stream)
+			if err := ultraViolet.ListFrom(src); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
-func readStream(in string) (ultraViolet.Stream, error) {
-	var stream ultraViolet.Stream
-	stream.Snapshots = make([]*ultraViolet.Snapshot, 0, 4086)
-	f, err := os.Open(in)
-	if err != nil {
-		return stream, err
-	}
-	defer f.Close()
+// warnSkipped warns on stderr, out of the way of what is shown, that a
+// broken line of a data file is skipped.
+func warnSkipped(err *ultraViolet.LineError) {
+	fmt.Fprintf(os.Stderr, "skipping %s; see uv fsck\n", err)
+}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		var s *ultraViolet.Snapshot
-		err := json.Unmarshal(scanner.Bytes(), &s)
-		if err != nil {
-			return stream, err
+// parseTime parses the value of --since or --until: a day, which stands
+// for its start or, if end is set, its end, or a time. An empty value is
+// the zero time.
+func parseTime(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if day, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		if end {
+			day = day.AddDate(0, 0, 1)
 		}
-		stream.Snapshots = append(stream.Snapshots, s)
+		return day, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q; use YYYY-MM-DD or RFC 3339", s)
 	}
-	return stream, nil
+	return t, nil
 }
 
-type DepCmd struct{}
+type DepCmd struct {
+	Tracker string `long:"tracker" short:"t" description:"tracker to show the dependencies of instead of the one detected from the session"`
+}
 
 var depCmd DepCmd
 
 func (c *DepCmd) Execute(args []string) error {
-	t, err := getTracker()
+	t, err := getTracker(c.Tracker, nil)
 	if err != nil {
 		return err
 	}
@@ -231,6 +315,48 @@ func (c *DepCmd) Execute(args []string) error {
 	return nil
 }
 
+// getCollectors returns the Collectors that complete the Snapshots of
+// the Tracker. The TmuxCollector is only used if tmux is set.
+func getCollectors(displays []string, idleThreshold time.Duration, tmux bool) []ultraViolet.Collector {
+	idle := &ultraViolet.IdleCollector{Threshold: idleThreshold}
+	if len(displays) > 0 {
+		idle.Display = displays[0]
+	}
+	collectors := []ultraViolet.Collector{idle, &ultraViolet.LockCollector{}, &ultraViolet.ProcessCollector{}, &ultraViolet.TerminalCollector{}, &ultraViolet.BrowserCollector{}}
+	if tmux {
+		collectors = append(collectors, &ultraViolet.TmuxCollector{})
+	}
+	return collectors
+}
+
+// isPlayback reports whether the tracker called name plays back
+// Snapshots rather than reading the windows, in which case Collectors
+// would mix the current system into them.
+func isPlayback(name string) bool {
+	return name == "replay" || name == "synthetic"
+}
+
+// collectErrors holds the last error of each Collector, so that a
+// Collector that keeps failing (e.g. without logind) is logged once.
+var collectErrors = make(map[ultraViolet.Collector]string)
+
+// collect runs collectors on snap until ctx is done. Their errors are
+// logged rather than returned, since the windows are worth recording
+// anyway.
+func collect(ctx context.Context, snap *ultraViolet.Snapshot, collectors []ultraViolet.Collector) {
+	for _, c := range collectors {
+		err := ultraViolet.CollectContext(ctx, c, snap)
+		if err != nil && err.Error() != collectErrors[c] {
+			log.Println(err)
+		}
+		if err != nil {
+			collectErrors[c] = err.Error()
+		} else {
+			delete(collectErrors, c)
+		}
+	}
+}
+
 func main() {
 	run := func() error {
 		_, err := CLI.Parse()
@@ -251,15 +377,26 @@ func main() {
 	}
 }
 
-func getTracker() (ultraViolet.Tracker, error) {
-	switch runtime.GOOS {
-	case "linux":
-		return ultraViolet.NewTracker("linux")
-	case "darwin":
-		return ultraViolet.NewTracker("darwin")
-	default:
-		log.Println("Sorry, your OS is not supported.")
-		os.Exit(1)
-		return nil, errors.New("OS not supported.")
+// getTracker returns the Tracker called name, or the best Tracker for
+// the current session if name is empty. If displays are given, the
+// Tracker is set to track them.
+func getTracker(name string, displays []string) (ultraViolet.Tracker, error) {
+	var t ultraViolet.Tracker
+	var err error
+	if name == "" {
+		t, err = ultraViolet.DefaultTracker()
+	} else if t, err = ultraViolet.NewTracker(name); err != nil {
+		err = fmt.Errorf("unknown tracker %q; choose one of %s", name, strings.Join(ultraViolet.Trackers(), ", "))
+	}
+	if err != nil || len(displays) == 0 {
+		return t, err
+	}
+	dt, ok := t.(ultraViolet.DisplayTracker)
+	if !ok {
+		return nil, errors.New("the tracker does not support --display; try --tracker linux")
+	}
+	if err := dt.SetDisplays(displays); err != nil {
+		return nil, err
 	}
+	return t, nil
 }
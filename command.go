@@ -0,0 +1,36 @@
+package ultraViolet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// DefaultCommandTimeout is how long Trackers that run command-line
+// utilities wait for each of them by default.
+const DefaultCommandTimeout = 5 * time.Second
+
+// runCommand runs a command-line utility in env (or the environment of
+// the process if env is nil), with stdin as its input if not nil, and
+// returns its output. The utility is killed when ctx is done or after
+// timeout, whichever comes first.
+func runCommand(ctx context.Context, timeout time.Duration, env []string, stdin io.Reader, name string, args ...string) ([]byte, error) {
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(cmdCtx, name, args...)
+	cmd.Env = env
+	cmd.Stdin = stdin
+	out, err := cmd.Output()
+	if err != nil && cmdCtx.Err() != nil {
+		if ctx.Err() != nil {
+			return out, ctx.Err()
+		}
+		return out, fmt.Errorf("%s timed out after %s", name, timeout)
+	}
+	return out, err
+}
@@ -2,6 +2,7 @@ package ultraViolet
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"hash/fnv"
@@ -19,9 +20,14 @@ func init() {
 // API, the DarwinTracker will not be able to detect individual windows of applications that are not scriptable (in the
 // AppleScript sense). For these applications, a single window is emitted with the name set to the application process
 // name and the ID set to the process ID.
-type DarwinTracker struct{}
+type DarwinTracker struct {
+	// Timeout is how long each AppleScript snippet may run. If zero,
+	// DefaultCommandTimeout is used.
+	Timeout time.Duration
+}
 
 var _ Tracker = (*DarwinTracker)(nil)
+var _ ContextTracker = (*DarwinTracker)(nil)
 
 func NewDarwinTracker() Tracker {
 	return &DarwinTracker{}
@@ -98,14 +104,18 @@ Note: this command prints out this message regardless of whether this has been d
 }
 
 func (t *DarwinTracker) Snap() (*Snapshot, error) {
-	allProcWins, err := runAS(allWindowsScript)
+	return t.SnapContext(context.Background())
+}
+
+func (t *DarwinTracker) SnapContext(ctx context.Context) (*Snapshot, error) {
+	allProcWins, err := runAS(ctx, t.Timeout, allWindowsScript)
 	if err != nil {
 		return nil, err
 	}
 
 	allWindows := _snapAll(allProcWins)
 
-	procWinsActive, err := runAS(activeWindowsScript)
+	procWinsActive, err := runAS(ctx, t.Timeout, activeWindowsScript)
 	if err != nil {
 		return nil, err
 	}
@@ -115,7 +125,7 @@ func (t *DarwinTracker) Snap() (*Snapshot, error) {
 		return nil, err
 	}
 
-	procWinsVisible, err := runAS(visibleWindowsScript)
+	procWinsVisible, err := runAS(ctx, t.Timeout, visibleWindowsScript)
 	if err != nil {
 		return nil, err
 	}
@@ -194,12 +204,17 @@ type process struct {
 }
 
 // runAS runs script as AppleScript and parses the output into a map of
-// processes to windows.
-func runAS(script string) (map[process][]*Window, error) {
-	cmd := exec.Command("osascript")
-	cmd.Stdin = bytes.NewBuffer([]byte(script))
-	b, err := cmd.CombinedOutput()
+// processes to windows. osascript is killed after timeout or when ctx is
+// done.
+func runAS(ctx context.Context, timeout time.Duration, script string) (map[process][]*Window, error) {
+	b, err := runCommand(ctx, timeout, nil, bytes.NewBufferString(script), "osascript")
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if e, ok := err.(*exec.ExitError); ok {
+			b = append(b, e.Stderr...)
+		}
 		return nil, fmt.Errorf("AppleScript error: %s, output was:\n%s", err, string(b))
 	}
 	return parseASOutput(string(b))
@@ -2,8 +2,10 @@ package ultraViolet
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"strings"
 	"time"
 )
@@ -42,6 +44,156 @@ type Tracker interface {
 	Deps() string
 }
 
+// ContextTracker is implemented by Trackers that can give up on a
+// Snapshot when a context is done, rather than block on a hung window
+// system or utility.
+type ContextTracker interface {
+	// SnapContext is Snap that returns ctx.Err() once ctx is done.
+	SnapContext(ctx context.Context) (*Snapshot, error)
+}
+
+// SnapContext takes a Snapshot with t, giving up when ctx is done. Trackers
+// that don't implement ContextTracker are left running in the background
+// until their Snap returns.
+func SnapContext(ctx context.Context, t Tracker) (*Snapshot, error) {
+	if ct, ok := t.(ContextTracker); ok {
+		return ct.SnapContext(ctx)
+	}
+	type result struct {
+		snap *Snapshot
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		snap, err := t.Snap()
+		done <- result{snap, err}
+	}()
+	select {
+	case r := <-done:
+		return r.snap, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Watcher is implemented by Trackers that can report changes as they
+// happen instead of being sampled at a fixed interval.
+type Watcher interface {
+	// Watch sends a Snapshot to snaps whenever the active window or the
+	// title of a window changes, and at least once every heartbeat if
+	// nothing happens. Each Snapshot's Time is the time the change was
+	// observed. Watch returns when stop is closed or an error occurs.
+	Watch(snaps chan<- *Snapshot, heartbeat time.Duration, stop <-chan struct{}) error
+}
+
+// Collector adds to Snapshots what Trackers can't tell from the windows,
+// such as whether the user is idle.
+type Collector interface {
+	// Collect fills in the fields of snap the Collector is about.
+	Collect(snap *Snapshot) error
+}
+
+// ContextCollector is implemented by Collectors that can give up on a
+// Snapshot, such as those that run command-line utilities.
+type ContextCollector interface {
+	// CollectContext is like Collect but returns ctx.Err() once ctx is
+	// done.
+	CollectContext(ctx context.Context, snap *Snapshot) error
+}
+
+// CollectContext runs c on snap and returns ctx.Err() if ctx is done
+// first. Collectors that don't implement ContextCollector run on a copy
+// of snap, which replaces snap only if they finish in time, so that a
+// hung Collector can't change snap later on.
+func CollectContext(ctx context.Context, c Collector, snap *Snapshot) error {
+	if cc, ok := c.(ContextCollector); ok {
+		return cc.CollectContext(ctx, snap)
+	}
+	cp := *snap
+	// [:0:0] keeps nil slices nil.
+	cp.Windows = append(snap.Windows[:0:0], snap.Windows...)
+	for i, w := range cp.Windows {
+		wc := *w
+		cp.Windows[i] = &wc
+	}
+	cp.Visible = append(snap.Visible[:0:0], snap.Visible...)
+	cp.Monitors = append(snap.Monitors[:0:0], snap.Monitors...)
+	cp.Warnings = append(snap.Warnings[:0:0], snap.Warnings...)
+	done := make(chan error, 1)
+	go func() { done <- c.Collect(&cp) }()
+	select {
+	case err := <-done:
+		*snap = cp
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// EventWatcher is implemented by Collectors that also learn about events
+// between Snapshots, such as the screen being locked.
+type EventWatcher interface {
+	// WatchEvents sends a marker Snapshot (see Snapshot.Event) to events
+	// for every event until stop is closed or an error occurs.
+	WatchEvents(events chan<- *Snapshot, stop <-chan struct{}) error
+}
+
+// DisplayTracker is implemented by Trackers that can track X displays
+// other than $DISPLAY.
+type DisplayTracker interface {
+	// SetDisplays sets the displays to track, such as ":1" or
+	// "localhost:10.0".
+	SetDisplays(displays []string) error
+}
+
+// Prober is implemented by Trackers that rely on something the session
+// may lack, such as a compositor extension, and can tell cheaply whether
+// it is there.
+type Prober interface {
+	// Probe returns an error if the Tracker can't take Snapshots.
+	Probe() error
+}
+
+// watchSettle is how long Watchers wait for more events after a change
+// before taking a Snapshot, so that a burst of changes (e.g. a focus
+// switch that also updates a title) results in a single Snapshot.
+const watchSettle = 50 * time.Millisecond
+
+// watchLoop implements Watch for Trackers that learn about changes from
+// an event source but still need Snap to read the windows. A Snapshot is
+// sent right away, after every value received on changes and after every
+// heartbeat without changes. watchLoop returns when stop is closed or an
+// error is received on failed.
+func watchLoop(t Tracker, changes <-chan struct{}, failed <-chan error, snaps chan<- *Snapshot, heartbeat time.Duration, stop <-chan struct{}) error {
+	for at := time.Now(); ; {
+		snap, err := t.Snap()
+		if err != nil {
+			return err
+		}
+		snap.Time = at
+		select {
+		case snaps <- snap:
+		case <-stop:
+			return nil
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case err := <-failed:
+			return err
+		case at = <-time.After(heartbeat):
+		case <-changes:
+			at = time.Now()
+			time.Sleep(watchSettle)
+			select {
+			case <-changes:
+			default:
+			}
+		}
+	}
+}
+
 // Stream represents all the sampling data gathered by Thyme.
 type Stream struct {
 	// Snapshots is a list of window snapshots ordered by time.
@@ -64,6 +216,40 @@ type Snapshot struct {
 	Windows []*Window
 	Active  int
 	Visible []int
+	// Monitors are the monitors of the screen, if known.
+	Monitors []*Monitor `json:",omitempty"`
+
+	// Idle reports whether the user was away from the keyboard at Time.
+	Idle bool `json:",omitempty"`
+	// IdleTime is how long there had been no keyboard or mouse input
+	// at Time, if known.
+	IdleTime time.Duration `json:",omitempty"`
+	// Locked reports whether the screen was locked at Time.
+	Locked bool `json:",omitempty"`
+
+	// Event is set on markers, which record an event at Time instead of
+	// the windows: one of EventLock, EventUnlock, EventSleep and
+	// EventResume.
+	Event string `json:",omitempty"`
+
+	// Warnings describe what the Tracker couldn't find out, such as the
+	// details of a window that closed while it was being read. The rest
+	// of the Snapshot is still valid.
+	Warnings []string `json:",omitempty"`
+}
+
+// The events recorded by markers.
+const (
+	EventLock   = "lock"
+	EventUnlock = "unlock"
+	EventSleep  = "sleep"
+	EventResume = "resume"
+)
+
+// IsMarker reports whether s is a marker rather than a sample of the
+// windows.
+func (s Snapshot) IsMarker() bool {
+	return s.Event != ""
 }
 
 // Print returns a pretty-printed representation of the snapshot.
@@ -89,6 +275,18 @@ s_Windows:
 	}
 
 	fmt.Fprintf(&b, "%s\n", s.Time.Format("Mon Jan 2 15:04:05 -0700 MST 2006"))
+	if s.IsMarker() {
+		fmt.Fprintf(&b, "\tEvent: %s\n", s.Event)
+	}
+	if s.Locked {
+		fmt.Fprintf(&b, "\tLocked\n")
+	}
+	if s.Idle {
+		fmt.Fprintf(&b, "\tIdle: %s\n", s.IdleTime)
+	}
+	for _, warning := range s.Warnings {
+		fmt.Fprintf(&b, "\tWarning: %s\n", warning)
+	}
 	if active != nil {
 		fmt.Fprintf(&b, "\tActive: %s\n", active.Info().Print())
 	}
@@ -113,14 +311,65 @@ type Window struct {
 	ID int
 
 	// Desktop is the numerical identifier of the desktop the
-	// window belongs to.  Equal to -1 if the window is sticky.
+	// window belongs to.  Equal to -1 if the window is sticky, and
+	// to NoDesktop if it is on none of the numbered desktops.
 	Desktop int
 
 	// Name is the display name of the window (typically what the
 	// windowing system shows in the top bar of the window).
 	Name string
+
+	// Display is the X display the window is on, set only by Trackers
+	// that track several displays, which keep the IDs of their windows
+	// unique in the Snapshot.
+	Display string `json:",omitempty"`
+
+	// Instance and Class are the two parts of the X11 WM_CLASS property
+	// of the window, e.g. "Navigator" and "firefox". Wayland trackers
+	// set Class to the app ID of the window.
+	Instance string `json:",omitempty"`
+	Class    string `json:",omitempty"`
+
+	// Geometry is the position and size of the window, if known.
+	Geometry *Rect `json:",omitempty"`
+	// Monitor is the name of the monitor most of the window is on.
+	Monitor string `json:",omitempty"`
+	// VisibleArea is the fraction of the window that could be seen:
+	// on a monitor and not covered by other windows. It is only set
+	// for visible windows whose Geometry is known.
+	VisibleArea float64 `json:",omitempty"`
+
+	// PID is the ID of the process that owns the window, if known.
+	PID int `json:",omitempty"`
+	// Exe, Args and Cwd describe the process PID: the path of its
+	// executable, its command line and its working directory. They are
+	// filled in by the ProcessCollector.
+	Exe  string   `json:",omitempty"`
+	Args []string `json:",omitempty"`
+	Cwd  string   `json:",omitempty"`
+
+	// Foreground is the command in the foreground of a terminal
+	// emulator window, e.g. "vim", or of the active tmux pane in it. It
+	// is filled in by the TerminalCollector and becomes the SubApp of
+	// the window.
+	Foreground string `json:",omitempty"`
+	// Tmux is the tmux pane shown in the window. It is filled in by the
+	// TmuxCollector for the focused window only.
+	Tmux *TmuxPane `json:",omitempty"`
+	// URL is the address of the active tab of a browser window. It is
+	// filled in by the BrowserCollector and its domain becomes the
+	// SubApp of the window.
+	URL string `json:",omitempty"`
+	// Editor is what the editor shown in the window reported through
+	// the EditorServer, for the focused window only.
+	Editor *EditorContext `json:",omitempty"`
 }
 
+// NoDesktop is the Desktop of windows that are on no numbered desktop,
+// such as those of i3's named workspaces and scratchpad, which are on
+// none of the desktops IsOnDesktop is asked about.
+const NoDesktop = -2
+
 // IsSticky returns true if the window is a sticky window (i.e.
 // present on all desktops)
 func (w *Window) IsSticky() bool {
@@ -146,6 +395,18 @@ const (
 //     2) Most windows use the " - " with the application name at the end.
 //     3) The few programs that reverse this convention only reverse it.
 func (w *Window) Info() *Winfo {
+	wi := w.nameInfo()
+	if wi.SubApp == "" {
+		wi.SubApp = w.Foreground
+	}
+	if wi.SubApp == "" {
+		wi.SubApp = w.Domain()
+	}
+	return wi
+}
+
+// nameInfo returns the metadata of the window extracted from its name.
+func (w *Window) nameInfo() *Winfo {
 	// Special Cases
 	wi, isChrome := chromeInfo(w.Name)
 	if isChrome {
@@ -165,10 +426,15 @@ func (w *Window) Info() *Winfo {
 		return sepDefault(w.Name)
 	}
 
-	// No Application name separator
-	return &Winfo{
-		Title: w.Name,
+	// No Application name separator: name the application after its
+	// window class or else its executable, if known.
+	wi = &Winfo{Title: w.Name}
+	if w.Class != "" {
+		wi.App = w.Class
+	} else if w.Exe != "" {
+		wi.App = filepath.Base(w.Exe)
 	}
+	return wi
 }
 
 // Winfo is structured metadata info about a window.
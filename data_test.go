@@ -2,6 +2,7 @@ package ultraViolet
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"reflect"
 	"strings"
@@ -157,6 +158,7 @@ func TestWindowIsSticky(t *testing.T) {
 		{&Window{ID: 0, Desktop: 0, Name: ""}, false},
 		{&Window{ID: 1, Desktop: 1, Name: "Desktop"}, false},
 		{&Window{ID: -1, Desktop: -1, Name: "Google"}, true},
+		{&Window{ID: 2, Desktop: NoDesktop, Name: "Scratchpad"}, false},
 	}
 
 	for i, tt := range tests {
@@ -174,6 +176,7 @@ func TestWindowIsOnDesktop(t *testing.T) {
 		{&Window{ID: 0, Desktop: 0, Name: ""}, true},
 		{&Window{ID: 1, Desktop: 1, Name: "Desktop"}, false},
 		{&Window{ID: -1, Desktop: -1, Name: "Google"}, true},
+		{&Window{ID: 2, Desktop: NoDesktop, Name: "Scratchpad"}, false},
 	}
 	for i, tt := range tests {
 		if tt.window.IsOnDesktop(0) != tt.onDesktop {
@@ -205,6 +208,67 @@ func (_ testTracker2) Snap() (*Snapshot, error) {
 }
 func (_ testTracker2) Deps() string { return "depends on Go" }
 
+// testTracker3 hangs in Snap until it is closed.
+type testTracker3 chan struct{}
+
+func (t testTracker3) Snap() (*Snapshot, error) {
+	<-t
+	return new(Snapshot), nil
+}
+func (_ testTracker3) Deps() string { return "" }
+
+func TestSnapContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	hung := make(testTracker3)
+	defer close(hung)
+	if _, err := SnapContext(ctx, hung); err != context.DeadlineExceeded {
+		t.Errorf("hung: %v", err)
+	}
+	if snap, err := SnapContext(context.Background(), testTracker2{}); err != nil || len(snap.Visible) != 4 {
+		t.Errorf("tracker: %v %v", snap, err)
+	}
+	if _, err := SnapContext(context.Background(), testTracker1("")); err == nil {
+		t.Error("no error")
+	}
+}
+
+// testCollector sets Idle and the Name of every window once it is
+// closed.
+type testCollector chan struct{}
+
+func (c testCollector) Collect(snap *Snapshot) error {
+	<-c
+	snap.Idle = true
+	for _, w := range snap.Windows {
+		w.Name = "collected"
+	}
+	return nil
+}
+
+func TestCollectContext(t *testing.T) {
+	w := &Window{ID: 1, Name: "foo"}
+	snap := &Snapshot{Windows: []*Window{w}, Active: 1}
+	hung := make(testCollector)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := CollectContext(ctx, hung, snap); err != context.DeadlineExceeded {
+		t.Errorf("hung: %v", err)
+	}
+	close(hung)
+	// the hung collector finishing late leaves snap alone.
+	time.Sleep(10 * time.Millisecond)
+	if snap.Idle || w.Name != "foo" {
+		t.Errorf("hung: %+v %+v", snap, w)
+	}
+
+	done := make(testCollector)
+	close(done)
+	if err := CollectContext(context.Background(), done, snap); err != nil || !snap.Idle || snap.Windows[0].Name != "collected" {
+		t.Errorf("done: %v %+v", err, snap.Windows[0])
+	}
+}
+
 func TestRegisterTracker(t *testing.T) {
 	trackers = make(map[string]func() Tracker)
 	tests := []struct {
@@ -397,6 +461,24 @@ func TestWindowInfo(t *testing.T) {
 	}
 }
 
+func TestWindowInfoExe(t *testing.T) {
+	cases := []struct {
+		w        *Window
+		expected *Winfo
+	}{
+		{&Window{Name: "Terminal", Exe: "/usr/bin/gnome-terminal-server"}, &Winfo{App: "gnome-terminal-server", Title: "Terminal"}},
+		{&Window{Name: "foo - bar", Exe: "/usr/bin/baz"}, &Winfo{App: "bar", Title: "foo"}},
+		{&Window{Name: "untitled", Class: "Gedit", Exe: "/usr/bin/gedit"}, &Winfo{App: "Gedit", Title: "untitled"}},
+		{&Window{Name: "~/src", Class: "XTerm", Foreground: "vim"}, &Winfo{App: "XTerm", SubApp: "vim", Title: "~/src"}},
+		{&Window{Name: "me@host: ~ - Terminal", Foreground: "ssh"}, &Winfo{App: "Terminal", SubApp: "ssh", Title: "me@host: ~"}},
+	}
+	for i, c := range cases {
+		if !reflect.DeepEqual(c.w.Info(), c.expected) {
+			t.Errorf("case%d: %v", i, c.w.Info())
+		}
+	}
+}
+
 var expectedChrome = []typeExpectedWinfoCase{
 	{winfo: nil, isFulfill: false},
 	{winfo: nil, isFulfill: false},
@@ -0,0 +1,1012 @@
+package ultraViolet
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// This file implements the small subset of D-Bus needed by the trackers
+// that talk to desktop shells and to logind: connecting to a bus with the
+// EXTERNAL authentication mechanism, calling methods, exporting methods
+// and receiving signals.
+//
+// Values are represented by these Go types:
+//     y byte, b bool, n int16, q uint16, i int32, u uint32, x int64,
+//     t uint64, d float64, s string, o dbusObjectPath, g dbusSignature,
+//     h uint32, v dbusVariant, a{..} map[interface{}]interface{},
+//     other arrays and structs []interface{}.
+
+// D-Bus message types.
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+	dbusSignal       = 4
+)
+
+// dbusNoReplyExpected is the message flag for calls without reply.
+const dbusNoReplyExpected = 0x1
+
+// header field codes.
+const (
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSender      = 7
+	dbusFieldSignature   = 8
+)
+
+// dbusObjectPath is a D-Bus object path.
+type dbusObjectPath string
+
+// dbusSignature is a D-Bus type signature.
+type dbusSignature string
+
+// dbusVariant is a value along with its signature.
+type dbusVariant struct {
+	Sig   string
+	Value interface{}
+}
+
+// dbusMessage is a D-Bus message.
+type dbusMessage struct {
+	Type        byte
+	Flags       byte
+	Serial      uint32
+	Path        dbusObjectPath
+	Interface   string
+	Member      string
+	ErrorName   string
+	ReplySerial uint32
+	Destination string
+	Sender      string
+	Signature   string
+	Body        []interface{}
+}
+
+// dbusRemoteError is an error reply.
+type dbusRemoteError struct {
+	Name    string
+	Message string
+}
+
+func (e *dbusRemoteError) Error() string {
+	if e.Message == "" {
+		return "dbus: " + e.Name
+	}
+	return fmt.Sprintf("dbus: %s: %s", e.Name, e.Message)
+}
+
+// dbusMethod handles a call of an exported method. It returns the
+// signature and the values of the reply.
+type dbusMethod func(m *dbusMessage) (sig string, values []interface{}, err error)
+
+// dbusConn is a connection to a message bus.
+type dbusConn struct {
+	conn net.Conn
+	name string
+
+	wmu    sync.Mutex
+	serial uint32
+
+	mu       sync.Mutex
+	calls    map[uint32]chan *dbusMessage
+	methods  map[string]dbusMethod
+	signals  chan *dbusMessage
+	closeErr error
+	done     chan struct{}
+}
+
+// dbusSessionBus connects to the session bus.
+func dbusSessionBus() (*dbusConn, error) {
+	address := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	if address == "" {
+		if runDir := os.Getenv("XDG_RUNTIME_DIR"); runDir != "" {
+			address = "unix:path=" + runDir + "/bus"
+		} else {
+			return nil, errors.New("dbus: $DBUS_SESSION_BUS_ADDRESS is not set")
+		}
+	}
+	return dbusDial(address)
+}
+
+// dbusSystemBus connects to the system bus.
+func dbusSystemBus() (*dbusConn, error) {
+	address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if address == "" {
+		address = "unix:path=/var/run/dbus/system_bus_socket"
+	}
+	return dbusDial(address)
+}
+
+// dbusDial connects to the first reachable bus of a D-Bus server address
+// such as "unix:path=/run/user/1000/bus", authenticates and registers
+// with the bus.
+func dbusDial(address string) (*dbusConn, error) {
+	var errs []string
+	for _, a := range strings.Split(address, ";") {
+		conn, err := dbusDialOne(a)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		c, err := dbusHandshake(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("dbus: cannot connect to %q: %s", address, strings.Join(errs, "; "))
+}
+
+func dbusDialOne(address string) (net.Conn, error) {
+	colon := strings.Index(address, ":")
+	if colon == -1 {
+		return nil, fmt.Errorf("invalid address %q", address)
+	}
+	transport, params := address[:colon], make(map[string]string)
+	for _, kv := range strings.Split(address[colon+1:], ",") {
+		if eq := strings.Index(kv, "="); eq != -1 {
+			params[kv[:eq]] = kv[eq+1:]
+		}
+	}
+	switch transport {
+	case "unix":
+		if path, ok := params["path"]; ok {
+			return net.Dial("unix", path)
+		}
+		if name, ok := params["abstract"]; ok {
+			return net.Dial("unix", "@"+name)
+		}
+	case "tcp":
+		return net.Dial("tcp", net.JoinHostPort(params["host"], params["port"]))
+	}
+	return nil, fmt.Errorf("unsupported address %q", address)
+}
+
+// dbusHandshake authenticates on conn and says Hello to the bus.
+func dbusHandshake(conn net.Conn) (*dbusConn, error) {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := conn.Write([]byte("\x00AUTH EXTERNAL " + uid + "\r\n")); err != nil {
+		return nil, err
+	}
+	// read byte by byte so that nothing after the OK line is consumed.
+	line, err := dbusReadLine(conn)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return nil, fmt.Errorf("dbus: authentication failed: %s", line)
+	}
+	if _, err := conn.Write([]byte("BEGIN\r\n")); err != nil {
+		return nil, err
+	}
+
+	c := &dbusConn{
+		conn:    conn,
+		calls:   make(map[uint32]chan *dbusMessage),
+		methods: make(map[string]dbusMethod),
+		signals: make(chan *dbusMessage, 64),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	reply, err := c.Call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", "")
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) != 1 {
+		return nil, errors.New("dbus: invalid reply to Hello")
+	}
+	c.name, _ = reply[0].(string)
+	return c, nil
+}
+
+func dbusReadLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := r.Read(b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		line = append(line, b[0])
+	}
+}
+
+// Close closes the connection.
+func (c *dbusConn) Close() error {
+	return c.conn.Close()
+}
+
+// Signals returns the channel signals matched by AddMatch are sent to.
+// Signals are dropped if the channel is full.
+func (c *dbusConn) Signals() <-chan *dbusMessage {
+	return c.signals
+}
+
+// Done returns a channel that is closed when the connection fails.
+func (c *dbusConn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the connection failed.
+func (c *dbusConn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeErr
+}
+
+func (c *dbusConn) readLoop() {
+	r := bufio.NewReader(c.conn)
+	var err error
+	for {
+		var m *dbusMessage
+		if m, err = dbusReadMessage(r); err != nil {
+			break
+		}
+		switch m.Type {
+		case dbusMethodReturn, dbusError:
+			c.mu.Lock()
+			ch := c.calls[m.ReplySerial]
+			delete(c.calls, m.ReplySerial)
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- m
+			}
+		case dbusSignal:
+			select {
+			case c.signals <- m:
+			default:
+			}
+		case dbusMethodCall:
+			go c.serve(m)
+		}
+	}
+
+	// done is closed while holding mu, so that no call can register
+	// after the pending ones were failed.
+	c.mu.Lock()
+	c.closeErr = err
+	for serial, ch := range c.calls {
+		close(ch)
+		delete(c.calls, serial)
+	}
+	close(c.done)
+	c.mu.Unlock()
+}
+
+// serve answers a method call with an exported method.
+func (c *dbusConn) serve(m *dbusMessage) {
+	c.mu.Lock()
+	method := c.methods[string(m.Path)+"\x00"+m.Interface+"."+m.Member]
+	c.mu.Unlock()
+
+	reply := &dbusMessage{Type: dbusMethodReturn, ReplySerial: m.Serial, Destination: m.Sender}
+	if method == nil {
+		reply.Type = dbusError
+		reply.ErrorName = "org.freedesktop.DBus.Error.UnknownMethod"
+		reply.Signature = "s"
+		reply.Body = []interface{}{fmt.Sprintf("no method %s.%s at %s", m.Interface, m.Member, m.Path)}
+	} else if sig, values, err := method(m); err != nil {
+		reply.Type = dbusError
+		reply.ErrorName = "org.freedesktop.DBus.Error.Failed"
+		reply.Signature = "s"
+		reply.Body = []interface{}{err.Error()}
+	} else {
+		reply.Signature, reply.Body = sig, values
+	}
+	if m.Flags&dbusNoReplyExpected != 0 {
+		return
+	}
+	c.send(reply)
+}
+
+// Export makes method callable as iface.member on the object path.
+func (c *dbusConn) Export(path dbusObjectPath, iface, member string, method dbusMethod) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.methods[string(path)+"\x00"+iface+"."+member] = method
+}
+
+func (c *dbusConn) send(m *dbusMessage) (uint32, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.serial++
+	m.Serial = c.serial
+	b, err := dbusEncodeMessage(m)
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.conn.Write(b)
+	return m.Serial, err
+}
+
+// Call calls a method and waits for its reply. sig is the signature of
+// args.
+func (c *dbusConn) Call(dest string, path dbusObjectPath, iface, member, sig string, args ...interface{}) ([]interface{}, error) {
+	return c.CallContext(context.Background(), dest, path, iface, member, sig, args...)
+}
+
+// CallContext is Call that stops waiting for the reply and returns
+// ctx.Err() once ctx is done. A late reply is dropped.
+func (c *dbusConn) CallContext(ctx context.Context, dest string, path dbusObjectPath, iface, member, sig string, args ...interface{}) ([]interface{}, error) {
+	m := &dbusMessage{
+		Type:        dbusMethodCall,
+		Path:        path,
+		Interface:   iface,
+		Member:      member,
+		Destination: dest,
+		Signature:   sig,
+		Body:        args,
+	}
+	ch := make(chan *dbusMessage, 1)
+
+	// register the call before sending it, so that the reply can't be
+	// read before anybody waits for it.
+	c.wmu.Lock()
+	c.serial++
+	m.Serial = c.serial
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		c.wmu.Unlock()
+		return nil, errors.New("dbus: connection closed")
+	default:
+	}
+	c.calls[m.Serial] = ch
+	c.mu.Unlock()
+	b, err := dbusEncodeMessage(m)
+	if err == nil {
+		_, err = c.conn.Write(b)
+	}
+	c.wmu.Unlock()
+	if err != nil {
+		c.mu.Lock()
+		delete(c.calls, m.Serial)
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	var reply *dbusMessage
+	var ok bool
+	select {
+	case reply, ok = <-ch:
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.calls, m.Serial)
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	if !ok {
+		return nil, errors.New("dbus: connection closed")
+	}
+	if reply.Type == dbusError {
+		e := &dbusRemoteError{Name: reply.ErrorName}
+		if len(reply.Body) > 0 {
+			e.Message, _ = reply.Body[0].(string)
+		}
+		return nil, e
+	}
+	return reply.Body, nil
+}
+
+// Emit sends a signal.
+func (c *dbusConn) Emit(path dbusObjectPath, iface, member, sig string, args ...interface{}) error {
+	_, err := c.send(&dbusMessage{
+		Type:      dbusSignal,
+		Path:      path,
+		Interface: iface,
+		Member:    member,
+		Signature: sig,
+		Body:      args,
+	})
+	return err
+}
+
+// RequestName asks the bus to assign name to the connection. It fails if
+// the name is already owned.
+func (c *dbusConn) RequestName(name string) error {
+	// flag 0x4: DBUS_NAME_FLAG_DO_NOT_QUEUE
+	reply, err := c.Call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "RequestName", "su", name, uint32(0x4))
+	if err != nil {
+		return err
+	}
+	// 1: DBUS_REQUEST_NAME_REPLY_PRIMARY_OWNER, 4: ALREADY_OWNER
+	if len(reply) != 1 || (reply[0] != uint32(1) && reply[0] != uint32(4)) {
+		return fmt.Errorf("dbus: cannot own %s", name)
+	}
+	return nil
+}
+
+// AddMatch asks the bus to send the signals matching rule.
+func (c *dbusConn) AddMatch(rule string) error {
+	_, err := c.Call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "AddMatch", "s", rule)
+	return err
+}
+
+// GetProperty reads a property through org.freedesktop.DBus.Properties.
+func (c *dbusConn) GetProperty(dest string, path dbusObjectPath, iface, property string) (interface{}, error) {
+	return c.GetPropertyContext(context.Background(), dest, path, iface, property)
+}
+
+// GetPropertyContext is GetProperty that gives up once ctx is done.
+func (c *dbusConn) GetPropertyContext(ctx context.Context, dest string, path dbusObjectPath, iface, property string) (interface{}, error) {
+	reply, err := c.CallContext(ctx, dest, path, "org.freedesktop.DBus.Properties", "Get", "ss", iface, property)
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) != 1 {
+		return nil, errors.New("dbus: invalid reply to Properties.Get")
+	}
+	if v, ok := reply[0].(dbusVariant); ok {
+		return v.Value, nil
+	}
+	return reply[0], nil
+}
+
+// dbusReadMessage reads a message from r.
+func dbusReadMessage(r io.Reader) (*dbusMessage, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("dbus: invalid endianness %q", fixed[0])
+	}
+	bodyLen := int(order.Uint32(fixed[4:]))
+	fieldsLen := int(order.Uint32(fixed[12:]))
+	if bodyLen > 1<<27 || fieldsLen > 1<<26 {
+		return nil, errors.New("dbus: message too long")
+	}
+	headerLen := (16 + fieldsLen + 7) &^ 7
+	b := make([]byte, headerLen+bodyLen)
+	copy(b, fixed)
+	if _, err := io.ReadFull(r, b[16:]); err != nil {
+		return nil, err
+	}
+
+	m := &dbusMessage{Type: fixed[1], Flags: fixed[2], Serial: order.Uint32(fixed[8:])}
+	d := &dbusDecoder{b: b[:16+fieldsLen], pos: 12, order: order}
+	fields, err := d.decode("a(yv)")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields.([]interface{}) {
+		field := f.([]interface{})
+		code, value := field[0].(byte), field[1].(dbusVariant).Value
+		switch code {
+		case dbusFieldPath:
+			m.Path, _ = value.(dbusObjectPath)
+		case dbusFieldInterface:
+			m.Interface, _ = value.(string)
+		case dbusFieldMember:
+			m.Member, _ = value.(string)
+		case dbusFieldErrorName:
+			m.ErrorName, _ = value.(string)
+		case dbusFieldReplySerial:
+			m.ReplySerial, _ = value.(uint32)
+		case dbusFieldDestination:
+			m.Destination, _ = value.(string)
+		case dbusFieldSender:
+			m.Sender, _ = value.(string)
+		case dbusFieldSignature:
+			sig, _ := value.(dbusSignature)
+			m.Signature = string(sig)
+		}
+	}
+
+	body := &dbusDecoder{b: b[headerLen:], order: order}
+	for sig := m.Signature; sig != ""; {
+		var first string
+		if first, sig, err = dbusNextType(sig); err != nil {
+			return nil, err
+		}
+		v, err := body.decode(first)
+		if err != nil {
+			return nil, err
+		}
+		m.Body = append(m.Body, v)
+	}
+	return m, nil
+}
+
+// dbusEncodeMessage encodes m in dbusByteOrder.
+func dbusEncodeMessage(m *dbusMessage) ([]byte, error) {
+	body := &dbusEncoder{}
+	for sig, i := m.Signature, 0; sig != ""; i++ {
+		first, rest, err := dbusNextType(sig)
+		if err != nil {
+			return nil, err
+		}
+		if i >= len(m.Body) {
+			return nil, fmt.Errorf("dbus: missing value for signature %s", m.Signature)
+		}
+		if err := body.encode(first, m.Body[i]); err != nil {
+			return nil, err
+		}
+		sig = rest
+	}
+
+	var fields []interface{}
+	field := func(code byte, sig string, value interface{}) {
+		fields = append(fields, []interface{}{code, dbusVariant{sig, value}})
+	}
+	if m.Path != "" {
+		field(dbusFieldPath, "o", m.Path)
+	}
+	if m.Interface != "" {
+		field(dbusFieldInterface, "s", m.Interface)
+	}
+	if m.Member != "" {
+		field(dbusFieldMember, "s", m.Member)
+	}
+	if m.ErrorName != "" {
+		field(dbusFieldErrorName, "s", m.ErrorName)
+	}
+	if m.ReplySerial != 0 {
+		field(dbusFieldReplySerial, "u", m.ReplySerial)
+	}
+	if m.Destination != "" {
+		field(dbusFieldDestination, "s", m.Destination)
+	}
+	if m.Sender != "" {
+		field(dbusFieldSender, "s", m.Sender)
+	}
+	if m.Signature != "" {
+		field(dbusFieldSignature, "g", dbusSignature(m.Signature))
+	}
+
+	e := &dbusEncoder{b: []byte{'l', m.Type, m.Flags, 1}}
+	e.encode("u", uint32(len(body.b)))
+	e.encode("u", m.Serial)
+	if err := e.encode("a(yv)", fields); err != nil {
+		return nil, err
+	}
+	e.align(8)
+	return append(e.b, body.b...), nil
+}
+
+// dbusNextType splits the first complete type from a signature.
+func dbusNextType(sig string) (first, rest string, err error) {
+	if sig == "" {
+		return "", "", errors.New("dbus: empty signature")
+	}
+	switch sig[0] {
+	case 'a':
+		_, rest, err := dbusNextType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return sig[:len(sig)-len(rest)], rest, nil
+	case '(', '{':
+		end := map[byte]byte{'(': ')', '{': '}'}[sig[0]]
+		for rest := sig[1:]; rest != ""; {
+			if rest[0] == end {
+				n := len(sig) - len(rest) + 1
+				return sig[:n], sig[n:], nil
+			}
+			if _, rest, err = dbusNextType(rest); err != nil {
+				return "", "", err
+			}
+		}
+		return "", "", fmt.Errorf("dbus: unterminated signature %q", sig)
+	case 'y', 'b', 'n', 'q', 'i', 'u', 'x', 't', 'd', 's', 'o', 'g', 'h', 'v':
+		return sig[:1], sig[1:], nil
+	}
+	return "", "", fmt.Errorf("dbus: invalid signature %q", sig)
+}
+
+// dbusAlignment returns the alignment of the first type of sig.
+func dbusAlignment(sig string) int {
+	switch sig[0] {
+	case 'y', 'g', 'v':
+		return 1
+	case 'n', 'q':
+		return 2
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 4
+}
+
+// dbusByteOrder is the byte order of the messages dbusConn sends.
+var dbusByteOrder = binary.LittleEndian
+
+// dbusEncoder encodes values in dbusByteOrder.
+type dbusEncoder struct {
+	b []byte
+}
+
+func (e *dbusEncoder) align(n int) {
+	for len(e.b)%n != 0 {
+		e.b = append(e.b, 0)
+	}
+}
+
+func (e *dbusEncoder) uint(n int, v uint64) {
+	e.align(n)
+	switch n {
+	case 2:
+		var b [2]byte
+		dbusByteOrder.PutUint16(b[:], uint16(v))
+		e.b = append(e.b, b[:]...)
+	case 4:
+		var b [4]byte
+		dbusByteOrder.PutUint32(b[:], uint32(v))
+		e.b = append(e.b, b[:]...)
+	case 8:
+		var b [8]byte
+		dbusByteOrder.PutUint64(b[:], v)
+		e.b = append(e.b, b[:]...)
+	}
+}
+
+// encode encodes v as the single complete type sig.
+func (e *dbusEncoder) encode(sig string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	mismatch := fmt.Errorf("dbus: cannot encode %T as %s", v, sig)
+	switch sig[0] {
+	case 'y':
+		if rv.Kind() != reflect.Uint8 {
+			return mismatch
+		}
+		e.b = append(e.b, byte(rv.Uint()))
+	case 'b':
+		b, ok := v.(bool)
+		if !ok {
+			return mismatch
+		}
+		var u uint64
+		if b {
+			u = 1
+		}
+		e.uint(4, u)
+	case 'n', 'i', 'x':
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			e.uint(map[byte]int{'n': 2, 'i': 4, 'x': 8}[sig[0]], uint64(rv.Int()))
+		default:
+			return mismatch
+		}
+	case 'q', 'u', 't', 'h':
+		switch rv.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			e.uint(map[byte]int{'q': 2, 'u': 4, 'h': 4, 't': 8}[sig[0]], rv.Uint())
+		default:
+			return mismatch
+		}
+	case 'd':
+		if rv.Kind() != reflect.Float64 && rv.Kind() != reflect.Float32 {
+			return mismatch
+		}
+		e.uint(8, math.Float64bits(rv.Float()))
+	case 's', 'o':
+		if rv.Kind() != reflect.String {
+			return mismatch
+		}
+		e.uint(4, uint64(rv.Len()))
+		e.b = append(e.b, rv.String()...)
+		e.b = append(e.b, 0)
+	case 'g':
+		if rv.Kind() != reflect.String {
+			return mismatch
+		}
+		e.b = append(e.b, byte(rv.Len()))
+		e.b = append(e.b, rv.String()...)
+		e.b = append(e.b, 0)
+	case 'v':
+		variant, ok := v.(dbusVariant)
+		if !ok {
+			if variant.Sig = dbusSignatureOf(v); variant.Sig == "" {
+				return mismatch
+			}
+			variant.Value = v
+		}
+		if err := e.encode("g", variant.Sig); err != nil {
+			return err
+		}
+		return e.encode(variant.Sig, variant.Value)
+	case '(':
+		fields, ok := v.([]interface{})
+		if !ok {
+			return mismatch
+		}
+		e.align(8)
+		inner := sig[1 : len(sig)-1]
+		for _, f := range fields {
+			if inner == "" {
+				return mismatch
+			}
+			first, rest, err := dbusNextType(inner)
+			if err != nil {
+				return err
+			}
+			if err := e.encode(first, f); err != nil {
+				return err
+			}
+			inner = rest
+		}
+		if inner != "" {
+			return mismatch
+		}
+	case 'a':
+		elem := sig[1:]
+		e.uint(4, 0)
+		lenAt := len(e.b) - 4
+		e.align(dbusAlignment(elem))
+		start := len(e.b)
+		if elem[0] == '{' {
+			key, value, err := dbusNextType(elem[1 : len(elem)-1])
+			if err != nil {
+				return err
+			}
+			if rv.Kind() != reflect.Map {
+				return mismatch
+			}
+			for _, k := range rv.MapKeys() {
+				e.align(8)
+				if err := e.encode(key, k.Interface()); err != nil {
+					return err
+				}
+				if err := e.encode(value, rv.MapIndex(k).Interface()); err != nil {
+					return err
+				}
+			}
+		} else {
+			if v != nil && rv.Kind() != reflect.Slice {
+				return mismatch
+			}
+			for i := 0; v != nil && i < rv.Len(); i++ {
+				if err := e.encode(elem, rv.Index(i).Interface()); err != nil {
+					return err
+				}
+			}
+		}
+		dbusByteOrder.PutUint32(e.b[lenAt:], uint32(len(e.b)-start))
+	default:
+		return fmt.Errorf("dbus: invalid signature %q", sig)
+	}
+	return nil
+}
+
+// dbusSignatureOf returns the signature of simple Go values, or "" if
+// it cannot be inferred.
+func dbusSignatureOf(v interface{}) string {
+	switch v.(type) {
+	case byte:
+		return "y"
+	case bool:
+		return "b"
+	case int16:
+		return "n"
+	case uint16:
+		return "q"
+	case int32:
+		return "i"
+	case uint32:
+		return "u"
+	case int64:
+		return "x"
+	case uint64:
+		return "t"
+	case float64:
+		return "d"
+	case string:
+		return "s"
+	case dbusObjectPath:
+		return "o"
+	case dbusSignature:
+		return "g"
+	case []string:
+		return "as"
+	}
+	return ""
+}
+
+// dbusDecoder decodes values from a message.
+type dbusDecoder struct {
+	b     []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+var errDBusShort = errors.New("dbus: message too short")
+
+func (d *dbusDecoder) align(n int) error {
+	pos := (d.pos + n - 1) / n * n
+	if pos > len(d.b) {
+		return errDBusShort
+	}
+	d.pos = pos
+	return nil
+}
+
+func (d *dbusDecoder) next(n int) ([]byte, error) {
+	if err := d.align(n); err != nil {
+		return nil, err
+	}
+	if d.pos+n > len(d.b) {
+		return nil, errDBusShort
+	}
+	b := d.b[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *dbusDecoder) string(lenSize int) (string, error) {
+	var n int
+	if lenSize == 1 {
+		b, err := d.next(1)
+		if err != nil {
+			return "", err
+		}
+		n = int(b[0])
+	} else {
+		b, err := d.next(4)
+		if err != nil {
+			return "", err
+		}
+		n = int(d.order.Uint32(b))
+	}
+	if n < 0 || d.pos+n+1 > len(d.b) {
+		return "", errDBusShort
+	}
+	s := string(d.b[d.pos : d.pos+n])
+	d.pos += n + 1
+	return s, nil
+}
+
+// decode decodes a value of the single complete type sig.
+func (d *dbusDecoder) decode(sig string) (interface{}, error) {
+	switch sig[0] {
+	case 'y':
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b', 'i', 'u', 'h':
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		u := d.order.Uint32(b)
+		switch sig[0] {
+		case 'b':
+			return u != 0, nil
+		case 'i':
+			return int32(u), nil
+		}
+		return u, nil
+	case 'n', 'q':
+		b, err := d.next(2)
+		if err != nil {
+			return nil, err
+		}
+		if sig[0] == 'n' {
+			return int16(d.order.Uint16(b)), nil
+		}
+		return d.order.Uint16(b), nil
+	case 'x', 't', 'd':
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		u := d.order.Uint64(b)
+		switch sig[0] {
+		case 'x':
+			return int64(u), nil
+		case 'd':
+			return math.Float64frombits(u), nil
+		}
+		return u, nil
+	case 's':
+		return d.string(4)
+	case 'o':
+		s, err := d.string(4)
+		return dbusObjectPath(s), err
+	case 'g':
+		s, err := d.string(1)
+		return dbusSignature(s), err
+	case 'v':
+		s, err := d.string(1)
+		if err != nil {
+			return nil, err
+		}
+		first, rest, err := dbusNextType(s)
+		if err != nil || rest != "" {
+			return nil, fmt.Errorf("dbus: invalid variant signature %q", s)
+		}
+		v, err := d.decode(first)
+		return dbusVariant{Sig: s, Value: v}, err
+	case '(':
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		var fields []interface{}
+		for inner := sig[1 : len(sig)-1]; inner != ""; {
+			first, rest, err := dbusNextType(inner)
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.decode(first)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, v)
+			inner = rest
+		}
+		return fields, nil
+	case 'a':
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		n := int(d.order.Uint32(b))
+		elem := sig[1:]
+		if err := d.align(dbusAlignment(elem)); err != nil {
+			return nil, err
+		}
+		end := d.pos + n
+		if n < 0 || end > len(d.b) {
+			return nil, errDBusShort
+		}
+		if elem[0] == '{' {
+			key, value, err := dbusNextType(elem[1 : len(elem)-1])
+			if err != nil {
+				return nil, err
+			}
+			dict := make(map[interface{}]interface{})
+			for d.pos < end {
+				if err := d.align(8); err != nil {
+					return nil, err
+				}
+				k, err := d.decode(key)
+				if err != nil {
+					return nil, err
+				}
+				v, err := d.decode(value)
+				if err != nil {
+					return nil, err
+				}
+				dict[k] = v
+			}
+			return dict, nil
+		}
+		array := make([]interface{}, 0)
+		for d.pos < end {
+			v, err := d.decode(elem)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, v)
+		}
+		return array, nil
+	}
+	return nil, fmt.Errorf("dbus: invalid signature %q", sig)
+}
@@ -0,0 +1,553 @@
+package ultraViolet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBus is a message bus that routes method calls, replies and signals
+// between its connections. It implements just enough of the
+// org.freedesktop.DBus interface for dbusConn: Hello, RequestName and
+// AddMatch (every signal is delivered to every connection).
+type fakeBus struct {
+	l   net.Listener
+	dir string
+
+	mu     sync.Mutex
+	serial uint32
+	next   int
+	conns  map[string]net.Conn
+	owners map[string]string
+}
+
+func newFakeBus(t *testing.T) *fakeBus {
+	dir, err := ioutil.TempDir("", "uv-dbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", filepath.Join(dir, "bus"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	b := &fakeBus{l: l, dir: dir, conns: make(map[string]net.Conn), owners: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBus) address() string {
+	return "unix:path=" + filepath.Join(b.dir, "bus")
+}
+
+// setenv sets the environment variable name to value until the returned
+// function is called.
+func setenv(name, value string) func() {
+	old, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+	return func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func (b *fakeBus) Close() {
+	b.l.Close()
+	b.mu.Lock()
+	for _, conn := range b.conns {
+		conn.Close()
+	}
+	b.mu.Unlock()
+	os.RemoveAll(b.dir)
+}
+
+func (b *fakeBus) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if nul, err := r.ReadByte(); err != nil || nul != 0 {
+		return
+	}
+	if line, err := r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "AUTH EXTERNAL ") {
+		conn.Write([]byte("REJECTED EXTERNAL\r\n"))
+		return
+	}
+	conn.Write([]byte("OK 0123456789abcdef0123456789abcdef\r\n"))
+	if line, err := r.ReadString('\n'); err != nil || line != "BEGIN\r\n" {
+		return
+	}
+
+	b.mu.Lock()
+	b.next++
+	name := fmt.Sprintf(":1.%d", b.next)
+	b.conns[name] = conn
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, name)
+		for owned, owner := range b.owners {
+			if owner == name {
+				delete(b.owners, owned)
+			}
+		}
+		b.mu.Unlock()
+	}()
+
+	for {
+		m, err := dbusReadMessage(r)
+		if err != nil {
+			return
+		}
+		m.Sender = name
+		b.route(m)
+	}
+}
+
+func (b *fakeBus) route(m *dbusMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if m.Type == dbusMethodCall && m.Destination == "org.freedesktop.DBus" {
+		reply := &dbusMessage{Type: dbusMethodReturn, ReplySerial: m.Serial}
+		switch m.Member {
+		case "Hello":
+			reply.Signature, reply.Body = "s", []interface{}{m.Sender}
+		case "RequestName":
+			name, _ := m.Body[0].(string)
+			result := uint32(1)
+			if owner, ok := b.owners[name]; ok && owner != m.Sender {
+				result = 3
+			} else {
+				b.owners[name] = m.Sender
+			}
+			reply.Signature, reply.Body = "u", []interface{}{result}
+		case "AddMatch":
+		default:
+			reply = dbusErrorReply(m, "org.freedesktop.DBus.Error.UnknownMethod")
+		}
+		b.write(m.Sender, reply)
+		return
+	}
+
+	if m.Type == dbusSignal && m.Destination == "" {
+		for name := range b.conns {
+			b.write(name, m)
+		}
+		return
+	}
+	dest := m.Destination
+	if owner, ok := b.owners[dest]; ok {
+		dest = owner
+	}
+	if _, ok := b.conns[dest]; !ok {
+		if m.Type == dbusMethodCall {
+			b.write(m.Sender, dbusErrorReply(m, "org.freedesktop.DBus.Error.ServiceUnknown"))
+		}
+		return
+	}
+	b.write(dest, m)
+}
+
+func dbusErrorReply(m *dbusMessage, name string) *dbusMessage {
+	return &dbusMessage{Type: dbusError, ErrorName: name, ReplySerial: m.Serial, Signature: "s", Body: []interface{}{m.Member}}
+}
+
+// write sends m to the connection named name. Messages sent by the bus
+// itself get a serial of their own.
+func (b *fakeBus) write(name string, m *dbusMessage) {
+	if m.Sender == "" {
+		b.serial++
+		m.Serial = b.serial
+		m.Sender = "org.freedesktop.DBus"
+		m.Destination = name
+	}
+	p, err := dbusEncodeMessage(m)
+	if err != nil {
+		panic(err)
+	}
+	b.conns[name].Write(p)
+}
+
+func TestDBusCodec(t *testing.T) {
+	tests := []struct {
+		sig   string
+		value interface{}
+	}{
+		{"s", "ultra-violet"},
+		{"s", ""},
+		{"o", dbusObjectPath("/org/freedesktop/login1/session/auto")},
+		{"g", dbusSignature("a{sv}")},
+		{"b", true},
+		{"u", uint32(0xdeadbeef)},
+		{"x", int64(-1) << 40},
+		{"d", 0.25},
+		{"(ybnqiuxtd)", []interface{}{byte(1), false, int16(-2), uint16(3), int32(-4), uint32(5), int64(-6), uint64(7), 8.5}},
+		{"as", []interface{}{"a", "bc", "def"}},
+		{"as", []interface{}{}},
+		{"at", []interface{}{uint64(1), uint64(2)}},
+		{"a(yv)", []interface{}{
+			[]interface{}{byte(1), dbusVariant{"o", dbusObjectPath("/")}},
+			[]interface{}{byte(5), dbusVariant{"u", uint32(9)}},
+		}},
+		{"a{sv}", map[interface{}]interface{}{
+			"IdleHint":      dbusVariant{"b", true},
+			"IdleSinceHint": dbusVariant{"t", uint64(1517400000000000)},
+			"Names":         dbusVariant{"as", []interface{}{"x"}},
+		}},
+		{"aay", []interface{}{[]interface{}{byte(1)}, []interface{}{}, []interface{}{byte(2), byte(3)}}},
+	}
+	for i, tt := range tests {
+		// prefix a byte so that alignment matters.
+		e := &dbusEncoder{b: []byte{0}}
+		if err := e.encode(tt.sig, tt.value); err != nil {
+			t.Errorf("case%d: %s", i, err)
+			continue
+		}
+		d := &dbusDecoder{b: e.b, pos: 1, order: dbusByteOrder}
+		value, err := d.decode(tt.sig)
+		if err != nil {
+			t.Errorf("case%d: %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, tt.value) {
+			t.Errorf("case%d: got %#v", i, value)
+		}
+		if d.pos != len(e.b) {
+			t.Errorf("case%d: %d of %d bytes decoded", i, d.pos, len(e.b))
+		}
+	}
+}
+
+func TestDBusEncodeConversions(t *testing.T) {
+	tests := []struct {
+		sig   string
+		value interface{}
+		ok    bool
+	}{
+		{"s", dbusObjectPath("/a"), true},
+		{"as", []string{"a", "b"}, true},
+		{"a{ss}", map[string]string{"a": "b"}, true},
+		{"v", "inferred", true},
+		{"v", struct{}{}, false},
+		{"u", -1, false},
+		{"i", 1, true},
+		{"s", 1, false},
+		{"(su)", []interface{}{"a"}, false},
+		{"(su)", []interface{}{"a", uint32(1), "c"}, false},
+	}
+	for i, tt := range tests {
+		err := (&dbusEncoder{}).encode(tt.sig, tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("case%d: %v", i, err)
+		}
+	}
+}
+
+func TestDBusNextType(t *testing.T) {
+	tests := []struct {
+		sig   string
+		first string
+		rest  string
+		ok    bool
+	}{
+		{"su", "s", "u", true},
+		{"a{sv}as", "a{sv}", "as", true},
+		{"(ia(ss))v", "(ia(ss))", "v", true},
+		{"aai", "aai", "", true},
+		{"(ss", "", "", false},
+		{"a", "", "", false},
+		{"z", "", "", false},
+	}
+	for i, tt := range tests {
+		first, rest, err := dbusNextType(tt.sig)
+		if (err == nil) != tt.ok || first != tt.first || rest != tt.rest {
+			t.Errorf("case%d: %q %q %v", i, first, rest, err)
+		}
+	}
+}
+
+func TestDBusReadMessageBigEndian(t *testing.T) {
+	// a signal with the signature "u" and the body 42, as sent by a
+	// big-endian peer.
+	var b bytes.Buffer
+	b.Write([]byte{'B', dbusSignal, 0, 1, 0, 0, 0, 4, 0, 0, 0, 7})
+	fields := []byte{
+		dbusFieldPath, 1, 'o', 0, 0, 0, 0, 2, '/', 'a', 0, 0, 0, 0, 0, 0,
+		dbusFieldSignature, 1, 'g', 0, 1, 'u', 0,
+	}
+	b.Write([]byte{0, 0, 0, byte(len(fields))})
+	b.Write(fields)
+	b.Write([]byte{0})
+	b.Write([]byte{0, 0, 0, 42})
+
+	m, err := dbusReadMessage(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != dbusSignal || m.Serial != 7 || m.Path != "/a" || !reflect.DeepEqual(m.Body, []interface{}{uint32(42)}) {
+		t.Errorf("message: %+v", m)
+	}
+}
+
+func TestDBusConn(t *testing.T) {
+	bus := newFakeBus(t)
+	defer bus.Close()
+
+	service, err := dbusDial(bus.address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	if err := service.RequestName("org.example.Test"); err != nil {
+		t.Fatal(err)
+	}
+	service.Export("/org/example/Test", "org.example.Test", "Echo", func(m *dbusMessage) (string, []interface{}, error) {
+		return m.Signature, m.Body, nil
+	})
+	service.Export("/org/example/Test", "org.example.Test", "Fail", func(m *dbusMessage) (string, []interface{}, error) {
+		return "", nil, errors.New("failed on purpose")
+	})
+	hang := make(chan struct{})
+	service.Export("/org/example/Test", "org.example.Test", "Hang", func(m *dbusMessage) (string, []interface{}, error) {
+		<-hang
+		return "", nil, nil
+	})
+
+	client, err := dbusDial("unix:path=/nonexistent;" + bus.address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if client.name == "" || client.name == service.name {
+		t.Errorf("names: %q, %q", client.name, service.name)
+	}
+	if err := client.RequestName("org.example.Test"); err == nil {
+		t.Error("name owned twice")
+	}
+
+	reply, err := client.Call("org.example.Test", "/org/example/Test", "org.example.Test", "Echo", "sa{sv}", "hello", map[string]interface{}{"n": uint32(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{"hello", map[interface{}]interface{}{"n": dbusVariant{"u", uint32(1)}}}
+	if !reflect.DeepEqual(reply, expected) {
+		t.Errorf("reply: %#v", reply)
+	}
+
+	_, err = client.Call("org.example.Test", "/org/example/Test", "org.example.Test", "Fail", "")
+	if e, ok := err.(*dbusRemoteError); !ok || e.Name != "org.freedesktop.DBus.Error.Failed" || e.Message != "failed on purpose" {
+		t.Errorf("Fail: %v", err)
+	}
+	_, err = client.Call("org.example.Test", "/org/example/Test", "org.example.Test", "Missing", "")
+	if e, ok := err.(*dbusRemoteError); !ok || e.Name != "org.freedesktop.DBus.Error.UnknownMethod" {
+		t.Errorf("Missing: %v", err)
+	}
+	_, err = client.Call("org.example.Nobody", "/", "org.example.Test", "Echo", "")
+	if e, ok := err.(*dbusRemoteError); !ok || e.Name != "org.freedesktop.DBus.Error.ServiceUnknown" {
+		t.Errorf("Nobody: %v", err)
+	}
+
+	// a call that gets no reply in time is given up, and its late reply
+	// doesn't disturb the next call.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = client.CallContext(ctx, "org.example.Test", "/org/example/Test", "org.example.Test", "Hang", "")
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("Hang: %v", err)
+	}
+	close(hang)
+	reply, err = client.Call("org.example.Test", "/org/example/Test", "org.example.Test", "Echo", "s", "again")
+	if err != nil || !reflect.DeepEqual(reply, []interface{}{"again"}) {
+		t.Errorf("after Hang: %v %#v", err, reply)
+	}
+
+	if err := client.AddMatch("type='signal',interface='org.example.Test'"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Emit("/org/example/Test", "org.example.Test", "Changed", "u", uint32(3)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-client.Signals():
+		if m.Member != "Changed" || m.Sender != service.name || !reflect.DeepEqual(m.Body, []interface{}{uint32(3)}) {
+			t.Errorf("signal: %+v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no signal")
+	}
+
+	bus.Close()
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed")
+	}
+	if _, err := client.Call("org.example.Test", "/org/example/Test", "org.example.Test", "Echo", ""); err == nil {
+		t.Error("call on a closed connection")
+	}
+}
+
+// TestDBusConnClosing checks that calls made while the connection
+// closes all return.
+func TestDBusConnClosing(t *testing.T) {
+	bus := newFakeBus(t)
+	defer bus.Close()
+	service, err := dbusDial(bus.address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	if err := service.RequestName("org.example.Test"); err != nil {
+		t.Fatal(err)
+	}
+	service.Export("/org/example/Test", "org.example.Test", "Echo", func(m *dbusMessage) (string, []interface{}, error) {
+		return m.Signature, m.Body, nil
+	})
+	client, err := dbusDial(bus.address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := client.Call("org.example.Test", "/org/example/Test", "org.example.Test", "Echo", "s", "hello"); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	bus.Close()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("calls still waiting after the connection closed")
+	}
+}
+
+// TestDBusDaemon checks dbusConn against the reference bus
+// implementation. It is skipped if dbus-daemon is not installed.
+func TestDBusDaemon(t *testing.T) {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+	dir, err := ioutil.TempDir("", "uv-dbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "bus.conf")
+	err = ioutil.WriteFile(config, []byte(`<busconfig>
+  <type>session</type>
+  <listen>unix:path=`+filepath.Join(dir, "bus")+`</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	address, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := dbusDial(strings.TrimSpace(address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	if err := service.RequestName("org.example.Test"); err != nil {
+		t.Fatal(err)
+	}
+	service.Export("/org/example/Test", "org.example.Test", "Echo", func(m *dbusMessage) (string, []interface{}, error) {
+		return m.Signature, m.Body, nil
+	})
+
+	client, err := dbusDial(strings.TrimSpace(address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	args := []interface{}{"hello", dbusVariant{"ad", []interface{}{1.5}}, []interface{}{int64(-1), "x"}}
+	reply, err := client.Call("org.example.Test", "/org/example/Test", "org.example.Test", "Echo", "sv(xs)", args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reply, args) {
+		t.Errorf("reply: %#v", reply)
+	}
+	id, err := client.GetProperty("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Features")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := id.([]interface{}); !ok {
+		t.Errorf("Features: %#v", id)
+	}
+
+	if err := client.AddMatch("type='signal',interface='org.example.Test'"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Emit("/org/example/Test", "org.example.Test", "Changed", ""); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		select {
+		case m := <-client.Signals():
+			if m.Member != "Changed" {
+				continue
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("no signal")
+		}
+		break
+	}
+}
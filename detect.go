@@ -0,0 +1,135 @@
+package ultraViolet
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Session describes the desktop session uv runs in, as far as it can be
+// told from the environment.
+type Session struct {
+	// OS is the value of runtime.GOOS.
+	OS string
+	// Display is $DISPLAY, set in X11 sessions and by XWayland.
+	Display string
+	// WaylandDisplay is $WAYLAND_DISPLAY, set in Wayland sessions.
+	WaylandDisplay string
+	// I3Sock is $SWAYSOCK or $I3SOCK.
+	I3Sock string
+	// HyprlandSignature is $HYPRLAND_INSTANCE_SIGNATURE.
+	HyprlandSignature string
+	// Desktops is $XDG_CURRENT_DESKTOP split at colons, e.g. ["ubuntu", "GNOME"].
+	Desktops []string
+}
+
+// CurrentSession returns the Session of the current process.
+func CurrentSession() Session {
+	s := Session{
+		OS:                runtime.GOOS,
+		Display:           os.Getenv("DISPLAY"),
+		WaylandDisplay:    os.Getenv("WAYLAND_DISPLAY"),
+		I3Sock:            os.Getenv("SWAYSOCK"),
+		HyprlandSignature: os.Getenv("HYPRLAND_INSTANCE_SIGNATURE"),
+	}
+	if s.I3Sock == "" {
+		s.I3Sock = os.Getenv("I3SOCK")
+	}
+	if desktops := os.Getenv("XDG_CURRENT_DESKTOP"); desktops != "" {
+		s.Desktops = strings.Split(desktops, ":")
+	}
+	return s
+}
+
+// IsDesktop reports whether name is one of s.Desktops, ignoring case.
+func (s Session) IsDesktop(name string) bool {
+	for _, d := range s.Desktops {
+		if strings.EqualFold(d, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectTrackers returns the names of the registered Trackers that may
+// work in session s, best first. Trackers that talk to the compositor
+// come before the X11 ones, which only see XWayland windows in a Wayland
+// session.
+func DetectTrackers(s Session) []string {
+	var names []string
+	if s.OS == "darwin" {
+		names = append(names, "darwin")
+	} else {
+		if s.HyprlandSignature != "" || s.IsDesktop("Hyprland") {
+			names = append(names, "hyprland")
+		}
+		if s.I3Sock != "" || s.IsDesktop("sway") || s.IsDesktop("i3") {
+			names = append(names, "i3")
+		}
+		if s.IsDesktop("GNOME") {
+			names = append(names, "gnome")
+		}
+		if s.IsDesktop("KDE") {
+			names = append(names, "kwin")
+		}
+		if s.Display != "" {
+			names = append(names, "x11", "linux")
+		} else if s.WaylandDisplay == "" {
+			// nothing to go by (e.g. started from cron): the linux
+			// tracker falls back to display :0.
+			names = append(names, "linux")
+		}
+	}
+
+	registered := names[:0]
+	for _, name := range names {
+		if _, ok := trackers[name]; ok {
+			registered = append(registered, name)
+		}
+	}
+	return registered
+}
+
+// DefaultTracker returns a new instance of the best Tracker for the
+// current session.
+func DefaultTracker() (Tracker, error) {
+	return defaultTracker(CurrentSession())
+}
+
+// defaultTracker returns a new instance of the first Tracker DetectTrackers
+// lists for s that works: one whose Probe, if it is a Prober, succeeds,
+// or the last one, which is used anyway.
+func defaultTracker(s Session) (Tracker, error) {
+	names := DetectTrackers(s)
+	if len(names) == 0 {
+		return nil, errors.New("no tracker supports this session; choose one of " + strings.Join(Trackers(), ", "))
+	}
+	for _, name := range names[:len(names)-1] {
+		t, err := NewTracker(name)
+		if err != nil {
+			return nil, err
+		}
+		p, ok := t.(Prober)
+		if !ok || p.Probe() == nil {
+			return t, nil
+		}
+		if closer, ok := t.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	return NewTracker(names[len(names)-1])
+}
+
+// Trackers returns the names of the registered Trackers in alphabetical
+// order.
+func Trackers() []string {
+	names := make([]string, 0, len(trackers))
+	for name := range trackers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
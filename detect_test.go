@@ -0,0 +1,93 @@
+package ultraViolet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDetectTrackers(t *testing.T) {
+	defer func(registered map[string]func() Tracker) { trackers = registered }(trackers)
+	trackers = make(map[string]func() Tracker)
+	for _, name := range []string{"darwin", "gnome", "hyprland", "i3", "kwin", "linux", "x11"} {
+		trackers[name] = func() Tracker { return testTracker0(0) }
+	}
+
+	tests := []struct {
+		session  Session
+		expected []string
+	}{
+		{Session{OS: "darwin", Display: ":0"}, []string{"darwin"}},
+		{Session{OS: "linux", Display: ":0"}, []string{"x11", "linux"}},
+		{Session{OS: "linux"}, []string{"linux"}},
+		{Session{OS: "linux", WaylandDisplay: "wayland-0"}, nil},
+		{Session{OS: "linux", Display: ":0", I3Sock: "/run/user/1000/i3/ipc-socket.1234", Desktops: []string{"i3"}}, []string{"i3", "x11", "linux"}},
+		{Session{OS: "linux", WaylandDisplay: "wayland-1", I3Sock: "/run/user/1000/sway-ipc.1000.1234.sock"}, []string{"i3"}},
+		{Session{OS: "linux", WaylandDisplay: "wayland-1", Display: ":1", HyprlandSignature: "v0.41.2_1718192000_12345"}, []string{"hyprland", "x11", "linux"}},
+		{Session{OS: "linux", WaylandDisplay: "wayland-0", Display: ":0", Desktops: []string{"ubuntu", "GNOME"}}, []string{"gnome", "x11", "linux"}},
+		{Session{OS: "linux", WaylandDisplay: "wayland-0", Desktops: []string{"KDE"}}, []string{"kwin"}},
+		{Session{OS: "freebsd", Display: ":0", Desktops: []string{"XFCE"}}, []string{"x11", "linux"}},
+	}
+	for i, tt := range tests {
+		if actual := DetectTrackers(tt.session); !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("case%d: %v", i, actual)
+		}
+	}
+
+	// unregistered trackers are left out.
+	delete(trackers, "x11")
+	if actual := DetectTrackers(Session{OS: "linux", Display: ":0"}); !reflect.DeepEqual(actual, []string{"linux"}) {
+		t.Errorf("unregistered: %v", actual)
+	}
+}
+
+// probeTracker is a Tracker whose Probe returns its error.
+type probeTracker struct {
+	testTracker0
+	err error
+}
+
+func (t probeTracker) Probe() error { return t.err }
+
+func TestDefaultTracker(t *testing.T) {
+	defer func(registered map[string]func() Tracker) { trackers = registered }(trackers)
+	unavailable := probeTracker{err: errors.New("gnome: the ultra-violet GNOME Shell extension is not enabled")}
+	trackers = map[string]func() Tracker{
+		"gnome": func() Tracker { return unavailable },
+		"kwin":  func() Tracker { return probeTracker{} },
+		"x11":   func() Tracker { return testTracker0(1) },
+		"linux": func() Tracker { return testTracker0(2) },
+	}
+
+	tests := []struct {
+		session  Session
+		expected Tracker
+	}{
+		// gnome ranked first but unavailable.
+		{Session{OS: "linux", Display: ":0", Desktops: []string{"ubuntu", "GNOME"}}, testTracker0(1)},
+		{Session{OS: "linux", WaylandDisplay: "wayland-0", Display: ":0", Desktops: []string{"GNOME"}}, testTracker0(1)},
+		{Session{OS: "linux", Display: ":0", Desktops: []string{"KDE"}}, probeTracker{}},
+		// the last resort is used anyway.
+		{Session{OS: "linux", WaylandDisplay: "wayland-0", Desktops: []string{"GNOME"}}, unavailable},
+	}
+	for i, tt := range tests {
+		if actual, err := defaultTracker(tt.session); err != nil || !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("case%d: %v %v", i, actual, err)
+		}
+	}
+	if _, err := defaultTracker(Session{OS: "linux", WaylandDisplay: "wayland-0"}); err == nil {
+		t.Error("no tracker: no error")
+	}
+}
+
+func TestTrackers(t *testing.T) {
+	defer func(registered map[string]func() Tracker) { trackers = registered }(trackers)
+	trackers = map[string]func() Tracker{
+		"x11":   func() Tracker { return testTracker0(0) },
+		"gnome": func() Tracker { return testTracker0(0) },
+		"i3":    func() Tracker { return testTracker0(0) },
+	}
+	if actual := Trackers(); !reflect.DeepEqual(actual, []string{"gnome", "i3", "x11"}) {
+		t.Errorf("trackers: %v", actual)
+	}
+}
@@ -0,0 +1,258 @@
+package ultraViolet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultEditorTimeout is how long an editor heartbeat holds if no other
+// arrives.
+const DefaultEditorTimeout = 2 * time.Minute
+
+// EditorContext is what an editor plugin reports about the focused file.
+type EditorContext struct {
+	// Editor names the editor, e.g. "nvim" or "vscode".
+	Editor   string
+	File     string `json:",omitempty"`
+	Project  string `json:",omitempty"`
+	Language string `json:",omitempty"`
+}
+
+// editorHeartbeat is the JSON body of a heartbeat posted by a plugin.
+type editorHeartbeat struct {
+	Editor   string `json:"editor"`
+	File     string `json:"file"`
+	Project  string `json:"project"`
+	Language string `json:"language"`
+	// PID is the ID of the editor process, if the plugin knows it.
+	PID int `json:"pid"`
+
+	time time.Time
+}
+
+// EditorSocketPath returns the path of the unix socket the daemon
+// receives editor heartbeats on by default, creating its directory if
+// needed (see privateDir).
+func EditorSocketPath() (string, error) {
+	dir := runtimeDir()
+	return filepath.Join(dir, "editor.sock"), privateDir(dir)
+}
+
+// EditorServer receives heartbeats from editor plugins over HTTP and
+// fills in the Editor of the focused window from them. Plugins post
+// {"editor": "nvim", "file": "...", "project": "...", "language": "...",
+// "pid": 1234} to /heartbeat whenever a file gains focus or is edited,
+// as application/json. Requests with an Origin header, which only
+// browsers send, are refused, so that web pages can't forge heartbeats.
+//
+// A heartbeat is matched to the focused window if the editor process is
+// the window's process or runs below it (e.g. in a terminal), or, for
+// heartbeats without a PID, if the file's name is in the window's title.
+type EditorServer struct {
+	// Timeout is how long a heartbeat holds. If zero,
+	// DefaultEditorTimeout is used.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	beats map[string]*editorHeartbeat
+	l     net.Listener
+}
+
+var _ Collector = (*EditorServer)(nil)
+
+// Listen starts serving heartbeats on addr, which is either the path of
+// a unix socket (any path containing a slash) or a loopback TCP address
+// such as "127.0.0.1:5115". An existing socket is only replaced if no
+// server answers on it.
+func (s *EditorServer) Listen(addr string) error {
+	var l net.Listener
+	var err error
+	if strings.Contains(addr, "/") {
+		if err := os.MkdirAll(filepath.Dir(addr), 0700); err != nil {
+			return err
+		}
+		if fi, err := os.Lstat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			// only a socket left behind by a daemon that didn't exit
+			// cleanly refuses connections.
+			conn, err := net.DialTimeout("unix", addr, time.Second)
+			if err == nil {
+				conn.Close()
+				return fmt.Errorf("editor: %s is in use by another uv daemon", addr)
+			}
+			if !errors.Is(err, syscall.ECONNREFUSED) {
+				return err
+			}
+			os.Remove(addr)
+		}
+		l, err = net.Listen("unix", addr)
+	} else {
+		host, _, splitErr := net.SplitHostPort(addr)
+		if splitErr != nil {
+			return splitErr
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("editor: %s is not a loopback address", addr)
+		}
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.l = l
+	s.mu.Unlock()
+	go http.Serve(l, s)
+	return nil
+}
+
+// Close stops serving heartbeats.
+func (s *EditorServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.l == nil {
+		return nil
+	}
+	err := s.l.Close()
+	s.l = nil
+	return err
+}
+
+func (s *EditorServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/heartbeat" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// web pages can make browsers post to a loopback address, but only
+	// with a CORS preflight for JSON, and always with an Origin.
+	if r.Header.Get("Origin") != "" {
+		http.Error(w, "cross-origin heartbeats are not allowed", http.StatusForbidden)
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		http.Error(w, "heartbeats must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var beat editorHeartbeat
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&beat); err != nil {
+		http.Error(w, "invalid heartbeat: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if beat.Editor == "" {
+		http.Error(w, "invalid heartbeat: no editor", http.StatusBadRequest)
+		return
+	}
+	s.add(&beat, time.Now())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// add records beat, received at t, replacing the previous heartbeat of
+// the same editor process.
+func (s *EditorServer) add(beat *editorHeartbeat, t time.Time) {
+	beat.time = t
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.beats == nil {
+		s.beats = make(map[string]*editorHeartbeat)
+	}
+	s.beats[fmt.Sprintf("%s/%d", beat.Editor, beat.PID)] = beat
+}
+
+// fresh returns the heartbeats received within the timeout before t,
+// forgetting older ones.
+func (s *EditorServer) fresh(t time.Time) []*editorHeartbeat {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultEditorTimeout
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var beats []*editorHeartbeat
+	for key, beat := range s.beats {
+		if t.Sub(beat.time) > timeout {
+			delete(s.beats, key)
+			continue
+		}
+		beats = append(beats, beat)
+	}
+	return beats
+}
+
+func (s *EditorServer) Collect(snap *Snapshot) error {
+	var active *Window
+	for _, w := range snap.Windows {
+		if w.ID == snap.Active {
+			active = w
+		}
+	}
+	if active == nil {
+		return nil
+	}
+	t := snap.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	beats := s.fresh(t)
+	if len(beats) == 0 {
+		return nil
+	}
+
+	var procs map[int]*procStat
+	var best *editorHeartbeat
+	for _, beat := range beats {
+		if beat.PID > 0 {
+			if active.PID <= 0 {
+				continue
+			}
+			if procs == nil {
+				procs = readProcStats()
+			}
+			if !isDescendant(beat.PID, active.PID, procs) {
+				continue
+			}
+		} else if beat.File == "" || !strings.Contains(active.Name, filepath.Base(beat.File)) {
+			continue
+		}
+		if best == nil || beat.time.After(best.time) {
+			best = beat
+		}
+	}
+	if best != nil {
+		active.Editor = &EditorContext{Editor: best.Editor, File: best.File, Project: best.Project, Language: best.Language}
+	}
+	return nil
+}
+
+// ProjectLabel is a label function for NewTimeline and NewAggTime that
+// labels editor windows after the project reported by the editor, and
+// other windows after their application.
+func ProjectLabel(w *Window) string {
+	if w != nil && w.Editor != nil && w.Editor.Project != "" {
+		return w.Editor.Project
+	}
+	return appID(w)
+}
+
+// LanguageLabel is a label function for NewTimeline and NewAggTime that
+// labels editor windows after the language of the file reported by the
+// editor, and other windows after their application.
+func LanguageLabel(w *Window) string {
+	if w != nil && w.Editor != nil && w.Editor.Language != "" {
+		return w.Editor.Language
+	}
+	return appID(w)
+}
@@ -0,0 +1,194 @@
+package ultraViolet
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEditorServerHTTP(t *testing.T) {
+	s := &EditorServer{}
+	cases := []struct {
+		method, path, body  string
+		contentType, origin string
+		expected            int
+	}{
+		{"POST", "/heartbeat", `{"editor":"nvim","file":"/src/uv/show.go","project":"uv","language":"go","pid":42}`, "application/json", "", http.StatusNoContent},
+		{"GET", "/heartbeat", "", "", "", http.StatusMethodNotAllowed},
+		{"POST", "/", `{"editor":"nvim"}`, "application/json", "", http.StatusNotFound},
+		{"POST", "/heartbeat", `{"editor":`, "application/json", "", http.StatusBadRequest},
+		{"POST", "/heartbeat", `{"file":"/src/uv/show.go"}`, "application/json; charset=utf-8", "", http.StatusBadRequest},
+		// what a web page can make a browser send without a preflight.
+		{"POST", "/heartbeat", `{"editor":"forged","pid":43}`, "text/plain", "", http.StatusUnsupportedMediaType},
+		{"POST", "/heartbeat", `{"editor":"forged","pid":44}`, "", "", http.StatusUnsupportedMediaType},
+		{"POST", "/heartbeat", `{"editor":"forged","pid":45}`, "application/json", "https://example.com", http.StatusForbidden},
+	}
+	for i, c := range cases {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		s.ServeHTTP(rec, req)
+		if rec.Code != c.expected {
+			t.Errorf("case%d: %d %s", i, rec.Code, rec.Body)
+		}
+	}
+	beats := s.fresh(time.Now())
+	if len(beats) != 1 || beats[0].Project != "uv" || beats[0].PID != 42 {
+		t.Errorf("beats: %+v", beats)
+	}
+}
+
+func TestEditorServerListen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uv-editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &EditorServer{}
+	path := filepath.Join(dir, "run", "editor.sock")
+	if err := s.Listen(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Post("http://uv/heartbeat", "application/json", strings.NewReader(`{"editor":"vscode","file":"/src/uv/main.go","project":"uv","language":"go"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status: %s", resp.Status)
+	}
+
+	snap := &Snapshot{Windows: []*Window{&Window{ID: 1, Name: "main.go - uv - Visual Studio Code"}}, Active: 1}
+	if err := s.Collect(snap); err != nil {
+		t.Fatal(err)
+	}
+	expected := &EditorContext{Editor: "vscode", File: "/src/uv/main.go", Project: "uv", Language: "go"}
+	if !reflect.DeepEqual(snap.Windows[0].Editor, expected) {
+		t.Errorf("editor: %+v", snap.Windows[0].Editor)
+	}
+
+	// a second server doesn't take the socket of a running one, but does
+	// take over one left behind.
+	if err := (&EditorServer{}).Listen(path); err == nil {
+		t.Error("socket taken from a running server")
+	}
+	stale, err := net.Listen("unix", filepath.Join(dir, "stale.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	again := &EditorServer{}
+	if err := again.Listen(filepath.Join(dir, "stale.sock")); err != nil {
+		t.Errorf("stale socket: %v", err)
+	}
+	again.Close()
+
+	if err := (&EditorServer{}).Listen("0.0.0.0:0"); err == nil {
+		t.Error("listening on all interfaces")
+	}
+	tcp := &EditorServer{}
+	if err := tcp.Listen("127.0.0.1:0"); err != nil {
+		t.Error(err)
+	}
+	tcp.Close()
+}
+
+func TestEditorSocketPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file modes")
+	}
+	tmp, err := ioutil.TempDir("", "uv-runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer setenv("XDG_RUNTIME_DIR", tmp)()
+
+	path, err := EditorSocketPath()
+	if err != nil || path != filepath.Join(tmp, "ultra-violet", "editor.sock") {
+		t.Fatalf("path: %s %v", path, err)
+	}
+	// made by someone else beforehand, open to others.
+	if err := os.Chmod(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EditorSocketPath(); err == nil {
+		t.Error("directory open to others")
+	}
+}
+
+func TestEditorServerCollect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uv-editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { procDir = d }(procDir)
+	procDir = dir
+
+	// nvim in kitty, and a JetBrains IDE.
+	writeProc(t, 300, 1, 0, -1, 1, "kitty")
+	writeProc(t, 301, 300, 34816, 310, 2, "zsh")
+	writeProc(t, 310, 301, 34816, 310, 3, "nvim")
+	writeProc(t, 400, 1, 0, -1, 1, "java")
+
+	now := time.Date(2017, 1, 1, 9, 0, 0, 0, time.UTC)
+	s := &EditorServer{}
+	s.add(&editorHeartbeat{Editor: "nvim", File: "/src/uv/show.go", Project: "uv", Language: "go", PID: 310}, now.Add(-time.Minute))
+	s.add(&editorHeartbeat{Editor: "idea", File: "/src/app/Main.kt", Project: "app", Language: "kotlin", PID: 400}, now.Add(-3*time.Minute))
+	s.add(&editorHeartbeat{Editor: "vscode", File: "/src/web/index.ts", Project: "web", Language: "typescript"}, now)
+
+	cases := []struct {
+		w        *Window
+		expected *EditorContext
+	}{
+		{&Window{ID: 1, PID: 300, Name: "nvim"}, &EditorContext{Editor: "nvim", File: "/src/uv/show.go", Project: "uv", Language: "go"}},
+		// the heartbeat has expired.
+		{&Window{ID: 1, PID: 400, Name: "app – Main.kt"}, nil},
+		{&Window{ID: 1, Name: "index.ts - web - Visual Studio Code"}, &EditorContext{Editor: "vscode", File: "/src/web/index.ts", Project: "web", Language: "typescript"}},
+		{&Window{ID: 1, PID: 500, Name: "Inbox - Mail"}, nil},
+	}
+	for i, c := range cases {
+		snap := &Snapshot{Time: now, Windows: []*Window{c.w, &Window{ID: 2, PID: 300}}, Active: 1}
+		if err := s.Collect(snap); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c.w.Editor, c.expected) || snap.Windows[1].Editor != nil {
+			t.Errorf("case%d: %+v", i, c.w.Editor)
+		}
+	}
+	if len(s.fresh(now)) != 2 {
+		t.Errorf("expired heartbeats kept: %v", s.beats)
+	}
+
+	w := &Window{Name: "Inbox - Mail", Editor: &EditorContext{Editor: "nvim", Project: "uv", Language: "go"}}
+	if ProjectLabel(w) != "uv" || LanguageLabel(w) != "go" {
+		t.Errorf("labels: %s %s", ProjectLabel(w), LanguageLabel(w))
+	}
+	w.Editor = nil
+	if ProjectLabel(w) != "Mail" || LanguageLabel(w) != "Mail" {
+		t.Errorf("labels without editor: %s %s", ProjectLabel(w), LanguageLabel(w))
+	}
+}
@@ -0,0 +1,53 @@
+// Sends the active tab of every browser window to the uv native messaging
+// host (uv browser-host) whenever one of them changes:
+//
+//     {"tabs": [{"window": 1, "focused": true,
+//                "url": "https://example.org/", "title": "Example"}]}
+//
+// The host saves them for uv track and uv daemon, which match the tabs
+// to the windows of the browser by their titles.
+
+const HOST = 'io.github.aimof.ultra_violet';
+
+let port = null;
+
+function connect() {
+    port = chrome.runtime.connectNative(HOST);
+    port.onDisconnect.addListener(() => {
+        port = null;
+        // the host is missing or exited; try again later.
+        setTimeout(connect, 60 * 1000);
+    });
+    send();
+}
+
+async function send() {
+    if (port === null)
+        return;
+    const tabs = await chrome.tabs.query({active: true, windowType: 'normal'});
+    let focused = chrome.windows.WINDOW_ID_NONE;
+    try {
+        focused = (await chrome.windows.getLastFocused()).id;
+    } catch (e) {
+        // no window is left.
+    }
+    port.postMessage({
+        tabs: tabs.map(tab => ({
+            window: tab.windowId,
+            focused: tab.windowId === focused,
+            url: tab.url || '',
+            title: tab.title || '',
+        })),
+    });
+}
+
+chrome.tabs.onActivated.addListener(send);
+chrome.tabs.onRemoved.addListener(send);
+chrome.tabs.onUpdated.addListener((tabId, change, tab) => {
+    if (tab.active && (change.url !== undefined || change.title !== undefined))
+        send();
+});
+chrome.windows.onFocusChanged.addListener(send);
+chrome.windows.onRemoved.addListener(send);
+
+connect();
@@ -0,0 +1,41 @@
+-- Sends a heartbeat to the uv daemon when a buffer gains focus or is
+-- written, so that uv records the file, project and language you work on.
+-- The project is the name of the directory of the git repository of the
+-- file, or else of the working directory.
+
+local uv = vim.uv or vim.loop
+
+local socket = (os.getenv('XDG_RUNTIME_DIR') or '/tmp') .. '/ultra-violet/editor.sock'
+local last = { file = nil, time = 0 }
+
+local function project(file)
+  local git = vim.fs.find('.git', { path = vim.fs.dirname(file), upward = true })[1]
+  return vim.fs.basename(git and vim.fs.dirname(git) or vim.fn.getcwd())
+end
+
+local function heartbeat(force)
+  local file = vim.api.nvim_buf_get_name(0)
+  if file == '' or vim.bo.buftype ~= '' then
+    return
+  end
+  -- one heartbeat a minute for the same file is enough.
+  local now = uv.now()
+  if not force and file == last.file and now - last.time < 60000 then
+    return
+  end
+  last.file, last.time = file, now
+
+  local body = vim.json.encode({
+    editor = 'nvim',
+    file = file,
+    project = project(file),
+    language = vim.bo.filetype,
+    pid = vim.fn.getpid(),
+  })
+  vim.system({ 'curl', '-s', '-m', '2', '--unix-socket', socket,
+    '-H', 'Content-Type: application/json', '-d', body, 'http://uv/heartbeat' })
+end
+
+local group = vim.api.nvim_create_augroup('UltraViolet', {})
+vim.api.nvim_create_autocmd({ 'BufEnter', 'FocusGained' }, { group = group, callback = function() heartbeat(true) end })
+vim.api.nvim_create_autocmd({ 'BufWritePost', 'CursorHold', 'CursorHoldI' }, { group = group, callback = function() heartbeat(false) end })
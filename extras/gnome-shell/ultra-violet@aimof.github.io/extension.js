@@ -0,0 +1,94 @@
+// Exposes the windows of GNOME Shell to the uv "gnome" tracker.
+//
+// The object /io/github/aimof/UltraViolet is exported on GNOME Shell's
+// session bus connection (bus name org.gnome.Shell). Its Windows method
+// returns a JSON document:
+//
+//     {"active": 12, "workspace": 0, "windows": [
+//         {"id": 12, "title": "...", "wm_class": "firefox",
+//          "wm_class_instance": "Navigator", "pid": 4242,
+//          "workspace": 0, "visible": true}]}
+//
+// where workspace is -1 for windows shown on all workspaces. The Changed
+// signal is emitted when the focus, the workspace or the title of the
+// focused window changes.
+
+import Gio from 'gi://Gio';
+import Meta from 'gi://Meta';
+import {Extension} from 'resource:///org/gnome/shell/extensions/extension.js';
+
+const PATH = '/io/github/aimof/UltraViolet';
+const IFACE = `
+<node>
+  <interface name="io.github.aimof.UltraViolet">
+    <method name="Windows">
+      <arg type="s" direction="out" name="windows"/>
+    </method>
+    <signal name="Changed"/>
+  </interface>
+</node>`;
+
+export default class UltraVioletExtension extends Extension {
+    enable() {
+        this._dbus = Gio.DBusExportedObject.wrapJSObject(IFACE, this);
+        this._dbus.export(Gio.DBus.session, PATH);
+
+        this._signals = [
+            [global.display, global.display.connect('notify::focus-window', () => this._focusChanged())],
+            [global.display, global.display.connect('window-created', () => this._changed())],
+            [global.workspace_manager, global.workspace_manager.connect('active-workspace-changed', () => this._changed())],
+        ];
+        this._focusChanged();
+    }
+
+    disable() {
+        this._unwatchTitle();
+        for (const [object, id] of this._signals)
+            object.disconnect(id);
+        this._signals = null;
+        this._dbus.unexport();
+        this._dbus = null;
+    }
+
+    Windows() {
+        const workspace = global.workspace_manager.get_active_workspace();
+        const focus = global.display.get_focus_window();
+        const windows = global.display.list_all_windows()
+            .filter(w => !w.is_skip_taskbar() && w.get_window_type() !== Meta.WindowType.DESKTOP)
+            .map(w => ({
+                id: w.get_id(),
+                title: w.get_title() ?? '',
+                wm_class: w.get_wm_class() ?? '',
+                wm_class_instance: w.get_wm_class_instance() ?? '',
+                pid: w.get_pid(),
+                workspace: w.is_on_all_workspaces() ? -1 : (w.get_workspace()?.index() ?? -1),
+                visible: !w.minimized && w.showing_on_its_workspace() && w.located_on_workspace(workspace),
+            }));
+        return JSON.stringify({
+            active: focus ? focus.get_id() : 0,
+            workspace: workspace.index(),
+            windows,
+        });
+    }
+
+    _changed() {
+        this._dbus?.emit_signal('Changed', null);
+    }
+
+    _focusChanged() {
+        this._unwatchTitle();
+        const focus = global.display.get_focus_window();
+        if (focus) {
+            this._titleWindow = focus;
+            this._titleId = focus.connect('notify::title', () => this._changed());
+        }
+        this._changed();
+    }
+
+    _unwatchTitle() {
+        if (this._titleWindow) {
+            this._titleWindow.disconnect(this._titleId);
+            this._titleWindow = null;
+        }
+    }
+}
@@ -0,0 +1,614 @@
+package ultraViolet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// The formats Snapshots are written in. FormatJSON writes every Snapshot
+// in full on a line of JSON, as uv always did. FormatDelta writes only
+// the windows that were added, removed or changed since the previous
+// Snapshot, with a keyframe holding all of them now and then.
+//
+// Both are lines of JSON. A file in FormatDelta starts with a header
+// line, {"Format": "ultra-violet", "Version": 2}, which a DeltaEncoder
+// writes before its first Snapshot and every keyframe, so that the end
+// of a file can be read on its own; files without a header are in
+// FormatJSON (version 1). Since a header switches the format of the
+// lines after it, files can be appended to in either format and
+// concatenated.
+const (
+	FormatJSON  = "json"
+	FormatDelta = "delta"
+)
+
+// Formats lists the formats Snapshots can be written in.
+var Formats = []string{FormatJSON, FormatDelta}
+
+// formatName is the Format of header lines.
+const formatName = "ultra-violet"
+
+// The versions of the format in header lines.
+const (
+	formatVersionJSON  = 1
+	formatVersionDelta = 2
+)
+
+// DefaultKeyframeInterval is how many Snapshots in FormatDelta follow a
+// keyframe before the next one by default: an hour at 30s intervals.
+const DefaultKeyframeInterval = 120
+
+// formatHeader is a header line.
+type formatHeader struct {
+	Format  string
+	Version int
+}
+
+// windowKey identifies a window in a Snapshot.
+type windowKey struct {
+	ID      int
+	Display string `json:",omitempty"`
+}
+
+func keyOf(w *Window) windowKey {
+	return windowKey{ID: w.ID, Display: w.Display}
+}
+
+// deltaRecord is a line of a file in FormatDelta. Keyframes are
+// Snapshots, whose Windows are all there (an empty list if there are
+// none). The Windows of other Snapshots are null, and are those of the
+// previous Snapshot without Removed, with Changed in place of the
+// windows of the same ID, followed by Added. Markers hold their Snapshot
+// as is and don't count as the previous Snapshot.
+type deltaRecord struct {
+	Snapshot
+	Added   []*Window   `json:",omitempty"`
+	Changed []*Window   `json:",omitempty"`
+	Removed []windowKey `json:",omitempty"`
+
+	// the fields of formatHeader, so that a line can be decoded before
+	// knowing whether it is a header.
+	Format  string `json:",omitempty"`
+	Version int    `json:",omitempty"`
+}
+
+// Encoder writes Snapshots to a data file.
+type Encoder interface {
+	Encode(snap *Snapshot) error
+}
+
+// NewEncoder returns an Encoder writing Snapshots to w in format, which
+// is one of Formats.
+func NewEncoder(w io.Writer, format string) (Encoder, error) {
+	switch format {
+	case FormatJSON:
+		return &JSONEncoder{w: w}, nil
+	case FormatDelta:
+		return &DeltaEncoder{w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q; choose one of %s", format, Formats)
+}
+
+// writeLines writes vs as lines of JSON in a single write, so that other
+// writers of the file never come in between.
+func writeLines(w io.Writer, vs ...interface{}) error {
+	var b []byte
+	for _, v := range vs {
+		line, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		b = append(append(b, line...), '\n')
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// AppendEncoder returns an Encoder appending Snapshots in format to a
+// data file of size bytes whose contents are read from r. If format is
+// "", the Snapshots are appended in the format the file ends in, or in
+// FormatDelta if it is empty, so that files of older versions of uv stay
+// in FormatJSON.
+//
+// Only the end of the file is read: back to the last header, or to the
+// last Snapshot with windows in FormatJSON. A DeltaEncoder carries on
+// from the last Snapshot of the file if it is in FormatDelta; otherwise,
+// or if its windows can't be told, it starts with a header. A
+// JSONEncoder starts with one if the file ends in FormatDelta. Broken
+// lines are skipped, and a last line cut short is ended, so that the
+// Snapshots appended are on lines of their own.
+func AppendEncoder(r io.ReaderAt, size int64, w io.Writer, format string) (Encoder, error) {
+	if format != "" {
+		if _, err := NewEncoder(nil, format); err != nil {
+			return nil, err
+		}
+	}
+	start, err := tailStart(r, size)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDecoderAt(io.NewSectionReader(r, start, size-start), start, FormatJSON)
+	d.Skip = func(*LineError) {}
+	for err == nil {
+		_, err = d.Decode()
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	if d.partial {
+		if _, err := w.Write([]byte("\n")); err != nil {
+			return nil, err
+		}
+	}
+
+	if format == "" {
+		format = FormatDelta
+		if size > 0 && !d.delta {
+			format = FormatJSON
+		}
+	}
+	e, _ := NewEncoder(w, format)
+	switch e := e.(type) {
+	case *JSONEncoder:
+		e.header = d.delta
+	case *DeltaEncoder:
+		if d.delta {
+			e.started = true
+			if d.keyed {
+				e.setPrev(d.windows)
+				e.sinceKey = d.sinceKey
+			}
+		}
+	}
+	return e, nil
+}
+
+// tailStart returns the offset of the lines at the end of the data file
+// r of size bytes that tell how to append to it: those from the last
+// header on, or, in FormatJSON, from the last Snapshot with windows,
+// since the keyframes of FormatDelta come right after a header. The
+// file is read back from its end until then.
+func tailStart(r io.ReaderAt, size int64) (int64, error) {
+	const chunk = 64 << 10
+	var (
+		// buf holds what is left to look at of the bytes from pos on.
+		buf []byte
+		pos = size
+		// full is the offset of the line after the one looked at, if
+		// it is a Snapshot with windows.
+		full = int64(-1)
+		// delta is set once a delta was seen, in which case the last
+		// header is what tells the windows, whatever comes after it.
+		delta bool
+	)
+	for {
+		i := bytes.LastIndexByte(buf, '\n')
+		if i < 0 && pos > 0 {
+			n := int64(chunk)
+			if n > pos {
+				n = pos
+			}
+			pos -= n
+			b := make([]byte, n, int(n)+len(buf))
+			if _, err := r.ReadAt(b, pos); err != nil && err != io.EOF {
+				return 0, err
+			}
+			buf = append(b, buf...)
+			continue
+		}
+		offset := pos + int64(i+1)
+		text := bytes.TrimSpace(buf[i+1:])
+		if len(text) > 0 {
+			var line struct {
+				Format                  string
+				Windows                 json.RawMessage
+				Added, Changed, Removed json.RawMessage
+			}
+			if json.Unmarshal(text, &line) == nil {
+				if line.Format == formatName {
+					return offset, nil
+				}
+				if full >= 0 && !delta {
+					// not preceded by a header.
+					return full, nil
+				}
+				full = -1
+				if line.Format == "" && len(line.Windows) > 0 && line.Windows[0] == '[' {
+					full = offset
+				}
+				delta = delta || line.Added != nil || line.Changed != nil || line.Removed != nil
+			} else {
+				full = -1
+			}
+		}
+		if i < 0 {
+			// the start of the file.
+			if full >= 0 {
+				return full, nil
+			}
+			return 0, nil
+		}
+		buf = buf[:i]
+	}
+}
+
+// JSONEncoder writes Snapshots in FormatJSON.
+type JSONEncoder struct {
+	w io.Writer
+	// header is set if a header must come first, after lines in
+	// another format. Older versions of uv don't read headers, so files
+	// in FormatJSON start without one.
+	header bool
+}
+
+// NewJSONEncoder returns a JSONEncoder writing to w.
+func NewJSONEncoder(w io.Writer) *JSONEncoder {
+	return &JSONEncoder{w: w}
+}
+
+func (e *JSONEncoder) Encode(snap *Snapshot) error {
+	if e.header {
+		if err := writeLines(e.w, &formatHeader{Format: formatName, Version: formatVersionJSON}, snap); err != nil {
+			return err
+		}
+		e.header = false
+		return nil
+	}
+	return writeLines(e.w, snap)
+}
+
+// DeltaEncoder writes Snapshots in FormatDelta.
+type DeltaEncoder struct {
+	// KeyframeInterval is how many Snapshots follow a keyframe before
+	// the next one. If zero, DefaultKeyframeInterval is used.
+	KeyframeInterval int
+
+	w        io.Writer
+	started  bool
+	prev     map[windowKey]Window
+	order    []windowKey
+	sinceKey int
+}
+
+// NewDeltaEncoder returns a DeltaEncoder writing to w.
+func NewDeltaEncoder(w io.Writer) *DeltaEncoder {
+	return &DeltaEncoder{w: w}
+}
+
+func (e *DeltaEncoder) Encode(snap *Snapshot) error {
+	header := &formatHeader{Format: formatName, Version: formatVersionDelta}
+	rec := &deltaRecord{Snapshot: *snap}
+	if snap.IsMarker() {
+		lines := []interface{}{rec}
+		if !e.started {
+			lines = []interface{}{header, rec}
+		}
+		if err := writeLines(e.w, lines...); err != nil {
+			return err
+		}
+		e.started = true
+		return nil
+	}
+
+	interval := e.KeyframeInterval
+	if interval <= 0 {
+		interval = DefaultKeyframeInterval
+	}
+	rec.Windows = nil
+	if e.prev == nil || e.sinceKey >= interval || !e.diff(rec, snap.Windows) {
+		rec.Windows = snap.Windows
+		if rec.Windows == nil {
+			rec.Windows = []*Window{}
+		}
+		rec.Added, rec.Changed, rec.Removed = nil, nil, nil
+		e.sinceKey = 0
+	} else {
+		e.sinceKey++
+	}
+	lines := []interface{}{rec}
+	if rec.Windows != nil {
+		lines = []interface{}{header, rec}
+	}
+	if err := writeLines(e.w, lines...); err != nil {
+		// the reader can't tell what the next delta applies to.
+		e.prev = nil
+		return err
+	}
+
+	e.started = true
+	e.setPrev(snap.Windows)
+	return nil
+}
+
+// setPrev sets the windows of the previous Snapshot. They are copied, in
+// case the caller changes them afterwards.
+func (e *DeltaEncoder) setPrev(windows []*Window) {
+	e.prev = make(map[windowKey]Window, len(windows))
+	e.order = e.order[:0]
+	for _, w := range windows {
+		e.prev[keyOf(w)] = *w
+		e.order = append(e.order, keyOf(w))
+	}
+}
+
+// diff fills in the changes of rec from the previous Snapshot to
+// windows. It returns false if windows can't be described that way:
+// if windows kept from the previous Snapshot were reordered, or came
+// after new ones, or if windows share an ID.
+func (e *DeltaEncoder) diff(rec *deltaRecord, windows []*Window) bool {
+	if len(e.order) != len(e.prev) {
+		return false
+	}
+	current := make(map[windowKey]bool, len(windows))
+	for _, w := range windows {
+		if current[keyOf(w)] {
+			return false
+		}
+		current[keyOf(w)] = true
+	}
+	var kept []windowKey
+	for _, k := range e.order {
+		if current[k] {
+			kept = append(kept, k)
+		} else {
+			rec.Removed = append(rec.Removed, k)
+		}
+	}
+
+	for _, w := range windows {
+		k := keyOf(w)
+		old, ok := e.prev[k]
+		if !ok {
+			rec.Added = append(rec.Added, w)
+			continue
+		}
+		if len(rec.Added) > 0 || len(kept) == 0 || kept[0] != k {
+			return false
+		}
+		kept = kept[1:]
+		if !reflect.DeepEqual(&old, w) {
+			rec.Changed = append(rec.Changed, w)
+		}
+	}
+	return true
+}
+
+// ErrTruncatedLine is the Err of the LineError of a last line that
+// ends without a newline and can't be decoded: a line being written, or
+// one cut short by a crash.
+var ErrTruncatedLine = errors.New("truncated line")
+
+// LineError is the error of a line of a data file that can't be decoded.
+type LineError struct {
+	// File is the path of the data file, if known.
+	File string
+	// Line is the number of the line, counting from 1, or 0 if it isn't
+	// known because decoding started in the middle of the file.
+	Line int
+	// Offset is the offset of the line in the data file.
+	Offset int64
+	// Text is the line, without surrounding spaces.
+	Text []byte
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	pos := fmt.Sprintf("offset %d", e.Offset)
+	if e.Line > 0 {
+		pos = fmt.Sprintf("line %d", e.Line)
+	}
+	if e.File != "" {
+		pos = e.File + ": " + pos
+	}
+	return pos + ": " + e.Err.Error()
+}
+
+// versionError is the error of a header of an unsupported version, which
+// is returned even when skipping lines: the lines after it can't be
+// decoded either.
+type versionError int
+
+func (v versionError) Error() string {
+	return fmt.Sprintf("unsupported format version %d; upgrade uv", int(v))
+}
+
+// Decoder reads Snapshots from a data file in any of Formats, one line
+// at a time, whatever their length.
+//
+// The windows a Snapshot in FormatDelta has in common with the previous
+// one are shared between them, and must not be changed.
+type Decoder struct {
+	// Skip, if set, is called with the error of each line that can't be
+	// decoded, which Decode then skips instead of returning the error.
+	// The deltas after a skipped line can't be applied, and are skipped
+	// too until the next keyframe.
+	Skip func(err *LineError)
+
+	r      *bufio.Reader
+	offset int64
+	// line is the number of the last line read, or -1 if unknown.
+	line int
+	// partial is set if the last line read ends without a newline.
+	partial bool
+
+	delta    bool
+	keyed    bool
+	windows  []*Window
+	sinceKey int
+	// key is set if the last Snapshot can be decoded on its own.
+	key bool
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// NewDecoderAt returns a Decoder reading from r, which starts at offset
+// in a data file whose lines are in format there. Decoding can start at
+// the Offset of a Decoder of the file before a Snapshot for which
+// Keyframe was true, in the Format it had then.
+func NewDecoderAt(r io.Reader, offset int64, format string) *Decoder {
+	d := &Decoder{r: bufio.NewReader(r), offset: offset, delta: format == FormatDelta}
+	if offset > 0 {
+		d.line = -1
+	}
+	return d
+}
+
+// Offset returns the offset in the data file of the end of what was
+// decoded so far.
+func (d *Decoder) Offset() int64 {
+	return d.offset
+}
+
+// Format returns the format of the lines being decoded.
+func (d *Decoder) Format() string {
+	if d.delta {
+		return FormatDelta
+	}
+	return FormatJSON
+}
+
+// Keyframe reports whether the last Snapshot decoded holds all of its
+// windows, so that decoding can start at it.
+func (d *Decoder) Keyframe() bool {
+	return d.key
+}
+
+// Decode returns the next Snapshot, or io.EOF after the last one. Lines
+// that can't be decoded are returned as a *LineError, unless Skip is
+// set.
+func (d *Decoder) Decode() (*Snapshot, error) {
+	for {
+		offset := d.offset
+		b, err := d.r.ReadBytes('\n')
+		if len(b) == 0 || err != nil && err != io.EOF {
+			return nil, err
+		}
+		d.offset += int64(len(b))
+		if d.line >= 0 {
+			d.line++
+		}
+		d.partial = err == io.EOF
+		text := bytes.TrimSpace(b)
+		if len(text) == 0 {
+			continue
+		}
+
+		snap, err := d.decodeLine(text)
+		if err == nil {
+			if snap == nil {
+				// a header.
+				continue
+			}
+			return snap, nil
+		}
+		if d.partial && !json.Valid(text) {
+			err = ErrTruncatedLine
+		}
+		lineErr := &LineError{Line: d.line, Offset: offset, Text: text, Err: err}
+		if lineErr.Line < 0 {
+			lineErr.Line = 0
+		}
+		if _, ok := err.(versionError); ok || d.Skip == nil {
+			return nil, lineErr
+		}
+		d.Skip(lineErr)
+		// the line may have changed the windows of the deltas after it.
+		d.keyed, d.windows = false, nil
+	}
+}
+
+// decodeLine decodes a line, returning its Snapshot, or nil if it is a
+// header.
+func (d *Decoder) decodeLine(text []byte) (*Snapshot, error) {
+	var rec deltaRecord
+	if err := json.Unmarshal(text, &rec); err != nil {
+		return nil, err
+	}
+	if rec.Format == "" {
+		return d.snapshot(&rec)
+	}
+	if rec.Format != formatName {
+		return nil, fmt.Errorf("unknown format %q", rec.Format)
+	}
+	switch rec.Version {
+	case formatVersionJSON:
+		d.delta = false
+	case formatVersionDelta:
+		d.delta = true
+	default:
+		return nil, versionError(rec.Version)
+	}
+	d.keyed, d.windows = false, nil
+	return nil, nil
+}
+
+// snapshot returns the Snapshot of rec, which is not a header.
+func (d *Decoder) snapshot(rec *deltaRecord) (*Snapshot, error) {
+	snap := &rec.Snapshot
+	d.key = !d.delta
+	if !d.delta || snap.IsMarker() {
+		return snap, nil
+	}
+	if snap.Windows != nil {
+		d.keyed, d.windows, d.sinceKey = true, snap.Windows, 0
+		d.key = true
+	} else {
+		if !d.keyed {
+			return nil, errors.New("delta without a keyframe before it")
+		}
+		windows, err := applyDelta(d.windows, rec)
+		if err != nil {
+			return nil, err
+		}
+		d.windows = windows
+		d.sinceKey++
+	}
+	snap.Windows = d.windows
+	if len(snap.Windows) == 0 {
+		snap.Windows = nil
+	}
+	return snap, nil
+}
+
+// applyDelta returns windows changed as rec describes.
+func applyDelta(windows []*Window, rec *deltaRecord) ([]*Window, error) {
+	removed := make(map[windowKey]bool, len(rec.Removed))
+	for _, k := range rec.Removed {
+		removed[k] = true
+	}
+	changed := make(map[windowKey]*Window, len(rec.Changed))
+	for _, w := range rec.Changed {
+		changed[keyOf(w)] = w
+	}
+
+	applied := make([]*Window, 0, len(windows)+len(rec.Added))
+	for _, w := range windows {
+		k := keyOf(w)
+		if removed[k] {
+			delete(removed, k)
+			continue
+		}
+		if c, ok := changed[k]; ok {
+			delete(changed, k)
+			w = c
+		}
+		applied = append(applied, w)
+	}
+	if len(removed) > 0 || len(changed) > 0 {
+		return nil, errors.New("delta of windows that aren't in the previous snapshot")
+	}
+	return append(applied, rec.Added...), nil
+}
+
+// ReadStream reads all the Snapshots of a data file in any of Formats.
+func ReadStream(r io.Reader) (*Stream, error) {
+	return Collect(NewDecoder(r))
+}
@@ -0,0 +1,330 @@
+package ultraViolet
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// formatTestStream returns Snapshots with windows opening, closing,
+// changing and moving around.
+func formatTestStream() []*Snapshot {
+	at := func(m int) time.Time { return time.Date(2017, 1, 1, 9, m, 0, 0, time.UTC) }
+	term := &Window{ID: 1, Name: "vim - Terminal", Class: "xterm"}
+	mail := &Window{ID: 2, Name: "Inbox - Mail"}
+	web := &Window{ID: 3, Name: "Example - Mozilla Firefox", URL: "https://www.example.org/"}
+	web2 := &Window{ID: 3, Name: "Other - Mozilla Firefox", URL: "https://www.example.org/other"}
+	remote := &Window{ID: 1, Display: ":1", Name: "xterm"}
+	return []*Snapshot{
+		{Time: at(0), Windows: []*Window{term, mail}, Active: 1, Visible: []int{1}},
+		{Time: at(1), Windows: []*Window{term, mail}, Active: 2, Visible: []int{2}},
+		{Time: at(2), Windows: []*Window{term, mail, web}, Active: 3, Visible: []int{3}, Warnings: []string{"window 2: gone"}},
+		{Time: at(3), Event: EventLock},
+		// locked.
+		{Time: at(4)},
+		{Time: at(5), Windows: []*Window{term, web2, remote}, Active: 3, Idle: true, IdleTime: time.Minute},
+		{Time: at(6), Event: EventUnlock},
+		// reordered.
+		{Time: at(7), Windows: []*Window{web2, term, remote}, Active: 1},
+		{Time: at(8), Windows: []*Window{web2, remote}, Active: 3},
+	}
+}
+
+func encodeStream(t *testing.T, e Encoder, snaps []*Snapshot) {
+	for _, snap := range snaps {
+		if err := e.Encode(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	snaps := formatTestStream()
+	for _, format := range Formats {
+		var b bytes.Buffer
+		e, err := NewEncoder(&b, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encodeStream(t, e, snaps)
+		stream, err := ReadStream(&b)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if !reflect.DeepEqual(stream.Snapshots, snaps) {
+			t.Errorf("%s: %s", format, stream.Print())
+		}
+	}
+}
+
+func TestDeltaEncoder(t *testing.T) {
+	var b bytes.Buffer
+	e := &DeltaEncoder{w: &b, KeyframeInterval: 3}
+	encodeStream(t, e, formatTestStream())
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	kinds := make([]string, len(lines))
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, `{"Format":"ultra-violet","Version":2}`):
+			kinds[i] = "header"
+		case strings.Contains(line, `"Event":"`):
+			kinds[i] = "marker"
+		case strings.Contains(line, `"Windows":null`):
+			kinds[i] = "delta"
+		default:
+			kinds[i] = "key"
+		}
+	}
+	// every keyframe comes after a header, so that appending to a file
+	// only needs to read its end.
+	expected := []string{"header", "key", "delta", "delta", "marker", "delta", "header", "key", "marker", "header", "key", "delta"}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("lines: %v\n%s", kinds, b.String())
+	}
+	if !strings.Contains(lines[3], `"Added":[{"ID":3,`) || strings.Contains(lines[3], "Inbox") {
+		t.Errorf("delta: %s", lines[3])
+	}
+	if !strings.Contains(lines[11], `"Removed":[{"ID":1}]`) {
+		t.Errorf("removal: %s", lines[11])
+	}
+}
+
+func TestAppendEncoder(t *testing.T) {
+	snaps := formatTestStream()
+	var b bytes.Buffer
+	formats := []string{FormatJSON, FormatDelta, FormatDelta, FormatJSON, FormatDelta}
+	for i, format := range formats {
+		e, err := AppendEncoder(bytes.NewReader(b.Bytes()), int64(b.Len()), &b, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end := i*2 + 2
+		if end > len(snaps) {
+			end = len(snaps)
+		}
+		encodeStream(t, e, snaps[i*2:end])
+	}
+	if n := strings.Count(b.String(), `"Format"`); n != 3 {
+		t.Errorf("%d headers:\n%s", n, b.String())
+	}
+	stream, err := ReadStream(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stream.Snapshots, snaps) {
+		t.Error(stream.Print())
+	}
+
+	if _, err := AppendEncoder(bytes.NewReader(b.Bytes()), int64(b.Len()), &b, "xml"); err == nil {
+		t.Error("unknown format")
+	}
+	// a truncated line is ended, and followed by a keyframe.
+	b.Reset()
+	b.WriteString(`{"Format":"ultra-violet","Version":2}` + "\n" + `{"Time":"2017-01-01T09:00:00Z","Windows":[],"Act`)
+	e, err := AppendEncoder(bytes.NewReader(b.Bytes()), int64(b.Len()), &b, FormatDelta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodeStream(t, e, snaps[:1])
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 4 || !strings.HasSuffix(lines[1], `"Act`) || !strings.Contains(lines[3], `"Windows":[{"ID":1,`) {
+		t.Errorf("after a truncated line:\n%s", b.String())
+	}
+}
+
+// countingReaderAt counts the bytes read from it.
+type countingReaderAt struct {
+	r *bytes.Reader
+	n int64
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	r.n += int64(n)
+	return n, err
+}
+
+func TestAppendEncoderTail(t *testing.T) {
+	snaps := formatTestStream()
+	// a long file in each format.
+	long := make([]*Snapshot, 0, 1000*len(snaps))
+	for i := 0; i < 1000; i++ {
+		for _, snap := range snaps {
+			s := *snap
+			s.Time = s.Time.Add(time.Duration(i) * time.Hour)
+			long = append(long, &s)
+		}
+	}
+	for _, format := range Formats {
+		var b bytes.Buffer
+		e, err := NewEncoder(&b, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delta, ok := e.(*DeltaEncoder); ok {
+			delta.KeyframeInterval = 10
+		}
+		encodeStream(t, e, long)
+		size := int64(b.Len())
+
+		r := &countingReaderAt{r: bytes.NewReader(b.Bytes())}
+		e, err = AppendEncoder(r, size, &b, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.n > 128<<10 {
+			t.Errorf("%s: read %d bytes of %d", format, r.n, size)
+		}
+		// the file stays in its format.
+		if _, ok := e.(*DeltaEncoder); ok != (format == FormatDelta) {
+			t.Errorf("%s: %T", format, e)
+		}
+		encodeStream(t, e, snaps[:2])
+		if format == FormatDelta && !strings.Contains(b.String()[size:], `"Windows":null`) {
+			t.Errorf("%s: no delta after the end:\n%s", format, b.String()[size:])
+		}
+		stream, err := ReadStream(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(stream.Snapshots, append(long, snaps[:2]...)) {
+			t.Errorf("%s: %d snapshots", format, len(stream.Snapshots))
+		}
+	}
+
+	// new files are in FormatDelta; files of older versions of uv stay
+	// in FormatJSON.
+	for i, c := range []struct {
+		data  string
+		delta bool
+	}{
+		{"", true},
+		{`{"Time":"2017-01-01T09:00:00Z","Windows":null}` + "\n", false},
+		{`{"Format":"ultra-violet","Version":2}` + "\n" + `{"Time":"2017-01-01T09:00:00Z","Event":"lock"}` + "\n", true},
+	} {
+		e, err := AppendEncoder(strings.NewReader(c.data), int64(len(c.data)), ioutil.Discard, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := e.(*DeltaEncoder); ok != c.delta {
+			t.Errorf("case%d: %T", i, e)
+		}
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	cases := []string{
+		`{"Format":"ultra-violet","Version":3}`,
+		`{"Format":"thyme","Version":1}`,
+		// a delta after the keyframe got lost.
+		`{"Format":"ultra-violet","Version":2}` + "\n" + `{"Time":"2017-01-01T09:00:00Z","Windows":null,"Active":1}`,
+		`{"Format":"ultra-violet","Version":2}` + "\n" + `{"Windows":[]}` + "\n" + `{"Windows":null,"Removed":[{"ID":1}]}`,
+	}
+	for i, c := range cases {
+		d := NewDecoder(strings.NewReader(c))
+		var err error
+		for err == nil {
+			_, err = d.Decode()
+		}
+		if err == io.EOF {
+			t.Errorf("case%d: no error", i)
+		}
+	}
+}
+
+func TestDecoderLongLine(t *testing.T) {
+	// far longer than the buffers of a bufio.Scanner.
+	snap := &Snapshot{
+		Time:    time.Date(2017, 1, 1, 9, 0, 0, 0, time.UTC),
+		Windows: []*Window{{ID: 1, Name: strings.Repeat("a very long title ", 100000)}},
+	}
+	for _, format := range Formats {
+		var b bytes.Buffer
+		e, err := NewEncoder(&b, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encodeStream(t, e, []*Snapshot{snap, snap})
+		stream, err := ReadStream(&b)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if len(stream.Snapshots) != 2 || !reflect.DeepEqual(stream.Snapshots[1], snap) {
+			t.Errorf("%s: %d snapshots", format, len(stream.Snapshots))
+		}
+	}
+}
+
+func TestDecoderSkip(t *testing.T) {
+	type skipped struct {
+		Line int
+		Err  error
+	}
+	cases := []struct {
+		Data    string
+		Times   []int
+		Skipped []skipped
+	}{{
+		Data:    `{"Time":"2017-01-01T09:00:00Z"}` + "\n" + `{"Time":"2017-01-01T09:01:00Z",` + "\n\n" + `{"Time":"2017-01-01T09:02:00Z"}` + "\n",
+		Times:   []int{0, 2},
+		Skipped: []skipped{{Line: 2}},
+	}, {
+		// the deltas after a broken line wait for a keyframe.
+		Data: `{"Format":"ultra-violet","Version":2}` + "\n" +
+			`{"Time":"2017-01-01T09:00:00Z","Windows":[{"ID":1}]}` + "\n" +
+			`{"Time":"2017-01-01T09:01:00Z","Windows":null,"Added":[{"ID":2}` + "\n" +
+			`{"Time":"2017-01-01T09:02:00Z","Windows":null,"Removed":[{"ID":2}]}` + "\n" +
+			`{"Time":"2017-01-01T09:03:00Z","Windows":[{"ID":1}]}` + "\n" +
+			`{"Time":"2017-01-01T09:04:00Z","Windows":null,"Added":[{"ID":2}]}` + "\n",
+		Times:   []int{0, 3, 4},
+		Skipped: []skipped{{Line: 3}, {Line: 4}},
+	}, {
+		Data:    `{"Time":"2017-01-01T09:00:00Z"}` + "\n" + `["not", "a", "snapshot"]` + "\n" + `{"Time":"2017-01-01T09:01:00Z","Act`,
+		Times:   []int{0},
+		Skipped: []skipped{{Line: 2}, {Line: 3, Err: ErrTruncatedLine}},
+	}, {
+		// a last line without a newline is read all the same.
+		Data:  `{"Time":"2017-01-01T09:00:00Z"}`,
+		Times: []int{0},
+	}}
+	for i, c := range cases {
+		d := NewDecoder(strings.NewReader(c.Data))
+		var got []skipped
+		d.Skip = func(err *LineError) {
+			s := skipped{Line: err.Line}
+			if err.Err == ErrTruncatedLine {
+				s.Err = err.Err
+			}
+			got = append(got, s)
+		}
+		stream, err := Collect(d)
+		if err != nil {
+			t.Errorf("case%d: %s", i, err)
+			continue
+		}
+		var times []int
+		for _, snap := range stream.Snapshots {
+			times = append(times, snap.Time.Minute())
+		}
+		if !reflect.DeepEqual(times, c.Times) || !reflect.DeepEqual(got, c.Skipped) {
+			t.Errorf("case%d: %v %v", i, times, got)
+		}
+
+		// without Skip, the first broken line is an error.
+		_, err = ReadStream(strings.NewReader(c.Data))
+		if lineErr, ok := err.(*LineError); len(c.Skipped) > 0 && (!ok || lineErr.Line != c.Skipped[0].Line) {
+			t.Errorf("case%d: %v", i, err)
+		}
+	}
+
+	// a header of a later version stops decoding even with Skip.
+	d := NewDecoder(strings.NewReader(`{"Format":"ultra-violet","Version":3}` + "\n" + `{}` + "\n"))
+	d.Skip = func(*LineError) {}
+	if _, err := d.Decode(); err == nil || err == io.EOF {
+		t.Errorf("version 3: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package ultraViolet
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// CheckReport is what Check found in a data file.
+type CheckReport struct {
+	// Snapshots is how many Snapshots could be read.
+	Snapshots int
+	// Errors are those of the lines that couldn't, which were skipped.
+	Errors []*LineError
+}
+
+// Check reads the data file r to the end, skipping and reporting the
+// lines that can't be decoded. If repaired isn't nil, the Snapshots that
+// can be read are written to it in format, so that it ends up holding a
+// repaired copy of the file; if format is "", that is the format the
+// file starts in: FormatJSON without a header, or that of its header. If
+// quarantine isn't nil, the lines that can't be read are copied to it as
+// they were, so that they can be looked into.
+func Check(r io.Reader, repaired io.Writer, format string, quarantine io.Writer) (*CheckReport, error) {
+	var enc Encoder
+	if repaired != nil && format != "" {
+		var err error
+		if enc, err = NewEncoder(repaired, format); err != nil {
+			return nil, err
+		}
+	}
+	report := &CheckReport{}
+	var qerr error
+	d := NewDecoder(r)
+	d.Skip = func(err *LineError) {
+		report.Errors = append(report.Errors, err)
+		if quarantine != nil && qerr == nil {
+			_, qerr = fmt.Fprintf(quarantine, "%s\n", err.Text)
+		}
+	}
+	for {
+		snap, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Snapshots++
+		if enc == nil && repaired != nil {
+			// the header, if any, comes before the first Snapshot.
+			enc, _ = NewEncoder(repaired, d.Format())
+		}
+		if enc != nil {
+			if err := enc.Encode(snap); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, qerr
+}
+
+// RepairFile rewrites the data file in path in format (in the format it
+// is in if "") without the lines Check can't decode, which are appended
+// to path.quarantine. The file is replaced once its copy is complete,
+// holding its lock meanwhile, so that AppendFiles wait and then carry on
+// with the copy.
+func RepairFile(path, format string) (*CheckReport, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := LockFile(f); err != nil {
+		return nil, err
+	}
+	defer UnlockFile(f)
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	quarantine, err := os.OpenFile(path+".quarantine", os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer quarantine.Close()
+	tmp := path + ".repair"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+
+	report, err := Check(f, out, format, quarantine)
+	if err != nil {
+		return report, err
+	}
+	if err := out.Sync(); err != nil {
+		return report, err
+	}
+	if err := quarantine.Sync(); err != nil {
+		return report, err
+	}
+	return report, os.Rename(tmp, path)
+}
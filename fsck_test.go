@@ -0,0 +1,68 @@
+package ultraViolet
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	snaps := formatTestStream()
+	var b bytes.Buffer
+	e := NewDeltaEncoder(&b)
+	encodeStream(t, e, snaps[:3])
+	b.WriteString(`{"Time":"2017-01-01T09:03:00Z","Event":"lo` + "\n")
+	encodeStream(t, e, snaps[3:])
+	b.WriteString(`{"Time":"2017-01-01T09:09:00Z","Windo`)
+	data := b.String()
+
+	var repaired, quarantine bytes.Buffer
+	report, err := Check(strings.NewReader(data), &repaired, FormatJSON, &quarantine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the deltas after the broken line can't be applied until the
+	// keyframe of the reordered windows.
+	if report.Snapshots != 7 || len(report.Errors) != 4 || report.Errors[0].Line != 5 || report.Errors[3].Err != ErrTruncatedLine {
+		t.Errorf("report: %d snapshots, %v", report.Snapshots, report.Errors)
+	}
+	if lines := strings.Split(strings.TrimSpace(quarantine.String()), "\n"); len(lines) != 4 || !strings.HasSuffix(lines[3], `"Windo`) {
+		t.Errorf("quarantine:\n%s", quarantine.String())
+	}
+
+	// the repaired file reads without errors.
+	repairedJSON := repaired.String()
+	again, err := Check(&repaired, nil, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Snapshots != report.Snapshots || len(again.Errors) != 0 {
+		t.Errorf("repaired: %d snapshots, %v", again.Snapshots, again.Errors)
+	}
+
+	// without a format, the repaired file keeps that of the file: delta
+	// after a header, json without one.
+	cases := []struct {
+		in       string
+		expected string
+	}{
+		{data, FormatDelta},
+		{repairedJSON, FormatJSON},
+	}
+	for i, c := range cases {
+		var out bytes.Buffer
+		if _, err := Check(strings.NewReader(c.in), &out, "", nil); err != nil {
+			t.Fatalf("case%d: %v", i, err)
+		}
+		header := strings.HasPrefix(out.String(), `{"Format":"ultra-violet"`)
+		if out.Len() == 0 || header != (c.expected == FormatDelta) {
+			t.Errorf("case%d: %.80s", i, out.String())
+		}
+	}
+
+	report, err = Check(strings.NewReader(data[:strings.Index(data, `"lo`)-len(`{"Time":"2017-01-01T09:03:00Z","Event":`)]), nil, "", nil)
+	if err != nil || report.Snapshots != 3 || !reflect.DeepEqual(report.Errors, []*LineError(nil)) {
+		t.Errorf("intact: %v %v", report, err)
+	}
+}
@@ -0,0 +1,150 @@
+package ultraViolet
+
+// Rect is a rectangle on the screen, in pixels from the top left corner
+// of the screen (or of the layout of all monitors).
+type Rect struct {
+	X, Y          int
+	Width, Height int
+}
+
+// Area returns the number of pixels in r.
+func (r Rect) Area() int {
+	if r.Empty() {
+		return 0
+	}
+	return r.Width * r.Height
+}
+
+// Empty reports whether r contains no pixels.
+func (r Rect) Empty() bool {
+	return r.Width <= 0 || r.Height <= 0
+}
+
+// Intersect returns the largest rectangle contained by both r and s,
+// which is empty if they don't overlap.
+func (r Rect) Intersect(s Rect) Rect {
+	x0, y0 := maxInt(r.X, s.X), maxInt(r.Y, s.Y)
+	x1, y1 := minInt(r.X+r.Width, s.X+s.Width), minInt(r.Y+r.Height, s.Y+s.Height)
+	if x1 <= x0 || y1 <= y0 {
+		return Rect{}
+	}
+	return Rect{X: x0, Y: y0, Width: x1 - x0, Height: y1 - y0}
+}
+
+// subtract returns the parts of r outside s as at most four disjoint
+// rectangles.
+func (r Rect) subtract(s Rect) []Rect {
+	i := r.Intersect(s)
+	if i.Empty() {
+		return []Rect{r}
+	}
+	var parts []Rect
+	// above and below the intersection, full width.
+	if top := i.Y - r.Y; top > 0 {
+		parts = append(parts, Rect{X: r.X, Y: r.Y, Width: r.Width, Height: top})
+	}
+	if bottom := r.Y + r.Height - (i.Y + i.Height); bottom > 0 {
+		parts = append(parts, Rect{X: r.X, Y: i.Y + i.Height, Width: r.Width, Height: bottom})
+	}
+	// left and right of the intersection, as high as it.
+	if left := i.X - r.X; left > 0 {
+		parts = append(parts, Rect{X: r.X, Y: i.Y, Width: left, Height: i.Height})
+	}
+	if right := r.X + r.Width - (i.X + i.Width); right > 0 {
+		parts = append(parts, Rect{X: i.X + i.Width, Y: i.Y, Width: right, Height: i.Height})
+	}
+	return parts
+}
+
+// Monitor is a monitor of the screen.
+type Monitor struct {
+	// Name is the name of the monitor's output, such as "DP-1", or its
+	// index if outputs have no names.
+	Name string
+	Rect
+}
+
+// setGeometry sets the Monitor and VisibleArea of the windows of snap
+// from their Geometry and snap.Monitors. stacking lists the IDs of the
+// windows from bottom to top; windows missing from it are taken to be
+// below the others. Windows without Geometry are left alone.
+func setGeometry(snap *Snapshot, stacking []int) {
+	level := make(map[int]int, len(stacking))
+	for i, id := range stacking {
+		level[id] = i + 1
+	}
+	visible := make(map[int]bool, len(snap.Visible))
+	for _, id := range snap.Visible {
+		visible[id] = true
+	}
+
+	// the parts of the screen covered by monitors, without overlaps
+	// (e.g. of mirrored monitors).
+	var screen []Rect
+	for _, m := range snap.Monitors {
+		parts := []Rect{m.Rect}
+		for _, s := range screen {
+			parts = subtractAll(parts, s)
+		}
+		screen = append(screen, parts...)
+	}
+
+	for _, w := range snap.Windows {
+		if w.Geometry == nil || w.Geometry.Empty() {
+			continue
+		}
+		best := 0
+		for _, m := range snap.Monitors {
+			if a := w.Geometry.Intersect(m.Rect).Area(); a > best {
+				w.Monitor, best = m.Name, a
+			}
+		}
+		if !visible[w.ID] {
+			continue
+		}
+
+		shown := []Rect{*w.Geometry}
+		if len(screen) > 0 {
+			shown = shown[:0]
+			for _, s := range screen {
+				if i := w.Geometry.Intersect(s); !i.Empty() {
+					shown = append(shown, i)
+				}
+			}
+		}
+		for _, above := range snap.Windows {
+			if above == w || above.Geometry == nil || !visible[above.ID] || level[above.ID] <= level[w.ID] {
+				continue
+			}
+			shown = subtractAll(shown, *above.Geometry)
+		}
+		area := 0
+		for _, r := range shown {
+			area += r.Area()
+		}
+		w.VisibleArea = float64(area) / float64(w.Geometry.Area())
+	}
+}
+
+// subtractAll returns the parts of the disjoint rectangles rs outside s.
+func subtractAll(rs []Rect, s Rect) []Rect {
+	var parts []Rect
+	for _, r := range rs {
+		parts = append(parts, r.subtract(s)...)
+	}
+	return parts
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
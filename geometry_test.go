@@ -0,0 +1,98 @@
+package ultraViolet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRectIntersect(t *testing.T) {
+	cases := []struct {
+		r, s     Rect
+		expected Rect
+	}{
+		{Rect{0, 0, 100, 100}, Rect{50, 50, 100, 100}, Rect{50, 50, 50, 50}},
+		{Rect{0, 0, 100, 100}, Rect{10, 10, 20, 20}, Rect{10, 10, 20, 20}},
+		{Rect{0, 0, 100, 100}, Rect{100, 0, 100, 100}, Rect{}},
+		{Rect{0, 0, 100, 100}, Rect{-50, -50, 60, 60}, Rect{0, 0, 10, 10}},
+	}
+	for i, c := range cases {
+		if actual := c.r.Intersect(c.s); actual != c.expected {
+			t.Errorf("case%d: %v", i, actual)
+		}
+	}
+}
+
+func TestRectSubtract(t *testing.T) {
+	cases := []struct {
+		r, s     Rect
+		expected int
+	}{
+		{Rect{0, 0, 100, 100}, Rect{50, 50, 100, 100}, 7500},
+		{Rect{0, 0, 100, 100}, Rect{10, 10, 20, 20}, 9600},
+		{Rect{0, 0, 100, 100}, Rect{200, 0, 100, 100}, 10000},
+		{Rect{0, 0, 100, 100}, Rect{-10, -10, 200, 200}, 0},
+	}
+	for i, c := range cases {
+		parts := c.r.subtract(c.s)
+		area := 0
+		for j, p := range parts {
+			if !p.Intersect(c.s).Empty() {
+				t.Errorf("case%d: part %v overlaps %v", i, p, c.s)
+			}
+			for _, q := range parts[j+1:] {
+				if !p.Intersect(q).Empty() {
+					t.Errorf("case%d: parts %v and %v overlap", i, p, q)
+				}
+			}
+			area += p.Area()
+		}
+		if area != c.expected {
+			t.Errorf("case%d: %v", i, parts)
+		}
+	}
+}
+
+func TestSetGeometry(t *testing.T) {
+	snap := &Snapshot{
+		Windows: []*Window{
+			// half on each monitor, below window 2.
+			&Window{ID: 1, Geometry: &Rect{1800, 0, 200, 100}},
+			&Window{ID: 2, Geometry: &Rect{1900, 0, 100, 50}},
+			// partly off screen.
+			&Window{ID: 3, Geometry: &Rect{-100, 500, 200, 100}},
+			// hidden, so not covering window 3.
+			&Window{ID: 4, Geometry: &Rect{0, 500, 100, 100}},
+			&Window{ID: 5},
+		},
+		Visible: []int{1, 2, 3, 5},
+		Monitors: []*Monitor{
+			&Monitor{Name: "left", Rect: Rect{0, 0, 1920, 1080}},
+			&Monitor{Name: "right", Rect: Rect{1920, 0, 1920, 1080}},
+			// mirrors "left", which must not count twice.
+			&Monitor{Name: "mirror", Rect: Rect{0, 0, 1920, 1080}},
+		},
+	}
+	setGeometry(snap, []int{3, 1, 4, 2})
+	expected := []struct {
+		monitor string
+		area    float64
+	}{
+		{"left", 0.75},
+		{"right", 1},
+		{"left", 0.5},
+		{"left", 0},
+		{"", 0},
+	}
+	for i, w := range snap.Windows {
+		if w.Monitor != expected[i].monitor || w.VisibleArea != expected[i].area {
+			t.Errorf("window%d: %s %v", w.ID, w.Monitor, w.VisibleArea)
+		}
+	}
+
+	// without monitors, windows count as fully on screen.
+	snap.Monitors = nil
+	setGeometry(snap, []int{3, 1, 4, 2})
+	if areas := []float64{snap.Windows[0].VisibleArea, snap.Windows[2].VisibleArea}; !reflect.DeepEqual(areas, []float64{0.75, 1}) {
+		t.Errorf("no monitors: %v", areas)
+	}
+}
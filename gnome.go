@@ -0,0 +1,200 @@
+package ultraViolet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterTracker("gnome", NewGnomeTracker)
+}
+
+// The D-Bus object exported inside GNOME Shell by the companion
+// extension in extras/gnome-shell.
+const (
+	gnomeBusName   = "org.gnome.Shell"
+	gnomePath      = dbusObjectPath("/io/github/aimof/UltraViolet")
+	gnomeInterface = "io.github.aimof.UltraViolet"
+)
+
+// GnomeTracker tracks application usage on GNOME Shell (X11 and Wayland
+// sessions alike). Mutter doesn't let other programs list windows on
+// Wayland, so the tracker asks a companion GNOME Shell extension over
+// the session bus. Windows are Mutter windows; their ID is the window's
+// stable ID and their Desktop is the index of their workspace (-1 for
+// windows shown on all workspaces).
+type GnomeTracker struct {
+	mu  sync.Mutex
+	bus *dbusConn
+}
+
+var _ Tracker = (*GnomeTracker)(nil)
+var _ Watcher = (*GnomeTracker)(nil)
+var _ Prober = (*GnomeTracker)(nil)
+var _ ContextTracker = (*GnomeTracker)(nil)
+
+func NewGnomeTracker() Tracker {
+	return &GnomeTracker{}
+}
+
+func (t *GnomeTracker) Deps() string {
+	return `
+Install and enable the ultra-violet GNOME Shell extension:
+  cp -r extras/gnome-shell/ultra-violet@aimof.github.io ~/.local/share/gnome-shell/extensions/
+  gnome-extensions enable ultra-violet@aimof.github.io
+GNOME Shell only loads new extensions after logging out and in again.
+
+Note: this command prints out this message regardless of whether the dependencies are already installed.
+`
+}
+
+// gnomeWindows is the JSON document returned by the extension's Windows
+// method.
+type gnomeWindows struct {
+	Active    uint64        `json:"active"`
+	Workspace int           `json:"workspace"`
+	Windows   []gnomeWindow `json:"windows"`
+}
+
+type gnomeWindow struct {
+	ID         uint64 `json:"id"`
+	Title      string `json:"title"`
+	WMClass    string `json:"wm_class"`
+	WMInstance string `json:"wm_class_instance"`
+	PID        int    `json:"pid"`
+	Workspace  int    `json:"workspace"`
+	Visible    bool   `json:"visible"`
+}
+
+// Snap gives GNOME Shell DefaultCommandTimeout to reply.
+func (t *GnomeTracker) Snap() (*Snapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCommandTimeout)
+	defer cancel()
+	return t.SnapContext(ctx)
+}
+
+func (t *GnomeTracker) SnapContext(ctx context.Context) (*Snapshot, error) {
+	bus, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+	snap, err := gnomeSnap(ctx, bus)
+	if err != nil {
+		select {
+		case <-bus.Done():
+			t.close()
+		default:
+		}
+		return nil, err
+	}
+	return snap, nil
+}
+
+// Probe checks that the extension answers, so that sessions without it
+// fall back to another Tracker.
+func (t *GnomeTracker) Probe() error {
+	_, err := t.Snap()
+	return err
+}
+
+// Close closes the connection to the session bus, if any.
+func (t *GnomeTracker) Close() error {
+	return t.close()
+}
+
+// Watch listens to the Changed signal the extension emits when the
+// focus, the workspace or the title of the focused window changes.
+func (t *GnomeTracker) Watch(snaps chan<- *Snapshot, heartbeat time.Duration, stop <-chan struct{}) error {
+	bus, err := t.connect()
+	if err != nil {
+		return err
+	}
+	rule := fmt.Sprintf("type='signal',path='%s',interface='%s',member='Changed'", gnomePath, gnomeInterface)
+	if err := bus.AddMatch(rule); err != nil {
+		return err
+	}
+
+	changes := make(chan struct{}, 1)
+	failed := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case m := <-bus.Signals():
+				if m.Path != gnomePath || m.Interface != gnomeInterface {
+					continue
+				}
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			case <-bus.Done():
+				err := bus.Err()
+				if err == nil {
+					err = errors.New("gnome: session bus connection closed")
+				}
+				failed <- err
+				return
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return watchLoop(t, changes, failed, snaps, heartbeat, stop)
+}
+
+func (t *GnomeTracker) connect() (*dbusConn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.bus == nil {
+		bus, err := dbusSessionBus()
+		if err != nil {
+			return nil, err
+		}
+		t.bus = bus
+	}
+	return t.bus, nil
+}
+
+func (t *GnomeTracker) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.bus == nil {
+		return nil
+	}
+	err := t.bus.Close()
+	t.bus = nil
+	return err
+}
+
+func gnomeSnap(ctx context.Context, bus *dbusConn) (*Snapshot, error) {
+	reply, err := bus.CallContext(ctx, gnomeBusName, gnomePath, gnomeInterface, "Windows", "")
+	if err != nil {
+		if e, ok := err.(*dbusRemoteError); ok && e.Name == "org.freedesktop.DBus.Error.UnknownMethod" {
+			return nil, errors.New("gnome: the ultra-violet GNOME Shell extension is not enabled")
+		}
+		return nil, err
+	}
+	var doc string
+	if len(reply) == 1 {
+		doc, _ = reply[0].(string)
+	}
+	var windows gnomeWindows
+	if err := json.Unmarshal([]byte(doc), &windows); err != nil {
+		return nil, fmt.Errorf("gnome: invalid reply to Windows: %s", err)
+	}
+
+	snap := &Snapshot{Time: time.Now(), Windows: make([]*Window, 0, len(windows.Windows)), Visible: make([]int, 0, len(windows.Windows))}
+	for _, w := range windows.Windows {
+		snap.Windows = append(snap.Windows, &Window{ID: int(w.ID), Desktop: w.Workspace, Name: w.Title, PID: w.PID, Instance: w.WMInstance, Class: w.WMClass})
+		if w.Visible {
+			snap.Visible = append(snap.Visible, int(w.ID))
+		}
+	}
+	snap.Active = int(windows.Active)
+	return snap, nil
+}
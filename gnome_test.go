@@ -0,0 +1,135 @@
+package ultraViolet
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeGnomeShell starts a fake bus with a connection that owns
+// org.gnome.Shell. Unless windows is nil, it also exports the
+// extension's Windows method, which returns *windows (initially the
+// contents of testdata/gnome_windows.json).
+func newFakeGnomeShell(t *testing.T, windows *string) (*fakeBus, *dbusConn) {
+	if windows != nil {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", "gnome_windows.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		*windows = string(b)
+	}
+
+	bus := newFakeBus(t)
+	shell, err := dbusDial(bus.address())
+	if err != nil {
+		bus.Close()
+		t.Fatal(err)
+	}
+	if err := shell.RequestName(gnomeBusName); err != nil {
+		bus.Close()
+		t.Fatal(err)
+	}
+	if windows != nil {
+		shell.Export(gnomePath, gnomeInterface, "Windows", func(m *dbusMessage) (string, []interface{}, error) {
+			return "s", []interface{}{*windows}, nil
+		})
+	}
+	return bus, shell
+}
+
+func TestGnomeSnap(t *testing.T) {
+	var windows string
+	bus, shell := newFakeGnomeShell(t, &windows)
+	defer bus.Close()
+	defer shell.Close()
+	defer setenv("DBUS_SESSION_BUS_ADDRESS", bus.address())()
+
+	tracker := &GnomeTracker{}
+	defer tracker.Close()
+	snap, err := tracker.Snap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedWindows := []*Window{
+		&Window{ID: 2236, Desktop: 1, Name: "uv — Visual Studio Code", Instance: "code", Class: "Code", PID: 3301},
+		&Window{ID: 2240, Desktop: 1, Name: "GNOME Shell Extensions — Mozilla Firefox", Instance: "Navigator", Class: "firefox", PID: 3412},
+		&Window{ID: 2251, Desktop: 0, Name: "Inbox - Mozilla Thunderbird", Instance: "Mail", Class: "thunderbird", PID: 3520},
+		&Window{ID: 2262, Desktop: -1, Name: "Picture-in-Picture", Instance: "Navigator", Class: "firefox", PID: 3412},
+	}
+	if !reflect.DeepEqual(snap.Windows, expectedWindows) {
+		t.Errorf("windows: %v", snap.Windows)
+	}
+	if snap.Active != 2236 {
+		t.Errorf("active: %d", snap.Active)
+	}
+	if !reflect.DeepEqual(snap.Visible, []int{2236, 2240, 2262}) {
+		t.Errorf("visible: %v", snap.Visible)
+	}
+
+	windows = "not json"
+	if _, err := tracker.Snap(); err == nil || !strings.Contains(err.Error(), "invalid reply") {
+		t.Errorf("invalid reply: %v", err)
+	}
+}
+
+func TestGnomeSnapNoExtension(t *testing.T) {
+	bus, shell := newFakeGnomeShell(t, nil)
+	defer bus.Close()
+	defer shell.Close()
+	defer setenv("DBUS_SESSION_BUS_ADDRESS", bus.address())()
+
+	tracker := &GnomeTracker{}
+	defer tracker.Close()
+	if _, err := tracker.Snap(); err == nil || !strings.Contains(err.Error(), "extension is not enabled") {
+		t.Errorf("error: %v", err)
+	}
+	if err := tracker.Probe(); err == nil {
+		t.Error("probe: no error")
+	}
+}
+
+func TestGnomeWatch(t *testing.T) {
+	var windows string
+	bus, shell := newFakeGnomeShell(t, &windows)
+	defer bus.Close()
+	defer shell.Close()
+	defer setenv("DBUS_SESSION_BUS_ADDRESS", bus.address())()
+
+	tracker := &GnomeTracker{}
+	defer tracker.Close()
+	snaps := make(chan *Snapshot)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- tracker.Watch(snaps, time.Hour, stop) }()
+
+	next := func() *Snapshot {
+		select {
+		case snap := <-snaps:
+			return snap
+		case err := <-done:
+			t.Fatalf("Watch returned early: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no snapshot")
+		}
+		return nil
+	}
+
+	if snap := next(); snap.Active != 2236 {
+		t.Errorf("initial: %v", snap.Print())
+	}
+	// the signal is sent after the match rule is added, since the first
+	// snapshot is taken after AddMatch returns.
+	shell.Emit("/org/example/Other", gnomeInterface, "Changed", "")
+	shell.Emit(gnomePath, gnomeInterface, "Changed", "")
+	if snap := next(); snap.Active != 2236 {
+		t.Errorf("changed: %v", snap.Print())
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,305 @@
+package ultraViolet
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterTracker("hyprland", NewHyprlandTracker)
+}
+
+// HyprlandTracker tracks application usage on Hyprland through its IPC
+// sockets. Windows are Hyprland clients; their ID is the client address
+// and their Desktop is the ID of their workspace (-1 for pinned clients).
+type HyprlandTracker struct {
+	// Dir is the directory holding .socket.sock and .socket2.sock. If
+	// empty, it is derived from $HYPRLAND_INSTANCE_SIGNATURE.
+	Dir string
+}
+
+var _ Tracker = (*HyprlandTracker)(nil)
+var _ Watcher = (*HyprlandTracker)(nil)
+
+func NewHyprlandTracker() Tracker {
+	return &HyprlandTracker{}
+}
+
+func (t *HyprlandTracker) Deps() string {
+	return `
+No command-line utilities are needed, but the tracker needs a running Hyprland
+and $HYPRLAND_INSTANCE_SIGNATURE (set by Hyprland for the programs it starts).
+
+Note: this command prints out this message regardless of whether the dependencies are already installed.
+`
+}
+
+// hyprlandEvents are the socket2 events after which the windows are
+// sampled again.
+var hyprlandEvents = map[string]bool{
+	"activewindow":   true,
+	"activewindowv2": true,
+	"workspace":      true,
+	"workspacev2":    true,
+	"focusedmon":     true,
+	"openwindow":     true,
+	"closewindow":    true,
+	"movewindow":     true,
+	"movewindowv2":   true,
+	"windowtitle":    true,
+	"windowtitlev2":  true,
+}
+
+// hyprlandClient is an element of the j/clients reply.
+type hyprlandClient struct {
+	Address   string `json:"address"`
+	Mapped    bool   `json:"mapped"`
+	Hidden    bool   `json:"hidden"`
+	Pinned    bool   `json:"pinned"`
+	Class     string `json:"class"`
+	Title     string `json:"title"`
+	PID       int    `json:"pid"`
+	Workspace struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"workspace"`
+	Monitor  int    `json:"monitor"`
+	At       [2]int `json:"at"`
+	Size     [2]int `json:"size"`
+	Floating bool   `json:"floating"`
+	// Fullscreen is 0 for tiled and floating clients, 1 for maximized
+	// and 2 for fullscreen ones.
+	Fullscreen int `json:"fullscreen"`
+	// FocusHistoryID is 0 for the focused client and grows with the time
+	// since a client was focused.
+	FocusHistoryID int `json:"focusHistoryID"`
+}
+
+// hyprlandMonitor is an element of the j/monitors reply.
+type hyprlandMonitor struct {
+	ID              int     `json:"id"`
+	Name            string  `json:"name"`
+	X               int     `json:"x"`
+	Y               int     `json:"y"`
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+	Scale           float64 `json:"scale"`
+	Transform       int     `json:"transform"`
+	ActiveWorkspace struct {
+		ID int `json:"id"`
+	} `json:"activeWorkspace"`
+	SpecialWorkspace struct {
+		ID int `json:"id"`
+	} `json:"specialWorkspace"`
+}
+
+func (t *HyprlandTracker) Snap() (*Snapshot, error) {
+	dir, err := t.dir()
+	if err != nil {
+		return nil, err
+	}
+	var clients []hyprlandClient
+	if err := hyprlandQuery(dir, "j/clients", &clients); err != nil {
+		return nil, err
+	}
+	var active hyprlandClient
+	if err := hyprlandQuery(dir, "j/activewindow", &active); err != nil {
+		return nil, err
+	}
+	// the workspaces reply doesn't tell which workspaces are shown, the
+	// monitors reply does.
+	var monitors []hyprlandMonitor
+	if err := hyprlandQuery(dir, "j/monitors", &monitors); err != nil {
+		return nil, err
+	}
+	return hyprlandSnap(clients, &active, monitors)
+}
+
+func hyprlandSnap(clients []hyprlandClient, active *hyprlandClient, monitors []hyprlandMonitor) (*Snapshot, error) {
+	shown := make(map[int]bool)
+	for _, m := range monitors {
+		shown[m.ActiveWorkspace.ID] = true
+		if m.SpecialWorkspace.ID != 0 {
+			shown[m.SpecialWorkspace.ID] = true
+		}
+	}
+
+	snap := &Snapshot{Time: time.Now(), Windows: make([]*Window, 0, len(clients)), Visible: make([]int, 0, len(clients))}
+	for _, c := range clients {
+		id, err := hyprlandAddress(c.Address)
+		if err != nil {
+			return nil, err
+		}
+		w := &Window{ID: id, Desktop: c.Workspace.ID, Name: c.Title, PID: c.PID, Class: c.Class}
+		w.Geometry = &Rect{X: c.At[0], Y: c.At[1], Width: c.Size[0], Height: c.Size[1]}
+		if c.Pinned {
+			w.Desktop = -1
+		}
+		snap.Windows = append(snap.Windows, w)
+		if c.Mapped && !c.Hidden && (c.Pinned || shown[c.Workspace.ID]) {
+			snap.Visible = append(snap.Visible, id)
+		}
+	}
+	if active.Address != "" {
+		id, err := hyprlandAddress(active.Address)
+		if err != nil {
+			return nil, err
+		}
+		snap.Active = id
+	}
+
+	for _, m := range monitors {
+		snap.Monitors = append(snap.Monitors, &Monitor{Name: m.Name, Rect: m.layout()})
+	}
+	stacking, err := hyprlandStacking(clients)
+	if err != nil {
+		return nil, err
+	}
+	setGeometry(snap, stacking)
+	return snap, nil
+}
+
+// layout returns the rectangle m covers in the layout, which is in
+// logical pixels: its mode divided by its scale, rotated by its transform.
+func (m hyprlandMonitor) layout() Rect {
+	width, height := m.Width, m.Height
+	if m.Transform%2 == 1 {
+		// rotated by 90 or 270 degrees.
+		width, height = height, width
+	}
+	if m.Scale > 0 {
+		width, height = int(float64(width)/m.Scale+0.5), int(float64(height)/m.Scale+0.5)
+	}
+	return Rect{X: m.X, Y: m.Y, Width: width, Height: height}
+}
+
+// hyprlandStacking returns the IDs of clients from bottom to top.
+// Hyprland doesn't report the stacking order; it is approximated by
+// putting tiled clients below floating ones, below fullscreen ones, and
+// the most recently focused client of each layer on top.
+func hyprlandStacking(clients []hyprlandClient) ([]int, error) {
+	layer := func(c hyprlandClient) int {
+		switch {
+		case c.Fullscreen != 0:
+			return 2
+		case c.Floating:
+			return 1
+		}
+		return 0
+	}
+	sorted := append([]hyprlandClient(nil), clients...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if li, lj := layer(sorted[i]), layer(sorted[j]); li != lj {
+			return li < lj
+		}
+		return sorted[i].FocusHistoryID > sorted[j].FocusHistoryID
+	})
+	stacking := make([]int, 0, len(sorted))
+	for _, c := range sorted {
+		id, err := hyprlandAddress(c.Address)
+		if err != nil {
+			return nil, err
+		}
+		stacking = append(stacking, id)
+	}
+	return stacking, nil
+}
+
+// hyprlandAddress converts a client address such as "0x55d3c7a1b2c0" to
+// a window ID.
+func hyprlandAddress(address string) (int, error) {
+	id, err := strconv.ParseInt(address, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("hyprland: invalid client address %q", address)
+	}
+	return int(id), nil
+}
+
+// Watch listens to the events of .socket2.sock and sends a Snapshot
+// after the ones that change the focus, the workspaces or the titles.
+func (t *HyprlandTracker) Watch(snaps chan<- *Snapshot, heartbeat time.Duration, stop <-chan struct{}) error {
+	dir, err := t.dir()
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("unix", filepath.Join(dir, ".socket2.sock"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	changes := make(chan struct{}, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			event := scanner.Text()
+			if i := strings.Index(event, ">>"); i != -1 {
+				event = event[:i]
+			}
+			if !hyprlandEvents[event] {
+				continue
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+		err := scanner.Err()
+		if err == nil {
+			err = errors.New("hyprland: event socket closed")
+		}
+		readErr <- err
+	}()
+
+	return watchLoop(t, changes, readErr, snaps, heartbeat, stop)
+}
+
+func (t *HyprlandTracker) dir() (string, error) {
+	if t.Dir != "" {
+		return t.Dir, nil
+	}
+	sig := os.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
+	if sig == "" {
+		return "", errors.New("hyprland: $HYPRLAND_INSTANCE_SIGNATURE is not set")
+	}
+	// Hyprland moved its sockets from /tmp to $XDG_RUNTIME_DIR in v0.40.
+	if runDir := os.Getenv("XDG_RUNTIME_DIR"); runDir != "" {
+		dir := filepath.Join(runDir, "hypr", sig)
+		if _, err := os.Stat(dir); err == nil {
+			return dir, nil
+		}
+	}
+	return filepath.Join("/tmp/hypr", sig), nil
+}
+
+// hyprlandQuery sends a command to .socket.sock and decodes the JSON
+// reply into v. Hyprland answers one command per connection.
+func hyprlandQuery(dir, command string, v interface{}) error {
+	conn, err := net.Dial("unix", filepath.Join(dir, ".socket.sock"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(command)); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("hyprland: invalid reply to %s: %s", command, err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package ultraViolet
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHyprland serves recorded replies on .socket.sock and forwards
+// events to the clients of .socket2.sock.
+type fakeHyprland struct {
+	dir     string
+	replies map[string]string
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	events    []net.Conn
+}
+
+func newFakeHyprland(t *testing.T) *fakeHyprland {
+	dir, err := ioutil.TempDir("", "uv-hyprland")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &fakeHyprland{dir: dir, replies: make(map[string]string)}
+	for command, file := range map[string]string{
+		"j/clients":      "hyprland_clients.json",
+		"j/activewindow": "hyprland_activewindow.json",
+		"j/monitors":     "hyprland_monitors.json",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.replies[command] = string(b)
+	}
+
+	requests, err := net.Listen("unix", filepath.Join(dir, ".socket.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := net.Listen("unix", filepath.Join(dir, ".socket2.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.listeners = []net.Listener{requests, events}
+	go func() {
+		for {
+			conn, err := requests.Accept()
+			if err != nil {
+				return
+			}
+			go h.answer(conn)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := events.Accept()
+			if err != nil {
+				return
+			}
+			h.mu.Lock()
+			h.events = append(h.events, conn)
+			h.mu.Unlock()
+		}
+	}()
+	return h
+}
+
+func (h *fakeHyprland) answer(conn net.Conn) {
+	defer conn.Close()
+	b := make([]byte, 1024)
+	n, err := conn.Read(b)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	reply, ok := h.replies[string(b[:n])]
+	h.mu.Unlock()
+	if !ok {
+		reply = "unknown request"
+	}
+	conn.Write([]byte(reply))
+}
+
+// event sends a line to the event listeners.
+func (h *fakeHyprland) event(line string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, conn := range h.events {
+		conn.Write([]byte(line + "\n"))
+	}
+}
+
+// listening reports whether a client is listening to events.
+func (h *fakeHyprland) listening() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.events) > 0
+}
+
+func (h *fakeHyprland) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, l := range h.listeners {
+		l.Close()
+	}
+	for _, conn := range h.events {
+		conn.Close()
+	}
+	os.RemoveAll(h.dir)
+}
+
+func TestHyprlandSnap(t *testing.T) {
+	h := newFakeHyprland(t)
+	defer h.Close()
+
+	snap, err := (&HyprlandTracker{Dir: h.dir}).Snap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pinned mpv window floats over firefox.
+	firefoxArea := 1260*1385 - 300*200
+	expectedWindows := []*Window{
+		&Window{ID: 0x55d3c7a1b2c0, Desktop: 1, Name: "nvim hyprland.go", Geometry: &Rect{10, 45, 1260, 1385}, Monitor: "DP-1", VisibleArea: 1, Class: "kitty", PID: 2174},
+		&Window{ID: 0x55d3c7a4f7e0, Desktop: 1, Name: "Hyprland Wiki — Mozilla Firefox", Geometry: &Rect{1290, 45, 1260, 1385}, Monitor: "DP-1", VisibleArea: float64(firefoxArea) / (1260 * 1385), Class: "firefox", PID: 2301},
+		&Window{ID: 0x55d3c7b01a90, Desktop: 3, Name: "Slack | general | uv", Geometry: &Rect{10, 45, 2540, 1385}, Monitor: "DP-1", Class: "Slack", PID: 2405},
+		&Window{ID: 0x55d3c7b2c350, Desktop: -1, Name: "talk.webm - mpv", Geometry: &Rect{2200, 1200, 300, 200}, Monitor: "DP-1", VisibleArea: 1, Class: "mpv", PID: 2511},
+		&Window{ID: 0x55d3c7b3d120, Desktop: -98, Name: "Passwords.kdbx - KeePassXC", Geometry: &Rect{640, 360, 1280, 720}, Monitor: "DP-1", Class: "org.keepassxc.KeePassXC", PID: 2620},
+	}
+	if !reflect.DeepEqual(snap.Windows, expectedWindows) {
+		t.Errorf("windows: %v", snap.Windows)
+	}
+	if snap.Active != 0x55d3c7a1b2c0 {
+		t.Errorf("active: %x", snap.Active)
+	}
+	if !reflect.DeepEqual(snap.Visible, []int{0x55d3c7a1b2c0, 0x55d3c7a4f7e0, 0x55d3c7b2c350}) {
+		t.Errorf("visible: %v", snap.Visible)
+	}
+	// 3840x2160 at scale 1.5.
+	if !reflect.DeepEqual(snap.Monitors, []*Monitor{&Monitor{Name: "DP-1", Rect: Rect{0, 0, 2560, 1440}}}) {
+		t.Errorf("monitors: %v", snap.Monitors)
+	}
+}
+
+func TestHyprlandSnapNoActiveWindow(t *testing.T) {
+	h := newFakeHyprland(t)
+	defer h.Close()
+	h.replies["j/activewindow"] = "{}"
+
+	snap, err := (&HyprlandTracker{Dir: h.dir}).Snap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Active != 0 {
+		t.Errorf("active: %x", snap.Active)
+	}
+}
+
+func TestHyprlandSnapInvalidReply(t *testing.T) {
+	h := newFakeHyprland(t)
+	defer h.Close()
+	h.replies["j/clients"] = "Invalid command"
+
+	if _, err := (&HyprlandTracker{Dir: h.dir}).Snap(); err == nil || !strings.Contains(err.Error(), "j/clients") {
+		t.Errorf("error: %v", err)
+	}
+}
+
+func TestHyprlandWatch(t *testing.T) {
+	h := newFakeHyprland(t)
+	defer h.Close()
+
+	snaps := make(chan *Snapshot)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- (&HyprlandTracker{Dir: h.dir}).Watch(snaps, time.Hour, stop) }()
+
+	next := func() *Snapshot {
+		select {
+		case snap := <-snaps:
+			return snap
+		case err := <-done:
+			t.Fatalf("Watch returned early: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no snapshot")
+		}
+		return nil
+	}
+
+	next()
+	for !h.listening() {
+		time.Sleep(time.Millisecond)
+	}
+	h.event("urgent>>55d3c7b01a90")
+	h.mu.Lock()
+	h.replies["j/activewindow"] = `{"address":"0x55d3c7a4f7e0"}`
+	h.mu.Unlock()
+	h.event("activewindow>>firefox,Hyprland Wiki — Mozilla Firefox")
+	h.event("activewindowv2>>55d3c7a4f7e0")
+	if snap := next(); snap.Active != 0x55d3c7a4f7e0 {
+		t.Errorf("active: %x", snap.Active)
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,305 @@
+package ultraViolet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterTracker("i3", NewI3Tracker)
+}
+
+// I3Tracker tracks application usage on i3 and sway through their IPC
+// protocol. Windows are the containers of the layout tree that hold an
+// application; their ID is the container ID and their Desktop is the
+// number of their workspace (NoDesktop for named workspaces without a
+// number and for the scratchpad).
+type I3Tracker struct {
+	// Socket is the path of the IPC socket. If empty, $SWAYSOCK or
+	// $I3SOCK is used, falling back to asking i3 for its socket path.
+	Socket string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+var _ Tracker = (*I3Tracker)(nil)
+var _ Watcher = (*I3Tracker)(nil)
+
+func NewI3Tracker() Tracker {
+	return &I3Tracker{}
+}
+
+func (t *I3Tracker) Deps() string {
+	return `
+No command-line utilities are needed, but the tracker needs a running i3 or sway
+whose IPC socket is reachable through $SWAYSOCK or $I3SOCK (both are set by the
+window manager for the programs it starts).
+
+Note: this command prints out this message regardless of whether the dependencies are already installed.
+`
+}
+
+// i3 IPC message and event types.
+const (
+	i3GetWorkspaces = 1
+	i3Subscribe     = 2
+	i3GetTree       = 4
+
+	i3EventMask = 1 << 31
+)
+
+var i3Magic = []byte("i3-ipc")
+
+func (t *I3Tracker) Snap() (*Snapshot, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn == nil {
+		conn, err := t.dial()
+		if err != nil {
+			return nil, err
+		}
+		t.conn = conn
+	}
+	snap, err := i3Snap(t.conn)
+	if err != nil {
+		t.conn.Close()
+		t.conn = nil
+		return nil, err
+	}
+	return snap, nil
+}
+
+// Close closes the connection to the window manager, if any.
+func (t *I3Tracker) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+// Watch subscribes to window and workspace events on a second connection
+// and sends a Snapshot after each of them.
+func (t *I3Tracker) Watch(snaps chan<- *Snapshot, heartbeat time.Duration, stop <-chan struct{}) error {
+	events, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+	if err := i3Send(events, i3Subscribe, []byte(`["window","workspace"]`)); err != nil {
+		return err
+	}
+	typ, payload, err := i3Read(events)
+	if err != nil {
+		return err
+	}
+	var reply struct{ Success bool }
+	if err := json.Unmarshal(payload, &reply); err != nil || typ != i3Subscribe || !reply.Success {
+		return fmt.Errorf("i3: subscribing failed: %s", payload)
+	}
+
+	changes := make(chan struct{}, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := i3Read(events); err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return watchLoop(t, changes, readErr, snaps, heartbeat, stop)
+}
+
+func (t *I3Tracker) dial() (net.Conn, error) {
+	path := t.Socket
+	if path == "" {
+		path = i3SocketPath()
+	}
+	if path == "" {
+		return nil, errors.New("i3: cannot find the IPC socket; is $SWAYSOCK or $I3SOCK set?")
+	}
+	return net.Dial("unix", path)
+}
+
+// i3SocketPath returns the path of the IPC socket of the running i3 or
+// sway, or "" if it cannot be found.
+func i3SocketPath() string {
+	for _, env := range []string{"SWAYSOCK", "I3SOCK"} {
+		if path := os.Getenv(env); path != "" {
+			return path
+		}
+	}
+	out, err := exec.Command("i3", "--get-socketpath").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// i3Send writes an IPC message.
+func i3Send(w io.Writer, typ uint32, payload []byte) error {
+	msg := make([]byte, 0, len(i3Magic)+8+len(payload))
+	msg = append(msg, i3Magic...)
+	msg = append(msg, make([]byte, 8)...)
+	binary.LittleEndian.PutUint32(msg[len(i3Magic):], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(msg[len(i3Magic)+4:], typ)
+	msg = append(msg, payload...)
+	_, err := w.Write(msg)
+	return err
+}
+
+// i3Read reads an IPC reply or event.
+func i3Read(r io.Reader) (uint32, []byte, error) {
+	head := make([]byte, len(i3Magic)+8)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+	if !bytes.Equal(head[:len(i3Magic)], i3Magic) {
+		return 0, nil, errors.New("i3: invalid magic string")
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(head[len(i3Magic):]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return binary.LittleEndian.Uint32(head[len(i3Magic)+4:]), payload, nil
+}
+
+// i3Query sends a message and decodes its reply into v.
+func i3Query(rw io.ReadWriter, typ uint32, v interface{}) error {
+	if err := i3Send(rw, typ, nil); err != nil {
+		return err
+	}
+	for {
+		replyType, payload, err := i3Read(rw)
+		if err != nil {
+			return err
+		}
+		if replyType&i3EventMask != 0 {
+			continue
+		}
+		if replyType != typ {
+			return fmt.Errorf("i3: got reply %d to message %d", replyType, typ)
+		}
+		return json.Unmarshal(payload, v)
+	}
+}
+
+// i3Node is a container of the layout tree.
+type i3Node struct {
+	ID            int64     `json:"id"`
+	Name          string    `json:"name"`
+	Type          string    `json:"type"`
+	Num           int       `json:"num"`
+	Layout        string    `json:"layout"`
+	Focused       bool      `json:"focused"`
+	Focus         []int64   `json:"focus"`
+	Visible       *bool     `json:"visible"`
+	Window        *int64    `json:"window"`
+	AppID         *string   `json:"app_id"`
+	Props         i3Props   `json:"window_properties"`
+	PID           int       `json:"pid"`
+	Nodes         []*i3Node `json:"nodes"`
+	FloatingNodes []*i3Node `json:"floating_nodes"`
+}
+
+// i3Props holds the X11 properties of the window of a node.
+type i3Props struct {
+	Class    string `json:"class"`
+	Instance string `json:"instance"`
+}
+
+// isView reports whether n holds an application window.
+func (n *i3Node) isView() bool {
+	return n.Window != nil || n.AppID != nil
+}
+
+// i3Workspace is an element of the GET_WORKSPACES reply.
+type i3Workspace struct {
+	Name    string `json:"name"`
+	Visible bool   `json:"visible"`
+}
+
+func i3Snap(rw io.ReadWriter) (*Snapshot, error) {
+	var workspaces []i3Workspace
+	if err := i3Query(rw, i3GetWorkspaces, &workspaces); err != nil {
+		return nil, err
+	}
+	var tree i3Node
+	if err := i3Query(rw, i3GetTree, &tree); err != nil {
+		return nil, err
+	}
+
+	visibleWorkspaces := make(map[string]bool)
+	for _, ws := range workspaces {
+		visibleWorkspaces[ws.Name] = ws.Visible
+	}
+	snap := &Snapshot{Time: time.Now(), Windows: make([]*Window, 0, 32), Visible: make([]int, 0, 8)}
+	i3Walk(snap, &tree, nil, NoDesktop, false, visibleWorkspaces)
+	return snap, nil
+}
+
+// i3Walk adds the views below n to snap. desktop is the number of the
+// workspace n belongs to and visible whether n can be seen on screen.
+func i3Walk(snap *Snapshot, n, parent *i3Node, desktop int, visible bool, visibleWorkspaces map[string]bool) {
+	switch {
+	case n.Type == "workspace":
+		if n.Name == "__i3_scratch" {
+			desktop, visible = NoDesktop, false
+		} else {
+			desktop, visible = n.Num, visibleWorkspaces[n.Name]
+			if desktop < 0 {
+				// i3 numbers named workspaces -1, which is for
+				// sticky windows.
+				desktop = NoDesktop
+			}
+		}
+	case parent != nil && (parent.Layout == "tabbed" || parent.Layout == "stacked"):
+		// only the focused child of a tabbed or stacked container is shown.
+		visible = visible && len(parent.Focus) > 0 && parent.Focus[0] == n.ID
+	}
+
+	if n.isView() {
+		if n.Visible != nil {
+			visible = *n.Visible
+		}
+		w := &Window{ID: int(n.ID), Desktop: desktop, Name: n.Name, PID: n.PID, Instance: n.Props.Instance, Class: n.Props.Class}
+		if n.AppID != nil && *n.AppID != "" {
+			// a native Wayland window on sway.
+			w.Class = *n.AppID
+		}
+		snap.Windows = append(snap.Windows, w)
+		if visible {
+			snap.Visible = append(snap.Visible, int(n.ID))
+		}
+		if n.Focused {
+			snap.Active = int(n.ID)
+		}
+	}
+	for _, child := range n.Nodes {
+		i3Walk(snap, child, n, desktop, visible, visibleWorkspaces)
+	}
+	for _, child := range n.FloatingNodes {
+		i3Walk(snap, child, nil, desktop, visible, visibleWorkspaces)
+	}
+}
@@ -0,0 +1,206 @@
+package ultraViolet
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeI3Server serves recorded replies over an i3 IPC socket.
+type fakeI3Server struct {
+	l          net.Listener
+	tree       []byte
+	workspaces []byte
+
+	mu         sync.Mutex
+	subscribed []net.Conn
+}
+
+func newFakeI3Server(t *testing.T, treeFile, workspaces string) (*fakeI3Server, func()) {
+	tree, err := ioutil.ReadFile(filepath.Join("testdata", treeFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "uv-i3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", filepath.Join(dir, "ipc.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	s := &fakeI3Server{l: l, tree: tree, workspaces: []byte(workspaces)}
+	go s.serve()
+	return s, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func (s *fakeI3Server) path() string {
+	return s.l.Addr().String()
+}
+
+func (s *fakeI3Server) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeI3Server) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		typ, _, err := i3Read(conn)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		switch typ {
+		case i3GetWorkspaces:
+			err = i3Send(conn, typ, s.workspaces)
+		case i3GetTree:
+			err = i3Send(conn, typ, s.tree)
+		case i3Subscribe:
+			err = i3Send(conn, typ, []byte(`{"success":true}`))
+			s.subscribed = append(s.subscribed, conn)
+		default:
+			err = i3Send(conn, typ, []byte(`{"success":false}`))
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// event sends an event to the subscribed connections.
+func (s *fakeI3Server) event(typ uint32, payload string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.subscribed {
+		i3Send(conn, i3EventMask|typ, []byte(payload))
+	}
+}
+
+func TestI3Snap(t *testing.T) {
+	tests := []struct {
+		tree       string
+		workspaces string
+		windows    []*Window
+		active     int
+		visible    []int
+	}{
+		{
+			"i3_tree.json",
+			`[{"num":1,"name":"1","visible":true,"focused":true},{"num":2,"name":"2: mail","visible":false,"focused":false}]`,
+			[]*Window{
+				&Window{ID: 94011462364000, Desktop: NoDesktop, Name: "Passwords.kdbx - KeePassXC", Instance: "keepassxc", Class: "KeePassXC"},
+				&Window{ID: 94011462382000, Desktop: 1, Name: "i3: i3 User’s Guide - Mozilla Firefox", Instance: "Navigator", Class: "Firefox"},
+				&Window{ID: 94011462384000, Desktop: 1, Name: "~ - Terminal", Instance: "urxvt", Class: "URxvt"},
+				&Window{ID: 94011462385000, Desktop: 1, Name: "vim main.go - Terminal", Instance: "urxvt", Class: "URxvt"},
+				&Window{ID: 94011462387000, Desktop: 1, Name: "Volume Control", Instance: "pavucontrol", Class: "Pavucontrol"},
+				&Window{ID: 94011462391000, Desktop: 2, Name: "Inbox - Mozilla Thunderbird", Instance: "Mail", Class: "Thunderbird"},
+			},
+			94011462385000,
+			[]int{94011462382000, 94011462385000, 94011462387000},
+		},
+		{
+			"sway_tree.json",
+			`[{"num":1,"name":"1","visible":true,"focused":true},{"num":-1,"name":"web","visible":false,"focused":false}]`,
+			[]*Window{
+				&Window{ID: 5, Desktop: 1, Name: "README.md - uv - Visual Studio Code", Instance: "code", Class: "Code", PID: 4242},
+				&Window{ID: 6, Desktop: 1, Name: "foot", Class: "foot", PID: 4343},
+				&Window{ID: 10, Desktop: NoDesktop, Name: "GitHub - Mozilla Firefox", Class: "firefox", PID: 4444},
+			},
+			6,
+			[]int{5, 6},
+		},
+	}
+	for i, tt := range tests {
+		s, cleanup := newFakeI3Server(t, tt.tree, tt.workspaces)
+		tracker := &I3Tracker{Socket: s.path()}
+		snap, err := tracker.Snap()
+		tracker.Close()
+		cleanup()
+		if err != nil {
+			t.Errorf("case%d: %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(snap.Windows, tt.windows) {
+			t.Errorf("case%d: windows: %v", i, snap.Windows)
+		}
+		if snap.Active != tt.active {
+			t.Errorf("case%d: active: %d", i, snap.Active)
+		}
+		if !reflect.DeepEqual(snap.Visible, tt.visible) {
+			t.Errorf("case%d: visible: %v", i, snap.Visible)
+		}
+	}
+}
+
+func TestI3Watch(t *testing.T) {
+	s, cleanup := newFakeI3Server(t, "sway_tree.json", `[{"num":1,"name":"1","visible":true}]`)
+	defer cleanup()
+	tracker := &I3Tracker{Socket: s.path()}
+	defer tracker.Close()
+
+	snaps := make(chan *Snapshot)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- tracker.Watch(snaps, time.Hour, stop) }()
+
+	next := func() *Snapshot {
+		select {
+		case snap := <-snaps:
+			return snap
+		case err := <-done:
+			t.Fatalf("Watch returned early: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no snapshot")
+		}
+		return nil
+	}
+
+	if snap := next(); snap.Active != 6 {
+		t.Errorf("initial: %v", snap.Print())
+	}
+	before := time.Now()
+	s.event(3, `{"change":"focus","container":{"id":5}}`)
+	if snap := next(); snap.Time.Before(before) {
+		t.Errorf("time: %v", snap.Time)
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestI3Read(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		i3Read(server)
+		i3Send(server, i3EventMask|3, []byte(`{"change":"title"}`))
+		i3Send(server, i3GetTree, []byte(`{"id":1}`))
+		server.Write([]byte("i3-xxx\x00\x00\x00\x00\x00\x00\x00\x00"))
+		server.Close()
+	}()
+
+	var n i3Node
+	if err := i3Query(client, i3GetTree, &n); err != nil || n.ID != 1 {
+		t.Errorf("query: %v, %v", n, err)
+	}
+	if _, _, err := i3Read(client); err == nil {
+		t.Error("invalid magic")
+	}
+}
@@ -0,0 +1,181 @@
+package ultraViolet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultIdleThreshold is how long without keyboard or mouse input makes
+// the user idle, unless configured otherwise.
+const DefaultIdleThreshold = 5 * time.Minute
+
+// IdleCollector sets the idle state of Snapshots. In X11 sessions, the
+// time since the last input is read with the MIT-SCREEN-SAVER extension;
+// elsewhere (e.g. on Wayland, where the X server only sees XWayland
+// input), logind's IdleHint of the user's session is used, which desktop
+// environments set after their own idle delay.
+type IdleCollector struct {
+	// Threshold is how long without input makes the user idle. If zero,
+	// DefaultIdleThreshold is used.
+	Threshold time.Duration
+
+	// Display is the X display to query. If empty, $DISPLAY is used.
+	Display string
+
+	mu     sync.Mutex
+	x11    *x11Conn
+	saver  byte
+	logind logind
+}
+
+var (
+	_ Collector        = (*IdleCollector)(nil)
+	_ ContextCollector = (*IdleCollector)(nil)
+)
+
+// MIT-SCREEN-SAVER states.
+const (
+	x11ScreenSaverOff      = 0
+	x11ScreenSaverOn       = 1
+	x11ScreenSaverDisabled = 3
+)
+
+func (c *IdleCollector) Collect(snap *Snapshot) error {
+	return c.CollectContext(context.Background(), snap)
+}
+
+func (c *IdleCollector) CollectContext(ctx context.Context, snap *Snapshot) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	display := c.Display
+	if display == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		display = os.Getenv("DISPLAY")
+	}
+	if display != "" {
+		idle, saverOn, err := c.x11Idle(ctx, display)
+		if err == nil {
+			threshold := c.Threshold
+			if threshold == 0 {
+				threshold = DefaultIdleThreshold
+			}
+			snap.IdleTime = idle
+			snap.Idle = saverOn || idle >= threshold
+			return nil
+		}
+		if err != errNoScreenSaver {
+			return err
+		}
+	}
+
+	idle, since, err := c.logindIdle(ctx)
+	if err != nil {
+		return err
+	}
+	snap.Idle = idle
+	if idle && !since.IsZero() && snap.Time.After(since) {
+		snap.IdleTime = snap.Time.Sub(since)
+	}
+	return nil
+}
+
+// Close closes the connections to the X server and the system bus.
+func (c *IdleCollector) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.x11 != nil {
+		c.x11.Close()
+		c.x11 = nil
+	}
+	c.logind.close()
+	return nil
+}
+
+var errNoScreenSaver = errors.New("x11: the MIT-SCREEN-SAVER extension is not available")
+
+// x11Idle returns the time since the last input on display and whether
+// the screen saver is on. The connection is closed if ctx is done before
+// the X server replies.
+func (c *IdleCollector) x11Idle(ctx context.Context, display string) (time.Duration, bool, error) {
+	if c.x11 == nil {
+		conn, err := x11Dial(display)
+		if err != nil {
+			return 0, false, err
+		}
+		major, ok, err := conn.queryExtension("MIT-SCREEN-SAVER")
+		if err != nil || !ok {
+			conn.Close()
+			if err == nil {
+				err = errNoScreenSaver
+			}
+			return 0, false, err
+		}
+		c.x11, c.saver = conn, major
+	}
+	stop := make(chan struct{})
+	closed := make(chan bool, 1)
+	go func(conn *x11Conn) {
+		select {
+		case <-ctx.Done():
+			// fails the request waiting for its reply.
+			conn.Close()
+			closed <- true
+		case <-stop:
+			closed <- false
+		}
+	}(c.x11)
+	idle, state, err := x11ScreenSaverInfo(c.x11, c.saver)
+	close(stop)
+	if <-closed || err != nil {
+		c.x11.Close()
+		c.x11 = nil
+		if ctx.Err() != nil {
+			return 0, false, ctx.Err()
+		}
+		return 0, false, err
+	}
+	return idle, state == x11ScreenSaverOn, nil
+}
+
+// x11ScreenSaverInfo sends a ScreenSaverQueryInfo request for the root
+// window and returns the time since the last input and the screen saver
+// state.
+func x11ScreenSaverInfo(c *x11Conn, major byte) (time.Duration, byte, error) {
+	req := make([]byte, 8)
+	req[0] = major
+	req[1] = 1 // ScreenSaverQueryInfo
+	x11.PutUint32(req[4:], c.root)
+	seq, err := c.send(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	p, err := c.reply(seq)
+	if err != nil {
+		return 0, 0, err
+	}
+	return time.Duration(x11.Uint32(p[16:])) * time.Millisecond, p[1], nil
+}
+
+// logindIdle returns the IdleHint of the user's session and when it was
+// last set.
+func (c *IdleCollector) logindIdle(ctx context.Context) (bool, time.Time, error) {
+	hint, err := c.logind.property(ctx, "IdleHint")
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	idle, ok := hint.(bool)
+	if !ok {
+		return false, time.Time{}, fmt.Errorf("logind: IdleHint is a %T", hint)
+	}
+	var since time.Time
+	if v, err := c.logind.property(ctx, "IdleSinceHint"); err == nil {
+		if usec, ok := v.(uint64); ok && usec != 0 {
+			since = time.Unix(0, int64(usec)*int64(time.Microsecond))
+		}
+	}
+	return idle, since, nil
+}
@@ -0,0 +1,80 @@
+package ultraViolet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleCollectorX11(t *testing.T) {
+	s := newFakeX11Server()
+	s.screenSaver = true
+	c := dialFakeX11(t, s)
+	major, ok, err := c.queryExtension("MIT-SCREEN-SAVER")
+	if err != nil || !ok || major != fakeX11ScreenSaver {
+		t.Fatalf("queryExtension: %d %v %v", major, ok, err)
+	}
+	if _, ok, err := c.queryExtension("XINERAMA"); err != nil || ok {
+		t.Fatalf("queryExtension XINERAMA: %v %v", ok, err)
+	}
+
+	collector := &IdleCollector{Threshold: time.Minute, Display: "fake", x11: c, saver: major}
+	defer collector.Close()
+	cases := []struct {
+		idle     time.Duration
+		state    byte
+		expected bool
+	}{
+		{0, x11ScreenSaverOff, false},
+		{59 * time.Second, x11ScreenSaverOff, false},
+		{time.Minute, x11ScreenSaverOff, true},
+		{10 * time.Second, x11ScreenSaverOn, true},
+		{2 * time.Minute, x11ScreenSaverDisabled, true},
+	}
+	for i, c := range cases {
+		s.update(func() { s.idle, s.saverState = c.idle, c.state })
+		snap := &Snapshot{Time: time.Now()}
+		if err := collector.Collect(snap); err != nil {
+			t.Fatalf("case%d: %v", i, err)
+		}
+		if snap.Idle != c.expected || snap.IdleTime != c.idle {
+			t.Errorf("case%d: %v %s", i, snap.Idle, snap.IdleTime)
+		}
+	}
+}
+
+func TestIdleCollectorLogind(t *testing.T) {
+	props := make(map[string]dbusVariant)
+	bus, logind := newFakeLogind(t, true, props)
+	defer bus.Close()
+	defer logind.Close()
+	defer setenv("DBUS_SYSTEM_BUS_ADDRESS", bus.address())()
+	defer setenv("WAYLAND_DISPLAY", "wayland-0")()
+
+	collector := &IdleCollector{}
+	defer collector.Close()
+	now := time.Now().Truncate(time.Microsecond)
+	cases := []struct {
+		hint     bool
+		since    time.Time
+		idleTime time.Duration
+	}{
+		{false, time.Time{}, 0},
+		{true, now.Add(-3 * time.Minute), 3 * time.Minute},
+		{true, time.Time{}, 0},
+		{false, now.Add(-time.Minute), 0},
+	}
+	for i, c := range cases {
+		props["IdleHint"] = dbusVariant{Sig: "b", Value: c.hint}
+		props["IdleSinceHint"] = dbusVariant{Sig: "t", Value: uint64(0)}
+		if !c.since.IsZero() {
+			props["IdleSinceHint"] = dbusVariant{Sig: "t", Value: uint64(c.since.UnixNano() / int64(time.Microsecond))}
+		}
+		snap := &Snapshot{Time: now}
+		if err := collector.Collect(snap); err != nil {
+			t.Fatalf("case%d: %v", i, err)
+		}
+		if snap.Idle != c.hint || snap.IdleTime != c.idleTime {
+			t.Errorf("case%d: %v %s", i, snap.Idle, snap.IdleTime)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package ultraViolet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterTracker("kwin", NewKWinTracker)
+}
+
+// The D-Bus object the KWin script reports to. It is exported on the
+// tracker's own connection, so several trackers don't conflict.
+const (
+	kwinPath      = dbusObjectPath("/io/github/aimof/UltraViolet")
+	kwinInterface = "io.github.aimof.UltraViolet"
+)
+
+// kwinReportTimeout is how long Snap waits for the first report of the
+// script after loading it.
+var kwinReportTimeout = 5 * time.Second
+
+// kwinScript is loaded into KWin by the tracker. It reports the windows
+// whenever they change by calling Report on the tracker's connection,
+// whose unique name replaces %q. It works with the scripting API of
+// Plasma 5 (clients, numbered desktops) and Plasma 6 (windows,
+// VirtualDesktop objects).
+const kwinScript = `// Loaded into KWin by the uv "kwin" tracker.
+const service = %q;
+
+function windows() {
+    return workspace.windowList ? workspace.windowList() : workspace.clientList();
+}
+
+function activeWindow() {
+    return workspace.activeWindow !== undefined ? workspace.activeWindow : workspace.activeClient;
+}
+
+function desktopIndex(d) {
+    if (typeof d === "number")
+        return d > 0 ? d - 1 : -1;
+    for (let i = 0; i < workspace.desktops.length; i++)
+        if (workspace.desktops[i].id === d.id)
+            return i;
+    return -1;
+}
+
+function windowDesktop(w) {
+    if (w.onAllDesktops)
+        return -1;
+    if (typeof w.desktop === "number")
+        return desktopIndex(w.desktop);
+    return w.desktops.length > 0 ? desktopIndex(w.desktops[0]) : -1;
+}
+
+function report() {
+    const current = desktopIndex(workspace.currentDesktop);
+    const list = [];
+    for (const w of windows()) {
+        if (!w.normalWindow && !w.dialog)
+            continue;
+        const desktop = windowDesktop(w);
+        list.push({
+            id: String(w.internalId),
+            caption: w.caption,
+            resourceClass: String(w.resourceClass),
+            resourceName: String(w.resourceName),
+            pid: w.pid,
+            desktop: desktop,
+            visible: !w.minimized && (desktop === -1 || desktop === current),
+        });
+    }
+    const active = activeWindow();
+    callDBus(service, "` + string(kwinPath) + `", "` + kwinInterface + `", "Report", JSON.stringify({
+        active: active ? String(active.internalId) : "",
+        desktop: current,
+        windows: list,
+    }));
+}
+
+function watch(w) {
+    w.captionChanged.connect(report);
+    if (w.minimizedChanged)
+        w.minimizedChanged.connect(report);
+}
+
+for (const name of ["windowActivated", "clientActivated", "windowRemoved", "clientRemoved",
+                    "currentDesktopChanged", "clientMinimized", "clientUnminimized"])
+    if (workspace[name])
+        workspace[name].connect(report);
+for (const name of ["windowAdded", "clientAdded"])
+    if (workspace[name])
+        workspace[name].connect(function (w) { watch(w); report(); });
+windows().forEach(watch);
+report();
+`
+
+// KWinTracker tracks application usage on KDE Plasma (X11 and Wayland
+// sessions alike) by loading a KWin script that reports the windows back
+// to the tracker over the session bus. Windows are KWin windows; their
+// ID is derived from KWin's internal window UUID and their Desktop is
+// the 0-based index of their virtual desktop (-1 for windows on all
+// desktops).
+type KWinTracker struct {
+	mu       sync.Mutex
+	bus      *dbusConn
+	plugin   string
+	file     string
+	reported chan struct{}
+	changes  chan struct{}
+
+	// rmu guards report, which is set by the script while mu may be
+	// held waiting for KWin.
+	rmu    sync.Mutex
+	report *kwinReport
+}
+
+var _ Tracker = (*KWinTracker)(nil)
+var _ Watcher = (*KWinTracker)(nil)
+
+func NewKWinTracker() Tracker {
+	return &KWinTracker{}
+}
+
+func (t *KWinTracker) Deps() string {
+	return `
+No command-line utilities are needed, but the tracker needs KDE Plasma 5.23 or
+later and a session bus ($DBUS_SESSION_BUS_ADDRESS).
+
+Note: this command prints out this message regardless of whether the dependencies are already installed.
+`
+}
+
+// kwinReport is the JSON document sent by the script.
+type kwinReport struct {
+	Active  string       `json:"active"`
+	Desktop int          `json:"desktop"`
+	Windows []kwinWindow `json:"windows"`
+}
+
+type kwinWindow struct {
+	ID            string `json:"id"`
+	Caption       string `json:"caption"`
+	ResourceClass string `json:"resourceClass"`
+	ResourceName  string `json:"resourceName"`
+	PID           int    `json:"pid"`
+	Desktop       int    `json:"desktop"`
+	Visible       bool   `json:"visible"`
+}
+
+func (t *KWinTracker) Snap() (*Snapshot, error) {
+	reported, err := t.start()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-reported:
+	case <-time.After(kwinReportTimeout):
+		return nil, errors.New("kwin: the script didn't report the windows")
+	}
+	t.rmu.Lock()
+	defer t.rmu.Unlock()
+	return kwinSnap(t.report), nil
+}
+
+// Watch sends a Snapshot whenever the script reports a change.
+func (t *KWinTracker) Watch(snaps chan<- *Snapshot, heartbeat time.Duration, stop <-chan struct{}) error {
+	if _, err := t.start(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	bus, changes := t.bus, t.changes
+	t.mu.Unlock()
+
+	failed := make(chan error, 1)
+	go func() {
+		select {
+		case <-bus.Done():
+			err := bus.Err()
+			if err == nil {
+				err = errors.New("kwin: session bus connection closed")
+			}
+			failed <- err
+		case <-stop:
+		}
+	}()
+	return watchLoop(t, changes, failed, snaps, heartbeat, stop)
+}
+
+// Close unloads the script and closes the connection to the session bus.
+func (t *KWinTracker) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.bus == nil {
+		return nil
+	}
+	t.scripting("unloadScript", "s", t.plugin)
+	os.Remove(t.file)
+	err := t.bus.Close()
+	t.bus = nil
+	return err
+}
+
+// start connects to the session bus and loads the script, unless this
+// has already been done. It returns a channel that is closed once the
+// script reported the windows.
+func (t *KWinTracker) start() (<-chan struct{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.bus != nil {
+		select {
+		case <-t.bus.Done():
+			t.bus = nil
+			os.Remove(t.file)
+		default:
+			return t.reported, nil
+		}
+	}
+
+	bus, err := dbusSessionBus()
+	if err != nil {
+		return nil, err
+	}
+	t.bus = bus
+	t.rmu.Lock()
+	t.report = nil
+	t.rmu.Unlock()
+	t.reported = make(chan struct{})
+	t.changes = make(chan struct{}, 1)
+	t.plugin = "ultra-violet-" + strconv.Itoa(os.Getpid())
+	reported, changes := t.reported, t.changes
+	var first sync.Once
+	bus.Export(kwinPath, kwinInterface, "Report", func(m *dbusMessage) (string, []interface{}, error) {
+		var doc string
+		if len(m.Body) == 1 {
+			doc, _ = m.Body[0].(string)
+		}
+		report := &kwinReport{}
+		if err := json.Unmarshal([]byte(doc), report); err != nil {
+			return "", nil, err
+		}
+		t.rmu.Lock()
+		t.report = report
+		t.rmu.Unlock()
+		first.Do(func() { close(reported) })
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+		return "", nil, nil
+	})
+
+	if err := t.load(); err != nil {
+		bus.Close()
+		t.bus = nil
+		os.Remove(t.file)
+		return nil, err
+	}
+	return t.reported, nil
+}
+
+// load writes the script to a file and asks KWin to run it.
+func (t *KWinTracker) load() error {
+	f, err := ioutil.TempFile("", "ultra-violet-kwin")
+	if err != nil {
+		return err
+	}
+	t.file = f.Name()
+	_, err = fmt.Fprintf(f, kwinScript, t.bus.name)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// a script left over by a previous run with the same name would
+	// prevent loading this one.
+	if _, err := t.scripting("unloadScript", "s", t.plugin); err != nil {
+		return fmt.Errorf("kwin: %s", err)
+	}
+	reply, err := t.scripting("loadScript", "ss", t.file, t.plugin)
+	if err != nil {
+		return fmt.Errorf("kwin: %s", err)
+	}
+	if len(reply) != 1 || reply[0] == int32(-1) {
+		return errors.New("kwin: loading the script failed")
+	}
+	if _, err := t.scripting("start", ""); err != nil {
+		// don't leave the script loaded in KWin.
+		t.scripting("unloadScript", "s", t.plugin)
+		return fmt.Errorf("kwin: %s", err)
+	}
+	return nil
+}
+
+// scripting calls a method of KWin's scripting interface, giving KWin
+// DefaultCommandTimeout to reply.
+func (t *KWinTracker) scripting(member, sig string, args ...interface{}) ([]interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCommandTimeout)
+	defer cancel()
+	return t.bus.CallContext(ctx, "org.kde.KWin", "/Scripting", "org.kde.kwin.Scripting", member, sig, args...)
+}
+
+func kwinSnap(report *kwinReport) *Snapshot {
+	snap := &Snapshot{Time: time.Now(), Windows: make([]*Window, 0, len(report.Windows)), Visible: make([]int, 0, len(report.Windows))}
+	for _, w := range report.Windows {
+		id := kwinWindowID(w.ID)
+		snap.Windows = append(snap.Windows, &Window{ID: id, Desktop: w.Desktop, Name: w.Caption, PID: w.PID, Instance: w.ResourceName, Class: w.ResourceClass})
+		if w.Visible {
+			snap.Visible = append(snap.Visible, id)
+		}
+	}
+	if report.Active != "" {
+		snap.Active = kwinWindowID(report.Active)
+	}
+	return snap
+}
+
+// kwinWindowID converts KWin's internal window UUID, such as
+// "{6b4b7f1e-0c1e-4d3e-9a0e-2f9d5c7a1b2c}", to a window ID. Wayland
+// windows have no numeric ID, and the UUID is stable for the lifetime of
+// the window.
+func kwinWindowID(uuid string) int {
+	h := fnv.New64a()
+	h.Write([]byte(uuid))
+	return int(h.Sum64() >> 33)
+}
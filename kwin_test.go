@@ -0,0 +1,233 @@
+package ultraViolet
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKWin owns org.kde.KWin on a fake bus and implements the parts of
+// org.kde.kwin.Scripting the tracker uses. Instead of running a loaded
+// script, start calls Report on the connection named in the script with
+// the report returned by the report function.
+type fakeKWin struct {
+	bus  *fakeBus
+	conn *dbusConn
+
+	mu       sync.Mutex
+	report   string
+	scripts  map[string]string
+	unloaded []string
+	service  string
+	// startErr, if set, is returned by start.
+	startErr error
+}
+
+func newFakeKWin(t *testing.T) *fakeKWin {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "kwin_report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &fakeKWin{bus: newFakeBus(t), report: string(b), scripts: make(map[string]string)}
+	if k.conn, err = dbusDial(k.bus.address()); err != nil {
+		k.bus.Close()
+		t.Fatal(err)
+	}
+	if err := k.conn.RequestName("org.kde.KWin"); err != nil {
+		k.Close()
+		t.Fatal(err)
+	}
+
+	service := regexp.MustCompile(`const service = "(.*)";`)
+	k.conn.Export("/Scripting", "org.kde.kwin.Scripting", "loadScript", func(m *dbusMessage) (string, []interface{}, error) {
+		file, _ := m.Body[0].(string)
+		plugin, _ := m.Body[1].(string)
+		script, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "i", []interface{}{int32(-1)}, nil
+		}
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		k.scripts[plugin] = string(script)
+		if match := service.FindStringSubmatch(string(script)); match != nil {
+			k.service = match[1]
+		}
+		return "i", []interface{}{int32(len(k.scripts))}, nil
+	})
+	k.conn.Export("/Scripting", "org.kde.kwin.Scripting", "unloadScript", func(m *dbusMessage) (string, []interface{}, error) {
+		plugin, _ := m.Body[0].(string)
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		_, loaded := k.scripts[plugin]
+		delete(k.scripts, plugin)
+		k.unloaded = append(k.unloaded, plugin)
+		return "b", []interface{}{loaded}, nil
+	})
+	k.conn.Export("/Scripting", "org.kde.kwin.Scripting", "start", func(m *dbusMessage) (string, []interface{}, error) {
+		k.mu.Lock()
+		err := k.startErr
+		k.mu.Unlock()
+		if err != nil {
+			return "", nil, err
+		}
+		// KWin scripts call D-Bus asynchronously.
+		go k.send()
+		return "", nil, nil
+	})
+	return k
+}
+
+// send calls Report like the script does.
+func (k *fakeKWin) send() error {
+	k.mu.Lock()
+	service, report := k.service, k.report
+	k.mu.Unlock()
+	_, err := k.conn.Call(service, kwinPath, kwinInterface, "Report", "s", report)
+	return err
+}
+
+func (k *fakeKWin) Close() {
+	k.conn.Close()
+	k.bus.Close()
+}
+
+func TestKWinSnap(t *testing.T) {
+	k := newFakeKWin(t)
+	defer k.Close()
+	defer setenv("DBUS_SESSION_BUS_ADDRESS", k.bus.address())()
+
+	tracker := &KWinTracker{}
+	snap, err := tracker.Snap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kate := kwinWindowID("{3f0e6c2a-5b1d-4c8e-9a7f-1d2e3c4b5a60}")
+	firefox := kwinWindowID("{8a41d7b3-0f2e-4a6c-b1d9-7e5f3a2c1b04}")
+	konsole := kwinWindowID("{c5b2e9f1-7d3a-4e8b-a6c0-9f1e2d3c4b5a}")
+	elisa := kwinWindowID("{e7d1a3c5-2b4f-4d6e-8a9c-0b1c2d3e4f5a}")
+	expectedWindows := []*Window{
+		&Window{ID: kate, Desktop: 0, Name: "ultra-violet — Kate", Instance: "kate", Class: "org.kde.kate", PID: 2811},
+		&Window{ID: firefox, Desktop: 1, Name: "KDE Community — Mozilla Firefox", Instance: "Navigator", Class: "firefox", PID: 2934},
+		&Window{ID: konsole, Desktop: 0, Name: "Konsole", Instance: "konsole", Class: "org.kde.konsole", PID: 3012},
+		&Window{ID: elisa, Desktop: -1, Name: "Elisa", Instance: "elisa", Class: "org.kde.elisa", PID: 3120},
+	}
+	if !reflect.DeepEqual(snap.Windows, expectedWindows) {
+		t.Errorf("windows: %v", snap.Windows)
+	}
+	if snap.Active != kate {
+		t.Errorf("active: %d", snap.Active)
+	}
+	if !reflect.DeepEqual(snap.Visible, []int{kate, elisa}) {
+		t.Errorf("visible: %v", snap.Visible)
+	}
+
+	k.mu.Lock()
+	script, loaded := k.scripts[tracker.plugin]
+	k.mu.Unlock()
+	if !loaded || !strings.Contains(script, strconv.Quote(tracker.bus.name)) {
+		t.Errorf("script not loaded: %q", script)
+	}
+	file := tracker.file
+	if err := tracker.Close(); err != nil {
+		t.Error(err)
+	}
+	k.mu.Lock()
+	if _, loaded := k.scripts[tracker.plugin]; loaded {
+		t.Error("script not unloaded")
+	}
+	k.mu.Unlock()
+	if _, err := ioutil.ReadFile(file); err == nil {
+		t.Error("script file not removed")
+	}
+}
+
+func TestKWinSnapNoReport(t *testing.T) {
+	k := newFakeKWin(t)
+	defer k.Close()
+	defer setenv("DBUS_SESSION_BUS_ADDRESS", k.bus.address())()
+	k.report = "not json"
+
+	defer func(timeout time.Duration) { kwinReportTimeout = timeout }(kwinReportTimeout)
+	kwinReportTimeout = 100 * time.Millisecond
+	tracker := &KWinTracker{}
+	defer tracker.Close()
+	if _, err := tracker.Snap(); err == nil || !strings.Contains(err.Error(), "didn't report") {
+		t.Errorf("error: %v", err)
+	}
+}
+
+func TestKWinStartFails(t *testing.T) {
+	k := newFakeKWin(t)
+	defer k.Close()
+	defer setenv("DBUS_SESSION_BUS_ADDRESS", k.bus.address())()
+	k.startErr = errors.New("scripting disabled")
+
+	tracker := &KWinTracker{}
+	defer tracker.Close()
+	if _, err := tracker.Snap(); err == nil {
+		t.Fatal("no error")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.scripts) != 0 {
+		t.Errorf("scripts left loaded: %v", k.scripts)
+	}
+	if _, err := ioutil.ReadFile(tracker.file); err == nil {
+		t.Error("script file not removed")
+	}
+}
+
+func TestKWinWatch(t *testing.T) {
+	k := newFakeKWin(t)
+	defer k.Close()
+	defer setenv("DBUS_SESSION_BUS_ADDRESS", k.bus.address())()
+
+	tracker := &KWinTracker{}
+	defer tracker.Close()
+	snaps := make(chan *Snapshot)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- tracker.Watch(snaps, time.Hour, stop) }()
+
+	next := func() *Snapshot {
+		select {
+		case snap := <-snaps:
+			return snap
+		case err := <-done:
+			t.Fatalf("Watch returned early: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no snapshot")
+		}
+		return nil
+	}
+
+	next()
+	k.mu.Lock()
+	k.report = `{"active":"{8a41d7b3-0f2e-4a6c-b1d9-7e5f3a2c1b04}","desktop":1,"windows":[{"id":"{8a41d7b3-0f2e-4a6c-b1d9-7e5f3a2c1b04}","caption":"Plasma — Mozilla Firefox","desktop":1,"visible":true}]}`
+	k.mu.Unlock()
+	if err := k.send(); err != nil {
+		t.Fatal(err)
+	}
+	// the first report may still be pending when Watch starts.
+	for {
+		snap := next()
+		if len(snap.Windows) == 1 {
+			if snap.Active != kwinWindowID("{8a41d7b3-0f2e-4a6c-b1d9-7e5f3a2c1b04}") || snap.Windows[0].Name != "Plasma — Mozilla Firefox" {
+				t.Errorf("changed: %v", snap.Print())
+			}
+			break
+		}
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
@@ -1,10 +1,10 @@
 package ultraViolet
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
-	"os/exec"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -16,14 +16,43 @@ func init() {
 }
 
 // LinuxTracker tracks application usage on Linux via a few standard command-line utilities.
-type LinuxTracker struct{}
+type LinuxTracker struct {
+	// Displays are the X displays to track. If empty, $DISPLAY is
+	// tracked, or ":0" if it is not set. When several displays are
+	// tracked, the Snapshot holds the windows of all of them with their
+	// Display set, and its active window is the one of the first display.
+	// The IDs of the windows of the second and later displays are offset
+	// by linuxDisplayShift bits, so that they are unique in the Snapshot.
+	Displays []string
+
+	// Env is the environment the utilities run in. If nil, they inherit
+	// the environment of the process. DISPLAY is always set to the
+	// display being tracked.
+	Env []string
+
+	// Timeout is how long each utility may run. If zero,
+	// DefaultCommandTimeout is used.
+	Timeout time.Duration
+}
+
+// linuxDisplayShift is the number of bits of X resource IDs, whose top
+// three bits are always zero. The windows of the i-th display tracked
+// (from 0) get IDs offset by i<<linuxDisplayShift.
+const linuxDisplayShift = 29
 
 var _ Tracker = (*LinuxTracker)(nil)
+var _ DisplayTracker = (*LinuxTracker)(nil)
+var _ ContextTracker = (*LinuxTracker)(nil)
 
 func NewLinuxTracker() Tracker {
 	return &LinuxTracker{}
 }
 
+func (t *LinuxTracker) SetDisplays(displays []string) error {
+	t.Displays = displays
+	return nil
+}
+
 func (t *LinuxTracker) Deps() string {
 	return `
 Install the following command-line utilities via your package manager of choice:
@@ -40,64 +69,162 @@ Note: this command prints out this message regardless of whether the dependencie
 }
 
 func (t *LinuxTracker) Snap() (*Snapshot, error) {
+	return t.SnapContext(context.Background())
+}
+
+func (t *LinuxTracker) SnapContext(ctx context.Context) (*Snapshot, error) {
+	r := &linuxRunner{ctx: ctx, timeout: t.Timeout}
+	displays := t.Displays
+	if len(displays) == 0 {
+		display := os.Getenv("DISPLAY")
+		if display == "" {
+			display = ":0"
+		}
+		displays = []string{display}
+	}
+	if len(displays) == 1 {
+		r.env = linuxEnv(t.Env, displays[0])
+		return snapDisplay(r)
+	}
 
-	windows, err := collectWindows()
+	// the offsets of the displays must fit in an int.
+	if max := 1 << uint(strconv.IntSize-1-linuxDisplayShift); len(displays) > max {
+		return nil, fmt.Errorf("cannot track more than %d displays", max)
+	}
+	snap := &Snapshot{Windows: make([]*Window, 0, 128), Visible: make([]int, 0, 128), Time: time.Now()}
+	for i, display := range displays {
+		r.env = linuxEnv(t.Env, display)
+		s, err := snapDisplay(r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("display %s: %s", display, err)
+		}
+		offset := i << linuxDisplayShift
+		for _, w := range s.Windows {
+			w.ID += offset
+			w.Display = display
+		}
+		snap.Windows = append(snap.Windows, s.Windows...)
+		for _, id := range s.Visible {
+			snap.Visible = append(snap.Visible, id+offset)
+		}
+		for _, warning := range s.Warnings {
+			snap.Warnings = append(snap.Warnings, "display "+display+": "+warning)
+		}
+		if i == 0 {
+			snap.Active = s.Active
+		}
+	}
+	return snap, nil
+}
+
+// linuxEnv returns env, or the environment of the process if env is
+// nil, with DISPLAY set to display.
+func linuxEnv(env []string, display string) []string {
+	if env == nil {
+		env = os.Environ()
+	}
+	result := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, "DISPLAY=") {
+			result = append(result, kv)
+		}
+	}
+	return append(result, "DISPLAY="+display)
+}
+
+// linuxRunner runs the utilities for one display.
+type linuxRunner struct {
+	ctx     context.Context
+	timeout time.Duration
+	env     []string
+}
+
+func (r *linuxRunner) output(name string, args ...string) ([]byte, error) {
+	return runCommand(r.ctx, r.timeout, r.env, nil, name, args...)
+}
+
+// snapDisplay takes a Snapshot of the display set in r.env.
+func snapDisplay(r *linuxRunner) (*Snapshot, error) {
+	windows, err := collectWindows(r)
 	if err != nil {
 		return nil, err
 	}
-	currentDesktop, err := findCurrentDesktop()
+	currentDesktop, err := findCurrentDesktop(r)
 	if err != nil {
 		return nil, err
 	}
-	visible, err := getVisible(windows, currentDesktop)
+	visible, warnings, err := getVisible(r, windows, currentDesktop)
 	if err != nil {
 		return nil, err
 	}
 
-	active, err := getActiveWindow()
+	active, err := getActiveWindow(r)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Snapshot{Windows: windows, Active: active, Visible: visible, Time: time.Now()}, nil
+	return &Snapshot{Windows: windows, Active: active, Visible: visible, Time: time.Now(), Warnings: warnings}, nil
 }
 
-func getVisible(windows []*Window, currentDesktop int) ([]int, error) {
+// getVisible returns the windows that are mapped and on currentDesktop.
+// Windows whose state can't be read (e.g. because they were just closed)
+// are left out with a warning rather than failing the Snapshot; only the
+// end of r.ctx stops it.
+func getVisible(r *linuxRunner, windows []*Window, currentDesktop int) ([]int, []string, error) {
 	var visible = make([]int, 0, len(windows))
+	var warnings []string
 	for _, window := range windows {
-		cmd := exec.Command("xwininfo", "-id", fmt.Sprintf("%d", window.ID), "-stats")
-		cmd.Env = append(cmd.Env, "path=/usr/bin", "DISPLAY=:0")
-		out_, err := cmd.Output()
-		log.Println(string(out_))
+		out_, err := r.output("xwininfo", "-id", fmt.Sprintf("%d", window.ID), "-stats")
 		if err != nil {
-			return nil, fmt.Errorf("xwininfo failed with error: %s", err)
+			if r.ctx.Err() != nil {
+				return nil, nil, r.ctx.Err()
+			}
+			warnings = append(warnings, fmt.Sprintf("window %d: xwininfo failed with error: %s", window.ID, err))
+			continue
 		}
 		if window.IsOnDesktop(currentDesktop) && vis.Match(out_) {
 			visible = append(visible, window.ID)
 		}
 	}
-	return visible, nil
+	return visible, warnings, nil
 }
 
 var vis = regexp.MustCompile(`Map State:\s+IsViewable`)
 
-func collectWindows() ([]*Window, error) {
+func collectWindows(r *linuxRunner) ([]*Window, error) {
 	var windows = make([]*Window, 0, 128)
-	cmd := exec.Command("wmctrl", "-l")
-	cmd.Env = append(cmd.Env, "path=/usr/bin", "DISPLAY=:0")
-	out_, err := cmd.Output()
+	out_, err := r.output("wmctrl", "-lpx")
 	if err != nil {
 		return nil, err
 	}
-	windows, err = _collectWindows(string(out_))
+	windows, err = _collectWindows(string(out_), "-lpx")
 	return windows, err
 }
 
-func _collectWindows(out string) ([]*Window, error) {
+// _collectWindows parses the output of `wmctrl <flags>`, where flags is
+// "-l", optionally followed by p (PID column) and x (WM_CLASS column).
+func _collectWindows(out string, flags string) ([]*Window, error) {
+	withPID, withClass := strings.Contains(flags, "p"), strings.Contains(flags, "x")
 	windows := make([]*Window, 0, 128)
 	lines := strings.Split(out, "\n")
 	for _, line := range lines {
 		fields := strings.Fields(line)
+		var pid_, class_ string
+		if withPID {
+			if len(fields) < 5 {
+				continue
+			}
+			pid_, fields = fields[2], append(fields[:2:2], fields[3:]...)
+		}
+		if withClass {
+			if len(fields) < 5 {
+				continue
+			}
+			class_, fields = fields[2], append(fields[:2:2], fields[3:]...)
+		}
 		if len(fields) < 4 {
 			continue
 		}
@@ -111,6 +238,14 @@ func _collectWindows(out string) ([]*Window, error) {
 			return nil, err
 		}
 		w := Window{ID: int(id64), Desktop: desktop, Name: name}
+		if withClass {
+			w.Instance, w.Class = splitWMClass(class_)
+		}
+		if withPID && isLocalHost(fields[2]) {
+			if w.PID, err = strconv.Atoi(pid_); err != nil {
+				return nil, err
+			}
+		}
 		if w.ID > 33554432 {
 			windows = append(windows, &w)
 		}
@@ -118,10 +253,27 @@ func _collectWindows(out string) ([]*Window, error) {
 	return windows, nil
 }
 
-func findCurrentDesktop() (int, error) {
-	cmd := exec.Command("wmctrl", "-d")
-	cmd.Env = append(cmd.Env, "PATH=/usr/bin", "DISPLAY=:0")
-	out_, err := cmd.Output()
+// splitWMClass splits the WM_CLASS of a window as printed by wmctrl,
+// "instance.class", into its parts. Either may contain dots, as in
+// "org.gnome.Nautilus.Org.gnome.Nautilus": an instance and class that are
+// equal but for case are preferred, or else the class is taken to have
+// no dots.
+func splitWMClass(s string) (instance, class string) {
+	if n := len(s) / 2; len(s)%2 == 1 && s[n] == '.' && strings.EqualFold(s[:n], s[n+1:]) {
+		return s[:n], s[n+1:]
+	}
+	if dot := strings.LastIndex(s, "."); dot >= 0 {
+		return s[:dot], s[dot+1:]
+	}
+	// wmctrl prints "N/A" for windows without WM_CLASS.
+	if s == "N/A" {
+		return "", ""
+	}
+	return s, ""
+}
+
+func findCurrentDesktop(r *linuxRunner) (int, error) {
+	out_, err := r.output("wmctrl", "-d")
 	if err != nil {
 		return 0, err
 	}
@@ -149,10 +301,8 @@ func _findCurrentDesktop(out string) (int, error) {
 	return 0, errors.New("Cannot find current desktop")
 }
 
-func getActiveWindow() (int, error) {
-	cmd := exec.Command("xdotool", "getactivewindow")
-	cmd.Env = append(cmd.Env, "PATH=/usr/bin", "DISPLAY=:0")
-	out, err := cmd.Output()
+func getActiveWindow(r *linuxRunner) (int, error) {
+	out, err := r.output("xdotool", "getactivewindow")
 	if err != nil {
 		return 0, fmt.Errorf("xdotool failed with error: %s. Try running `xdotool getactivewindow` to diagnose.", err)
 	}
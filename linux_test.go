@@ -1,7 +1,15 @@
 package ultraViolet
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_collectWindows(t *testing.T) {
@@ -41,7 +49,7 @@ func Test_collectWindows(t *testing.T) {
 	}
 
 	for i, out := range outWmctrl {
-		windows, err := _collectWindows(out)
+		windows, err := _collectWindows(out, "-l")
 		for j, w := range windows {
 			if w == nil || expectedWindows[i][j] == nil {
 				if w == expectedWindows[i][j] {
@@ -58,6 +66,64 @@ func Test_collectWindows(t *testing.T) {
 	}
 }
 
+func Test_collectWindowsPID(t *testing.T) {
+	outWmctrl := []string{
+		`0x0340000a  0 4242   N/A Terminal - bash
+0x03400232 -1 0      localhost Desktop`,
+		`0x0340000a  0 x N/A Terminal`,
+		`0x0340000a  0 N/A Terminal`,
+	}
+	expectedWindows := [][]*Window{
+		[]*Window{
+			&Window{ID: 54525962, Desktop: 0, Name: "Terminal - bash", PID: 4242},
+			&Window{ID: 54526514, Desktop: -1, Name: "Desktop"},
+		},
+		nil,
+		[]*Window{},
+	}
+	for i, out := range outWmctrl {
+		windows, err := _collectWindows(out, "-lp")
+		if (err != nil) != (expectedWindows[i] == nil) {
+			t.Errorf("case%d: %v", i, err)
+		}
+		if err == nil && !reflect.DeepEqual(windows, expectedWindows[i]) {
+			t.Errorf("case%d: %v", i, windows)
+		}
+	}
+}
+
+func Test_collectWindowsClass(t *testing.T) {
+	out := `0x0340000a  0 4242   gnome-terminal-server.Gnome-terminal  N/A Terminal
+0x03400232  0 4243   org.gnome.Nautilus.Org.gnome.Nautilus  N/A Home
+0x03400234  0 4244   N/A  N/A untitled`
+	expected := []*Window{
+		&Window{ID: 54525962, Desktop: 0, Name: "Terminal", Instance: "gnome-terminal-server", Class: "Gnome-terminal", PID: 4242},
+		&Window{ID: 54526514, Desktop: 0, Name: "Home", Instance: "org.gnome.Nautilus", Class: "Org.gnome.Nautilus", PID: 4243},
+		&Window{ID: 54526516, Desktop: 0, Name: "untitled", PID: 4244},
+	}
+	windows, err := _collectWindows(out, "-lpx")
+	if err != nil || !reflect.DeepEqual(windows, expected) {
+		t.Errorf("windows: %v %v", windows, err)
+	}
+}
+
+func TestSplitWMClass(t *testing.T) {
+	cases := []struct {
+		s, instance, class string
+	}{
+		{"Navigator.Firefox", "Navigator", "Firefox"},
+		{"org.kde.dolphin.dolphin", "org.kde.dolphin", "dolphin"},
+		{"org.gnome.Nautilus.Org.gnome.Nautilus", "org.gnome.Nautilus", "Org.gnome.Nautilus"},
+		{"N/A", "", ""},
+		{"xclock", "xclock", ""},
+	}
+	for i, c := range cases {
+		if instance, class := splitWMClass(c.s); instance != c.instance || class != c.class {
+			t.Errorf("case%d: %q %q", i, instance, class)
+		}
+	}
+}
+
 func Test_findCurrentDesktop(t *testing.T) {
 
 	var outDesktop = []string{
@@ -92,3 +158,150 @@ func Test_findCurrentDesktop(t *testing.T) {
 		}
 	}
 }
+
+func TestLinuxEnv(t *testing.T) {
+	tests := []struct {
+		env      []string
+		display  string
+		expected []string
+	}{
+		{nil, ":1", nil},
+		{[]string{}, ":0", []string{"DISPLAY=:0"}},
+		{[]string{"PATH=/nix/store/bin", "DISPLAY=:0", "XAUTHORITY=/tmp/x"}, "localhost:10.0", []string{"PATH=/nix/store/bin", "XAUTHORITY=/tmp/x", "DISPLAY=localhost:10.0"}},
+	}
+	for i, tt := range tests {
+		if tt.env == nil {
+			// the environment of the process is used, which may hold
+			// DISPLAY already.
+			tt.expected = linuxEnv(os.Environ(), tt.display)
+		}
+		if actual := linuxEnv(tt.env, tt.display); !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("case%d: %v", i, actual)
+		}
+	}
+}
+
+// fakeLinuxUtilities puts scripts standing in for wmctrl, xdotool and
+// xwininfo at the front of $PATH. Their output depends on $DISPLAY.
+func fakeLinuxUtilities(t *testing.T) func() {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "uv-linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts := map[string]string{
+		"wmctrl": `case "$1$DISPLAY" in
+-lpx:0) echo "0x03400002  0 1234   xterm.XTerm           N/A Terminal" ;;
+-lpx:1) echo "0x03400002  0 2001   Navigator.Firefox     otherhost Mozilla Firefox"; echo "0x03400005  1 2002   N/A  N/A Inbox - Mail" ;;
+-d*) echo "0  * DG: 1920x1080  VP: 0,0  WA: 0,0 1920x1080  1"; echo "1  - DG: 1920x1080  VP: N/A  WA: 0,0 1920x1080  2" ;;
+*) exit 1 ;;
+esac`,
+		"xdotool": `case "$DISPLAY" in
+:0) echo 54525954 ;;
+:1) echo 54525957 ;;
+*) exit 1 ;;
+esac`,
+		"xwininfo": `echo "  Map State: IsViewable"`,
+	}
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLinuxTrackerDisplays(t *testing.T) {
+	defer fakeLinuxUtilities(t)()
+
+	snap, err := (&LinuxTracker{Displays: []string{":1"}}).Snap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Windows) != 2 || snap.Windows[0].Display != "" || snap.Active != 54525957 {
+		t.Errorf("one display: %v", snap.Print())
+	}
+
+	snap, err = (&LinuxTracker{Displays: []string{":0", ":1"}, Env: []string{"DISPLAY=:5"}}).Snap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedWindows := []*Window{
+		&Window{ID: 54525954, Desktop: 0, Name: "Terminal", Display: ":0", Instance: "xterm", Class: "XTerm", PID: 1234},
+		&Window{ID: 1<<29 + 54525954, Desktop: 0, Name: "Mozilla Firefox", Display: ":1", Instance: "Navigator", Class: "Firefox"},
+		&Window{ID: 1<<29 + 54525957, Desktop: 1, Name: "Inbox - Mail", Display: ":1", PID: 2002},
+	}
+	if !reflect.DeepEqual(snap.Windows, expectedWindows) {
+		t.Errorf("windows: %v", snap.Windows)
+	}
+	if snap.Active != 54525954 {
+		t.Errorf("active: %d", snap.Active)
+	}
+	// the windows of :1 are told apart from those of :0 with the same ID.
+	if !reflect.DeepEqual(snap.Visible, []int{54525954, 1<<29 + 54525954}) {
+		t.Errorf("visible: %v", snap.Visible)
+	}
+
+	if _, err := (&LinuxTracker{Displays: []string{":0", ":2"}}).Snap(); err == nil {
+		t.Error("unknown display")
+	}
+}
+
+func TestLinuxTrackerWarnings(t *testing.T) {
+	defer fakeLinuxUtilities(t)()
+	defer fakeCommands(t, map[string]string{
+		"xwininfo": `case "$2" in
+54525957) echo "X Error of failed request: BadWindow" >&2; exit 1 ;;
+*) echo "  Map State: IsViewable" ;;
+esac`,
+	})()
+
+	snap, err := (&LinuxTracker{Displays: []string{":1"}}).Snap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Windows) != 2 || !reflect.DeepEqual(snap.Visible, []int{54525954}) {
+		t.Errorf("snapshot: %v", snap.Print())
+	}
+	if len(snap.Warnings) != 1 || !strings.HasPrefix(snap.Warnings[0], "window 54525957: ") {
+		t.Errorf("warnings: %q", snap.Warnings)
+	}
+
+	snap, err = (&LinuxTracker{Displays: []string{":0", ":1"}}).Snap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Warnings) != 1 || !strings.HasPrefix(snap.Warnings[0], "display :1: window 54525957: ") {
+		t.Errorf("warnings: %q", snap.Warnings)
+	}
+}
+
+func TestLinuxTrackerTimeout(t *testing.T) {
+	defer fakeLinuxUtilities(t)()
+	defer fakeCommands(t, map[string]string{"xwininfo": "exec sleep 10"})()
+
+	start := time.Now()
+	snap, err := (&LinuxTracker{Displays: []string{":1"}, Timeout: 100 * time.Millisecond}).Snap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Warnings) != 2 || !strings.Contains(snap.Warnings[0], "xwininfo timed out after 100ms") {
+		t.Errorf("warnings: %q", snap.Warnings)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := SnapContext(ctx, &LinuxTracker{Displays: []string{":1"}}); err != context.DeadlineExceeded {
+		t.Errorf("context: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("took %s", d)
+	}
+}
@@ -0,0 +1,189 @@
+package ultraViolet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LockCollector sets the Locked state of Snapshots and records markers
+// when the screen is locked or unlocked and when the system suspends or
+// resumes. The lock state comes from logind's LockedHint of the user's
+// session, which most lock screens set, and from the screen saver
+// service of the desktop (org.freedesktop.ScreenSaver or
+// org.gnome.ScreenSaver) on the session bus.
+type LockCollector struct {
+	mu     sync.Mutex
+	logind logind
+	bus    *dbusConn
+}
+
+var _ Collector = (*LockCollector)(nil)
+var _ ContextCollector = (*LockCollector)(nil)
+var _ EventWatcher = (*LockCollector)(nil)
+
+// lockScreenSavers are the D-Bus names, object paths and interfaces of
+// the screen saver services.
+var lockScreenSavers = []struct {
+	name string
+	path dbusObjectPath
+}{
+	{"org.freedesktop.ScreenSaver", "/org/freedesktop/ScreenSaver"},
+	{"org.gnome.ScreenSaver", "/org/gnome/ScreenSaver"},
+}
+
+func (c *LockCollector) Collect(snap *Snapshot) error {
+	return c.CollectContext(context.Background(), snap)
+}
+
+func (c *LockCollector) CollectContext(ctx context.Context, snap *Snapshot) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hint, errLogind := c.logind.property(ctx, "LockedHint")
+	if locked, ok := hint.(bool); ok && locked {
+		snap.Locked = true
+		return nil
+	}
+	active, errSaver := c.screenSaverActive(ctx)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if errLogind != nil && errSaver != nil {
+		return fmt.Errorf("cannot tell whether the screen is locked: %s; %s", errLogind, errSaver)
+	}
+	snap.Locked = active
+	return nil
+}
+
+// screenSaverActive asks the screen saver services whether the screen
+// saver (which locks the screen on most desktops) is active.
+func (c *LockCollector) screenSaverActive(ctx context.Context) (bool, error) {
+	if c.bus == nil {
+		bus, err := dbusSessionBus()
+		if err != nil {
+			return false, err
+		}
+		c.bus = bus
+	}
+	var err error
+	for _, s := range lockScreenSavers {
+		var reply []interface{}
+		reply, err = c.bus.CallContext(ctx, s.name, s.path, s.name, "GetActive", "")
+		if err == nil {
+			active := len(reply) == 1 && reply[0] == true
+			return active, nil
+		}
+	}
+	select {
+	case <-c.bus.Done():
+		c.bus.Close()
+		c.bus = nil
+	default:
+	}
+	return false, err
+}
+
+// Close closes the connections to the system and session buses.
+func (c *LockCollector) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logind.close()
+	if c.bus != nil {
+		c.bus.Close()
+		c.bus = nil
+	}
+	return nil
+}
+
+// WatchEvents sends EventSleep and EventResume markers when logind
+// announces a suspend (PrepareForSleep), and EventLock and EventUnlock
+// markers when the LockedHint of the session or the screen saver state
+// changes. The screen saver is only watched if there is a session bus.
+func (c *LockCollector) WatchEvents(events chan<- *Snapshot, stop <-chan struct{}) error {
+	system, err := dbusSystemBus()
+	if err != nil {
+		return err
+	}
+	defer system.Close()
+	session, err := logindSession(system)
+	if err != nil {
+		return err
+	}
+	for _, rule := range []string{
+		"type='signal',sender='org.freedesktop.login1',path='/org/freedesktop/login1',interface='org.freedesktop.login1.Manager',member='PrepareForSleep'",
+		fmt.Sprintf("type='signal',sender='org.freedesktop.login1',path='%s',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged'", session),
+	} {
+		if err := system.AddMatch(rule); err != nil {
+			return err
+		}
+	}
+	var locked, saverActive bool
+	if v, err := system.GetProperty("org.freedesktop.login1", session, "org.freedesktop.login1.Session", "LockedHint"); err == nil {
+		locked, _ = v.(bool)
+	}
+
+	var saverSignals <-chan *dbusMessage
+	if bus, err := dbusSessionBus(); err == nil {
+		defer bus.Close()
+		for _, s := range lockScreenSavers {
+			if err := bus.AddMatch(fmt.Sprintf("type='signal',interface='%s',member='ActiveChanged'", s.name)); err != nil {
+				return err
+			}
+		}
+		saverSignals = bus.Signals()
+	}
+
+	emit := func(event string) bool {
+		select {
+		case events <- &Snapshot{Time: time.Now(), Event: event}:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+	for {
+		wasLocked := locked || saverActive
+		select {
+		case <-stop:
+			return nil
+		case <-system.Done():
+			if err := system.Err(); err != nil {
+				return err
+			}
+			return errors.New("logind: system bus connection closed")
+		case m := <-system.Signals():
+			switch {
+			case m.Member == "PrepareForSleep" && len(m.Body) == 1:
+				event := EventResume
+				if m.Body[0] == true {
+					event = EventSleep
+				}
+				if !emit(event) {
+					return nil
+				}
+			case m.Member == "PropertiesChanged" && m.Path == session && len(m.Body) == 3:
+				changed, _ := m.Body[1].(map[interface{}]interface{})
+				if v, ok := changed["LockedHint"].(dbusVariant); ok {
+					locked, _ = v.Value.(bool)
+				}
+			}
+		case m := <-saverSignals:
+			if m.Member == "ActiveChanged" && len(m.Body) == 1 {
+				saverActive, _ = m.Body[0].(bool)
+			}
+		}
+
+		if isLocked := locked || saverActive; isLocked != wasLocked {
+			event := EventUnlock
+			if isLocked {
+				event = EventLock
+			}
+			if !emit(event) {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package ultraViolet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newFakeScreenSaver connects to bus as the screen saver service name at
+// path, whose GetActive method returns *active.
+func newFakeScreenSaver(t *testing.T, bus *fakeBus, name string, path dbusObjectPath, active *bool) *dbusConn {
+	saver, err := dbusDial(bus.address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saver.RequestName(name); err != nil {
+		t.Fatal(err)
+	}
+	saver.Export(path, name, "GetActive", func(m *dbusMessage) (string, []interface{}, error) {
+		return "b", []interface{}{*active}, nil
+	})
+	return saver
+}
+
+func TestLockCollector(t *testing.T) {
+	props := map[string]dbusVariant{"LockedHint": {Sig: "b", Value: false}}
+	bus, logind := newFakeLogind(t, true, props)
+	defer bus.Close()
+	defer logind.Close()
+	var active bool
+	saver := newFakeScreenSaver(t, bus, "org.gnome.ScreenSaver", "/org/gnome/ScreenSaver", &active)
+	defer saver.Close()
+	defer setenv("DBUS_SYSTEM_BUS_ADDRESS", bus.address())()
+	defer setenv("DBUS_SESSION_BUS_ADDRESS", bus.address())()
+
+	collector := &LockCollector{}
+	defer collector.Close()
+	cases := []struct {
+		hint, active bool
+		expected     bool
+	}{
+		{false, false, false},
+		{true, false, true},
+		{false, true, true},
+		{true, true, true},
+	}
+	for i, c := range cases {
+		props["LockedHint"] = dbusVariant{Sig: "b", Value: c.hint}
+		active = c.active
+		snap := &Snapshot{}
+		if err := collector.Collect(snap); err != nil {
+			t.Fatalf("case%d: %v", i, err)
+		}
+		if snap.Locked != c.expected {
+			t.Errorf("case%d: %v", i, snap.Locked)
+		}
+	}
+}
+
+func TestLockCollectorNoBus(t *testing.T) {
+	defer setenv("DBUS_SYSTEM_BUS_ADDRESS", "unix:path=/nonexistent")()
+	defer setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path=/nonexistent")()
+	collector := &LockCollector{}
+	defer collector.Close()
+	if err := collector.Collect(&Snapshot{}); err == nil {
+		t.Errorf("no error")
+	}
+}
+
+func TestLockCollectorWatchEvents(t *testing.T) {
+	props := map[string]dbusVariant{"LockedHint": {Sig: "b", Value: false}}
+	bus, logind := newFakeLogind(t, true, props)
+	defer bus.Close()
+	defer logind.Close()
+	var active bool
+	saver := newFakeScreenSaver(t, bus, "org.freedesktop.ScreenSaver", "/org/freedesktop/ScreenSaver", &active)
+	defer saver.Close()
+	defer setenv("DBUS_SYSTEM_BUS_ADDRESS", bus.address())()
+	defer setenv("DBUS_SESSION_BUS_ADDRESS", bus.address())()
+
+	collector := &LockCollector{}
+	events := make(chan *Snapshot)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- collector.WatchEvents(events, stop) }()
+
+	next := func() string {
+		select {
+		case marker := <-events:
+			return marker.Event
+		case err := <-done:
+			t.Fatalf("WatchEvents returned: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no marker")
+		}
+		return ""
+	}
+	screenSaver := func(active bool) {
+		if err := saver.Emit("/org/freedesktop/ScreenSaver", "org.freedesktop.ScreenSaver", "ActiveChanged", "b", active); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lockedHint := func(locked bool) {
+		changed := map[string]dbusVariant{"LockedHint": {Sig: "b", Value: locked}}
+		if err := logind.Emit(fakeLogindSession, "org.freedesktop.DBus.Properties", "PropertiesChanged", "sa{sv}as", "org.freedesktop.login1.Session", changed, []string{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prepareForSleep := func(start bool) {
+		if err := logind.Emit("/org/freedesktop/login1", "org.freedesktop.login1.Manager", "PrepareForSleep", "b", start); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the screen saver is signalled until WatchEvents listens, which
+	// makes a single lock marker since the state doesn't change.
+	var got []string
+	for got == nil {
+		screenSaver(true)
+		select {
+		case marker := <-events:
+			got = append(got, marker.Event)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	screenSaver(false)
+	got = append(got, next())
+	prepareForSleep(true)
+	got = append(got, next())
+	prepareForSleep(false)
+	got = append(got, next())
+	lockedHint(true)
+	got = append(got, next())
+	lockedHint(false)
+	got = append(got, next())
+
+	expected := []string{EventLock, EventUnlock, EventSleep, EventResume, EventLock, EventUnlock}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("events: %v", got)
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
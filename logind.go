@@ -0,0 +1,72 @@
+package ultraViolet
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// logind reads the properties of the user's session from systemd-logind
+// over the system bus. It connects on first use and again after the
+// connection fails. The zero value is ready to use.
+type logind struct {
+	bus     *dbusConn
+	session dbusObjectPath
+}
+
+// property returns the value of the property name of the session, or
+// ctx.Err() if ctx is done before logind replies.
+func (l *logind) property(ctx context.Context, name string) (interface{}, error) {
+	if l.bus == nil {
+		bus, err := dbusSystemBus()
+		if err != nil {
+			return nil, err
+		}
+		session, err := logindSession(bus)
+		if err != nil {
+			bus.Close()
+			return nil, err
+		}
+		l.bus, l.session = bus, session
+	}
+
+	v, err := l.bus.GetPropertyContext(ctx, "org.freedesktop.login1", l.session, "org.freedesktop.login1.Session", name)
+	if err != nil {
+		select {
+		case <-l.bus.Done():
+			l.close()
+		default:
+		}
+	}
+	return v, err
+}
+
+func (l *logind) close() {
+	if l.bus != nil {
+		l.bus.Close()
+		l.bus = nil
+	}
+}
+
+// logindSession returns the object path of the session uv runs in, or
+// else of the user's graphical session (e.g. when uv runs as a systemd
+// user service outside any session).
+func logindSession(bus *dbusConn) (dbusObjectPath, error) {
+	reply, err := bus.Call("org.freedesktop.login1", "/org/freedesktop/login1", "org.freedesktop.login1.Manager", "GetSessionByPID", "u", uint32(os.Getpid()))
+	if err == nil && len(reply) == 1 {
+		if path, ok := reply[0].(dbusObjectPath); ok {
+			return path, nil
+		}
+	}
+	display, err := bus.GetProperty("org.freedesktop.login1", "/org/freedesktop/login1/user/self", "org.freedesktop.login1.User", "Display")
+	if err != nil {
+		return "", err
+	}
+	// Display is a (so) struct of the session ID and object path.
+	if fields, ok := display.([]interface{}); ok && len(fields) == 2 {
+		if path, ok := fields[1].(dbusObjectPath); ok && path != "/" {
+			return path, nil
+		}
+	}
+	return "", errors.New("logind: cannot find the user's session")
+}
@@ -0,0 +1,63 @@
+package ultraViolet
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeLogindSession is the object path of the session of the fake logind.
+const fakeLogindSession = dbusObjectPath("/org/freedesktop/login1/session/_32")
+
+// newFakeLogind starts a fake system bus with a connection that owns
+// org.freedesktop.login1 and reports props as the properties of a
+// session. If bypid is false, GetSessionByPID fails as it does outside a
+// session, and the session is found through the user.
+func newFakeLogind(t *testing.T, bypid bool, props map[string]dbusVariant) (*fakeBus, *dbusConn) {
+	bus := newFakeBus(t)
+	logind, err := dbusDial(bus.address())
+	if err != nil {
+		bus.Close()
+		t.Fatal(err)
+	}
+	if err := logind.RequestName("org.freedesktop.login1"); err != nil {
+		bus.Close()
+		t.Fatal(err)
+	}
+	logind.Export("/org/freedesktop/login1", "org.freedesktop.login1.Manager", "GetSessionByPID", func(m *dbusMessage) (string, []interface{}, error) {
+		if !bypid {
+			return "", nil, errors.New("PID does not belong to any known session")
+		}
+		return "o", []interface{}{fakeLogindSession}, nil
+	})
+	logind.Export("/org/freedesktop/login1/user/self", "org.freedesktop.DBus.Properties", "Get", func(m *dbusMessage) (string, []interface{}, error) {
+		return "v", []interface{}{dbusVariant{Sig: "(so)", Value: []interface{}{"2", fakeLogindSession}}}, nil
+	})
+	logind.Export(fakeLogindSession, "org.freedesktop.DBus.Properties", "Get", func(m *dbusMessage) (string, []interface{}, error) {
+		if len(m.Body) != 2 {
+			return "", nil, errors.New("invalid arguments")
+		}
+		name, _ := m.Body[1].(string)
+		v, ok := props[name]
+		if !ok {
+			return "", nil, errors.New("unknown property " + name)
+		}
+		return "v", []interface{}{v}, nil
+	})
+	return bus, logind
+}
+
+func TestLogindSession(t *testing.T) {
+	for i, bypid := range []bool{true, false} {
+		bus, logind := newFakeLogind(t, bypid, map[string]dbusVariant{"Active": {Sig: "b", Value: true}})
+		conn, err := dbusDial(bus.address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session, err := logindSession(conn); err != nil || session != fakeLogindSession {
+			t.Errorf("case%d: %s %v", i, session, err)
+		}
+		conn.Close()
+		logind.Close()
+		bus.Close()
+	}
+}
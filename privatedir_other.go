@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package ultraViolet
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkPrivateDir returns an error unless fi, the Lstat of path, is a
+// directory of the user's that only they can access.
+func checkPrivateDir(path string, fi os.FileInfo) error {
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is not owned by the user", path)
+	}
+	if fi.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s has mode %s rather than drwx------", path, fi.Mode())
+	}
+	return nil
+}
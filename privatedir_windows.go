@@ -0,0 +1,15 @@
+package ultraViolet
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkPrivateDir returns an error unless fi, the Lstat of path, is a
+// directory. The temporary directory is the user's own on Windows.
+func checkPrivateDir(path string, fi os.FileInfo) error {
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	return nil
+}
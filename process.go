@@ -0,0 +1,66 @@
+package ultraViolet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procDir is where the proc filesystem is mounted.
+var procDir = "/proc"
+
+// ProcessCollector fills in the Exe, Args and Cwd of the windows whose
+// PID is known, from /proc. What can't be read (e.g. the executable of
+// another user's process, or anything at all on systems without /proc)
+// is left empty.
+type ProcessCollector struct{}
+
+var _ Collector = (*ProcessCollector)(nil)
+
+func (c *ProcessCollector) Collect(snap *Snapshot) error {
+	procs := make(map[int]*Window)
+	for _, w := range snap.Windows {
+		if w.PID <= 0 {
+			continue
+		}
+		p, ok := procs[w.PID]
+		if !ok {
+			p = readProcess(w.PID)
+			procs[w.PID] = p
+		}
+		w.Exe, w.Args, w.Cwd = p.Exe, p.Args, p.Cwd
+	}
+	return nil
+}
+
+// readProcess returns a Window holding only the process details of pid.
+func readProcess(pid int) *Window {
+	dir := filepath.Join(procDir, strconv.Itoa(pid))
+	p := &Window{PID: pid}
+	p.Exe, _ = os.Readlink(filepath.Join(dir, "exe"))
+	// the link of a replaced executable ends with " (deleted)".
+	p.Exe = strings.TrimSuffix(p.Exe, " (deleted)")
+	p.Cwd, _ = os.Readlink(filepath.Join(dir, "cwd"))
+	if cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline")); err == nil && len(cmdline) > 0 {
+		p.Args = strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	}
+	return p
+}
+
+// isLocalHost reports whether machine, the host a window's client runs on
+// according to the window system, is this host. The PIDs of windows of
+// remote clients are meaningless here. Domain names are ignored, and an
+// unknown machine is assumed to be local.
+func isLocalHost(machine string) bool {
+	if machine == "" || machine == "N/A" {
+		return true
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return true
+	}
+	short := func(host string) string { return strings.SplitN(host, ".", 2)[0] }
+	return strings.EqualFold(short(machine), short(host)) || strings.EqualFold(machine, "localhost")
+}
@@ -0,0 +1,88 @@
+package ultraViolet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProcessCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uv-proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { procDir = d }(procDir)
+	procDir = dir
+
+	for _, p := range []struct {
+		pid, exe, cwd, cmdline string
+	}{
+		{"100", "/usr/bin/foot", "/home/me/src", "foot\x00-e\x00vim\x00"},
+		{"200", "/usr/lib/firefox/firefox (deleted)", "", "/usr/lib/firefox/firefox\x00"},
+	} {
+		if err := os.Mkdir(filepath.Join(dir, p.pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(p.exe, filepath.Join(dir, p.pid, "exe")); err != nil {
+			t.Skip(err)
+		}
+		if p.cwd != "" {
+			if err := os.Symlink(p.cwd, filepath.Join(dir, p.pid, "cwd")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, p.pid, "cmdline"), []byte(p.cmdline), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snap := &Snapshot{Windows: []*Window{
+		&Window{ID: 1, Name: "vim", PID: 100},
+		&Window{ID: 2, Name: "Mozilla Firefox", PID: 200},
+		&Window{ID: 3, Name: "Library", PID: 200},
+		&Window{ID: 4, Name: "gone", PID: 300},
+		&Window{ID: 5, Name: "unknown"},
+	}}
+	if err := (&ProcessCollector{}).Collect(snap); err != nil {
+		t.Fatal(err)
+	}
+	firefox := []string{"/usr/lib/firefox/firefox"}
+	expected := []*Window{
+		&Window{ID: 1, Name: "vim", PID: 100, Exe: "/usr/bin/foot", Args: []string{"foot", "-e", "vim"}, Cwd: "/home/me/src"},
+		&Window{ID: 2, Name: "Mozilla Firefox", PID: 200, Exe: "/usr/lib/firefox/firefox", Args: firefox},
+		&Window{ID: 3, Name: "Library", PID: 200, Exe: "/usr/lib/firefox/firefox", Args: firefox},
+		&Window{ID: 4, Name: "gone", PID: 300},
+		&Window{ID: 5, Name: "unknown"},
+	}
+	if !reflect.DeepEqual(snap.Windows, expected) {
+		for i, w := range snap.Windows {
+			t.Errorf("window%d: %+v", i, w)
+		}
+	}
+}
+
+func TestIsLocalHost(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+	cases := []struct {
+		machine  string
+		expected bool
+	}{
+		{"", true},
+		{"N/A", true},
+		{host, true},
+		{host + ".example.org", true},
+		{"localhost", true},
+		{"x" + host, false},
+	}
+	for i, c := range cases {
+		if isLocalHost(c.machine) != c.expected {
+			t.Errorf("case%d: %s", i, c.machine)
+		}
+	}
+}
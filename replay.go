@@ -0,0 +1,193 @@
+package ultraViolet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+func init() {
+	RegisterTracker("replay", NewReplayTracker)
+}
+
+// ErrPlaybackDone is returned by the replay and synthetic Trackers once
+// every Snapshot has been played back.
+var ErrPlaybackDone = errors.New("no more snapshots to play back")
+
+// ReplayTracker plays back the Snapshots of a file written by `uv track
+// -o` or `uv daemon`, one per call to Snap, so that everything above the
+// Tracker can be exercised without a display. Markers are played back
+// too.
+type ReplayTracker struct {
+	// Path is the file to play back.
+	Path string
+	// Start, if set, shifts the recording in time so that its first
+	// Snapshot was taken at Start.
+	Start time.Time
+	// Speed is how many times faster than recorded Snapshots are
+	// returned; Snap waits until the next one is due. If zero, they are
+	// returned right away.
+	Speed float64
+
+	snaps []*Snapshot
+	shift time.Duration
+	next  int
+	pace  pacer
+	err   error
+}
+
+var _ Tracker = (*ReplayTracker)(nil)
+var _ ContextTracker = (*ReplayTracker)(nil)
+var _ Watcher = (*ReplayTracker)(nil)
+
+// NewReplayTracker returns a ReplayTracker set up from the environment:
+// $UV_REPLAY is the file to play back, $UV_REPLAY_START the time it is
+// shifted to (RFC 3339, or "now"), and $UV_REPLAY_SPEED its speed.
+func NewReplayTracker() Tracker {
+	t := &ReplayTracker{Path: os.Getenv("UV_REPLAY")}
+	switch start := os.Getenv("UV_REPLAY_START"); start {
+	case "":
+	case "now":
+		t.Start = time.Now()
+	default:
+		t.Start, t.err = time.Parse(time.RFC3339, start)
+	}
+	if t.err == nil {
+		t.Speed, t.err = playbackSpeed()
+	}
+	return t
+}
+
+// playbackSpeed returns the speed set by $UV_REPLAY_SPEED, or 0.
+func playbackSpeed() (float64, error) {
+	s := os.Getenv("UV_REPLAY_SPEED")
+	if s == "" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(s, 64)
+	if err != nil || speed < 0 {
+		return 0, fmt.Errorf("invalid UV_REPLAY_SPEED %q", s)
+	}
+	return speed, nil
+}
+
+func (t *ReplayTracker) Deps() string {
+	if t.Path == "" {
+		return "Set UV_REPLAY to a file written by `uv track -o` or `uv daemon` to play it back.\n"
+	}
+	return ""
+}
+
+func (t *ReplayTracker) Snap() (*Snapshot, error) {
+	return t.SnapContext(context.Background())
+}
+
+// SnapContext returns the next Snapshot of the recording once it is due.
+func (t *ReplayTracker) SnapContext(ctx context.Context) (*Snapshot, error) {
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	if t.next >= len(t.snaps) {
+		return nil, ErrPlaybackDone
+	}
+	snap := t.snaps[t.next]
+	if err := t.pace.wait(ctx, snap.Time, t.Speed); err != nil {
+		return nil, err
+	}
+	t.next++
+	snap.Time = snap.Time.Add(t.shift)
+	return snap, nil
+}
+
+// Watch sends the Snapshots of the recording as they become due, and
+// returns ErrPlaybackDone after the last one. The recording has its own
+// pace, so heartbeat is ignored.
+func (t *ReplayTracker) Watch(snaps chan<- *Snapshot, heartbeat time.Duration, stop <-chan struct{}) error {
+	return watchPlayback(t, snaps, stop)
+}
+
+// load reads the recording the first time it is needed.
+func (t *ReplayTracker) load() error {
+	if t.err != nil || t.snaps != nil {
+		return t.err
+	}
+	if t.Path == "" {
+		t.err = errors.New("replay: no file to play back; set UV_REPLAY")
+		return t.err
+	}
+	f, err := os.Open(t.Path)
+	if err != nil {
+		t.err = err
+		return err
+	}
+	defer f.Close()
+	var stream *Stream
+	stream, t.err = ReadStream(f)
+	t.snaps = stream.Snapshots
+	if t.err == nil && len(t.snaps) == 0 {
+		t.err = fmt.Errorf("replay: no snapshots in %s", t.Path)
+	}
+	if t.err == nil && !t.Start.IsZero() {
+		t.shift = t.Start.Sub(t.snaps[0].Time)
+	}
+	return t.err
+}
+
+// pacer paces the Snapshots of a timeline that is played back: the
+// first one is due right away, and the others as many times faster than
+// the timeline as the speed.
+type pacer struct {
+	origin  time.Time
+	started time.Time
+}
+
+// wait waits until the Snapshot taken at t on the timeline is due, or
+// until ctx is done.
+func (p *pacer) wait(ctx context.Context, t time.Time, speed float64) error {
+	if p.started.IsZero() {
+		p.origin, p.started = t, time.Now()
+	}
+	if speed <= 0 {
+		return ctx.Err()
+	}
+	due := p.started.Add(time.Duration(float64(t.Sub(p.origin)) / speed))
+	timer := time.NewTimer(time.Until(due))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// watchPlayback implements Watch for the Trackers that play back a
+// timeline.
+func watchPlayback(t ContextTracker, snaps chan<- *Snapshot, stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		snap, err := t.SnapContext(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case snaps <- snap:
+		case <-stop:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package ultraViolet
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeRecording writes snaps as `uv track -o` does and returns the path
+// of the file.
+func writeRecording(t *testing.T, dir string, snaps []*Snapshot) string {
+	path := filepath.Join(dir, "recording.json")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, snap := range snaps {
+		if err := enc.Encode(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestReplayTracker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uv-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	at := func(m int) time.Time { return time.Date(2017, 1, 1, 9, m, 0, 0, time.UTC) }
+	windows := []*Window{&Window{ID: 1, Name: "vim - Terminal"}, &Window{ID: 2, Name: "Inbox - Mail"}}
+	path := writeRecording(t, dir, []*Snapshot{
+		{Time: at(0), Windows: windows, Active: 1, Visible: []int{1}},
+		{Time: at(1), Event: EventLock},
+		{Time: at(5), Windows: windows, Active: 2, Visible: []int{2}},
+	})
+
+	defer setenv("UV_REPLAY", path)()
+	defer setenv("UV_REPLAY_START", "2018-06-01T12:00:00Z")()
+	tracker := NewReplayTracker()
+	if deps := tracker.Deps(); deps != "" {
+		t.Errorf("deps: %s", deps)
+	}
+	shifted := func(m int) time.Time { return time.Date(2018, 6, 1, 12, m, 0, 0, time.UTC) }
+	expected := []*Snapshot{
+		{Time: shifted(0), Windows: windows, Active: 1, Visible: []int{1}},
+		{Time: shifted(1), Event: EventLock},
+		{Time: shifted(5), Windows: windows, Active: 2, Visible: []int{2}},
+	}
+	for i, e := range expected {
+		snap, err := tracker.Snap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !snap.Time.Equal(e.Time) {
+			t.Errorf("snap%d: time %s", i, snap.Time)
+		}
+		snap.Time = e.Time
+		if !reflect.DeepEqual(snap, e) {
+			t.Errorf("snap%d: %+v", i, snap)
+		}
+	}
+	if _, err := tracker.Snap(); err != ErrPlaybackDone {
+		t.Errorf("after the end: %v", err)
+	}
+
+	defer setenv("UV_REPLAY", filepath.Join(dir, "none.json"))()
+	if _, err := NewReplayTracker().Snap(); !os.IsNotExist(err) {
+		t.Errorf("no file: %v", err)
+	}
+	defer setenv("UV_REPLAY", "")()
+	if tracker := NewReplayTracker(); tracker.Deps() == "" {
+		t.Error("no deps without UV_REPLAY")
+	}
+	defer setenv("UV_REPLAY_SPEED", "fast")()
+	if _, err := NewReplayTracker().Snap(); err == nil {
+		t.Error("invalid speed")
+	}
+}
+
+func TestReplayTrackerSpeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uv-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	at := func(s int) time.Time { return time.Date(2017, 1, 1, 9, 0, s, 0, time.UTC) }
+	path := writeRecording(t, dir, []*Snapshot{{Time: at(0)}, {Time: at(1)}, {Time: at(2)}, {Time: at(3)}})
+
+	// a second of the recording every 100ms.
+	tracker := &ReplayTracker{Path: path, Speed: 10}
+	snaps := make(chan *Snapshot)
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() { done <- tracker.Watch(snaps, time.Hour, stop) }()
+	for i := 0; i < 3; i++ {
+		snap := <-snaps
+		if !snap.Time.Equal(at(i)) {
+			t.Errorf("snap%d: %s", i, snap.Time)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("played back in %s", elapsed)
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("stopped: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := tracker.SnapContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("timeout: %v", err)
+	}
+	// the snapshot that was due is not skipped.
+	if snap, err := tracker.Snap(); err != nil || !snap.Time.Equal(at(3)) {
+		t.Errorf("after timeout: %v %v", snap, err)
+	}
+}
@@ -11,24 +11,20 @@ import (
 
 const maxNumberOfBars = 30
 
+// idleLabel is the label of the time the user was idle, which is not
+// counted as usage of the active window.
+const idleLabel = "Idle"
+
 // Stats renders an HTML page with charts using stream as its data
 // source. Currently, it renders the following charts:
 // 1. A timeline of applications active, visible, and open
 // 2. A timeline of windows active, visible, and open
-// 3. A barchart of applications most often active, visible, and open
+// 3. A barchart of applications most often active, visible (weighted by
+// the part of their windows on screen), and open
+// 4. A barchart of the domains of the web pages most often active
+// 5. Barcharts of the projects and languages most often active in editors
 func Stats(stream *Stream, w io.Writer) error {
-	tlFine := NewTimeline(stream, func(w *Window) string { return w.Name })
-	tlCoarse := NewTimeline(stream, appID)
-	agg := NewAggTime(stream, appID)
-
-	if err := statsTmpl.Execute(w, &statsPage{
-		Fine:   tlFine,
-		Coarse: tlCoarse,
-		Agg:    agg,
-	}); err != nil {
-		return err
-	}
-	return nil
+	return StatsFrom(stream.Source(), w)
 }
 
 // AggTime is the list of bar charts that convey aggregate application time usage.
@@ -36,29 +32,70 @@ type AggTime struct {
 	Charts []*BarChart
 }
 
-// NewAggTime returns a new AggTime created from a Stream.
+// NewAggTime returns a new AggTime created from a Stream. Visible windows
+// count for the fraction of their area shown on screen, or fully if the
+// tracker doesn't record window geometry. Web sites are counted by the
+// domain of the URL of the active window and editor time by the project
+// and language reported by the editor, whatever labelFunc.
 func NewAggTime(stream *Stream, labelFunc func(*Window) string) *AggTime {
+	agg := newAggTime()
+	for _, snap := range stream.Snapshots {
+		agg.add(snap, labelFunc)
+	}
+	return agg
+}
+
+// newAggTime returns an AggTime without samples.
+func newAggTime() *AggTime {
 	n := strconv.Itoa(maxNumberOfBars)
 	active := NewBarChart("Active", "App", "Samples", "Top "+n+" active applications by time (multiplied by window count)")
-	visible := NewBarChart("Visible", "App", "Samples", "Top "+n+" visible applications by time (multiplied by window count)")
+	visible := NewBarChart("Visible", "App", "Samples (weighted by visible area)", "Top "+n+" visible applications by time (multiplied by the on-screen fraction of each window)")
 	all := NewBarChart("All", "App", "Samples", "Top "+n+" open applications by time (multiplied by window count)")
-	for _, snap := range stream.Snapshots {
-		windows := make(map[int]*Window)
-		for _, win := range snap.Windows {
-			windows[win.ID] = win
-		}
+	domains := NewBarChart("Domains", "Domain", "Samples", "Top "+n+" active web sites by time")
+	projects := NewBarChart("Projects", "Project", "Samples", "Top "+n+" projects by time in editors")
+	languages := NewBarChart("Languages", "Language", "Samples", "Top "+n+" languages by time in editors")
+	return &AggTime{Charts: []*BarChart{active, visible, all, domains, projects, languages}}
+}
 
-		if win := windows[snap.Active]; win != nil {
-			active.Plus(labelFunc(windows[snap.Active]), 1)
+// add counts snap in the charts of a.
+func (a *AggTime) add(snap *Snapshot, labelFunc func(*Window) string) {
+	if snap.IsMarker() || snap.Locked {
+		return
+	}
+	active, visible, all, domains, projects, languages := a.Charts[0], a.Charts[1], a.Charts[2], a.Charts[3], a.Charts[4], a.Charts[5]
+	windows := make(map[int]*Window)
+	for _, win := range snap.Windows {
+		windows[win.ID] = win
+	}
+
+	if snap.Idle {
+		active.Plus(idleLabel, 1)
+	} else if win := windows[snap.Active]; win != nil {
+		active.Plus(labelFunc(windows[snap.Active]), 1)
+		if domain := win.Domain(); domain != "" {
+			domains.Plus(domain, 1)
 		}
-		for _, v := range snap.Visible {
-			visible.Plus(labelFunc(windows[v]), 1)
+		if e := win.Editor; e != nil {
+			if e.Project != "" {
+				projects.Plus(e.Project, 1)
+			}
+			if e.Language != "" {
+				languages.Plus(e.Language, 1)
+			}
 		}
-		for _, win := range snap.Windows {
-			all.Plus(labelFunc(win), 1)
+	}
+	for _, v := range snap.Visible {
+		win := windows[v]
+		weight := 1.0
+		if win != nil && win.Geometry != nil {
+			weight = win.VisibleArea
 		}
+		visible.Plus(labelFunc(win), 1)
+		visible.PlusWeighted(labelFunc(win), weight)
+	}
+	for _, win := range snap.Windows {
+		all.Plus(labelFunc(win), 1)
 	}
-	return &AggTime{Charts: []*BarChart{active, visible, all}}
 }
 
 // BarChart is a representation of a bar chart.
@@ -68,12 +105,19 @@ type BarChart struct {
 	XLabel string
 	Title  string
 	Series map[string]int
+	// Weights, if not nil, are the heights of the bars in charts whose
+	// samples count for less than one (e.g. windows partly visible),
+	// added with PlusWeighted. Series still counts the samples.
+	Weights map[string]float64
 }
 
 // Bar represents a single bar in a bar chart.
 type Bar struct {
 	Label string
 	Count int
+	// Weight is the height of the bar: the weight of the label in
+	// Weights, or Count if the chart has no weights.
+	Weight float64
 }
 
 // NewBarChart returns a new BarChart with the specified ID, x- and
@@ -87,12 +131,26 @@ func (c *BarChart) Plus(label string, n int) {
 	c.Series[label] += n
 }
 
+// PlusWeighted adds w to the weight associated with the label, which
+// replaces its count as the height of its bar. Callers count the sample
+// with Plus as well.
+func (c *BarChart) PlusWeighted(label string, w float64) {
+	if c.Weights == nil {
+		c.Weights = make(map[string]float64)
+	}
+	c.Weights[label] += w
+}
+
 // OrderedBars returns a list of the top $maxNumberOfBars bars in the bar chart ordered by
-// decreasing count.
+// decreasing weight.
 func (c *BarChart) OrderedBars() []Bar {
 	var bars []Bar
-	for l, c := range c.Series {
-		bars = append(bars, Bar{Label: l, Count: c})
+	for l, n := range c.Series {
+		weight := float64(n)
+		if c.Weights != nil {
+			weight = c.Weights[l]
+		}
+		bars = append(bars, Bar{Label: l, Count: n, Weight: weight})
 	}
 	s := sortBars{bars}
 	sort.Sort(s)
@@ -107,15 +165,25 @@ type sortBars struct {
 	bars []Bar
 }
 
-func (s sortBars) Len() int           { return len(s.bars) }
-func (s sortBars) Less(a, b int) bool { return s.bars[a].Count > s.bars[b].Count }
-func (s sortBars) Swap(a, b int)      { s.bars[a], s.bars[b] = s.bars[b], s.bars[a] }
+func (s sortBars) Len() int { return len(s.bars) }
+func (s sortBars) Less(a, b int) bool {
+	// bars of the same weight are ordered by label, so that the page is
+	// the same from one rendering to the next.
+	if s.bars[a].Weight != s.bars[b].Weight {
+		return s.bars[a].Weight > s.bars[b].Weight
+	}
+	return s.bars[a].Label < s.bars[b].Label
+}
+func (s sortBars) Swap(a, b int) { s.bars[a], s.bars[b] = s.bars[b], s.bars[a] }
 
 // Timeline represents a timeline of application usage.
 // Start is the start time of the timeline.
 // End is the end time of the timeline.
 // Rows is a map where the keys are tags and the values are lists of
-// time ranges. Each row is a distinct sub-timeline.
+// time ranges. Each row is a distinct sub-timeline. The "Idle" row holds
+// the time the user was idle, which is left out of the "Active" row.
+// Ranges end at lock and sleep markers and at samples taken while the
+// screen was locked.
 type Timeline struct {
 	Start time.Time
 	End   time.Time
@@ -135,76 +203,142 @@ type Range struct {
 // reflect the identity of the window's application. If you're
 // tracking events by window name, the ID should be the window name.
 func NewTimeline(stream *Stream, labelFunc func(*Window) string) *Timeline {
-	if len(stream.Snapshots) == 0 {
+	b := newTimelineBuilder(labelFunc)
+	for _, snap := range stream.Snapshots {
+		b.add(snap)
+	}
+	return b.timeline()
+}
+
+// timelineBuilder builds a Timeline one Snapshot at a time.
+type timelineBuilder struct {
+	labelFunc func(*Window) string
+
+	start, end                   time.Time
+	n                            int
+	active, visible, other, idle []*Range
+	lastActive, lastIdle         *Range
+	lastVisible, lastOther       map[string]*Range
+}
+
+func newTimelineBuilder(labelFunc func(*Window) string) *timelineBuilder {
+	return &timelineBuilder{labelFunc: labelFunc, lastVisible: make(map[string]*Range), lastOther: make(map[string]*Range)}
+}
+
+// timeline returns the Timeline of the Snapshots added so far, or nil if
+// there are none.
+func (b *timelineBuilder) timeline() *Timeline {
+	if b.n == 0 {
 		return nil
 	}
-	var active, visible, other []*Range
-	var lastActive *Range
-	var lastVisible, lastOther = make(map[string]*Range), make(map[string]*Range)
-	for _, snap := range stream.Snapshots {
-		windows := make(map[int]*Window)
-		for _, win := range snap.Windows {
-			windows[win.ID] = win
-		}
+	return &Timeline{
+		Start: b.start,
+		End:   b.end,
+		Rows:  map[string][]*Range{"Active": b.active, "Idle": b.idle, "Visible": b.visible, "All": b.other},
+	}
+}
 
-		{
-			if win := windows[snap.Active]; win != nil {
-				winLabel := labelFunc(win)
-				if lastActive != nil && lastActive.Label == winLabel {
-					lastActive.End = snap.Time
-				} else {
-					if lastActive != nil {
-						lastActive.End = snap.Time
-					}
-					newRange := &Range{Label: winLabel, Start: snap.Time, End: snap.Time}
-					active = append(active, newRange)
-					lastActive = newRange
-				}
-			} else {
-				lastActive = nil
+// add adds snap, which was taken after the Snapshots added before, to
+// the Timeline.
+func (b *timelineBuilder) add(snap *Snapshot) {
+	if b.n == 0 {
+		b.start = snap.Time
+	}
+	b.n++
+	b.end = snap.Time
+	labelFunc := b.labelFunc
+	if snap.IsMarker() || snap.Locked {
+		// nothing is in use while the screen is locked or the
+		// system sleeps, so end all ranges here and start afresh
+		// with the next sample.
+		for _, last := range []*Range{b.lastActive, b.lastIdle} {
+			if last != nil {
+				last.End = snap.Time
 			}
 		}
-
-		for _, prevRange := range lastVisible {
+		for _, prevRange := range b.lastVisible {
 			prevRange.End = snap.Time
 		}
-		nextVisible := make(map[string]*Range)
-		for _, v := range snap.Visible {
-			var winLabel string
-			if win := windows[v]; win != nil {
-				winLabel = labelFunc(win)
-			}
-			if existRng, exists := lastVisible[winLabel]; !exists {
-				newRange := &Range{Label: winLabel, Start: snap.Time, End: snap.Time}
-				nextVisible[winLabel] = newRange
-				visible = append(visible, newRange)
-			} else {
-				nextVisible[winLabel] = existRng
-			}
+		for _, prevRange := range b.lastOther {
+			prevRange.End = snap.Time
 		}
-		lastVisible = nextVisible
+		b.lastActive, b.lastIdle = nil, nil
+		b.lastVisible, b.lastOther = make(map[string]*Range), make(map[string]*Range)
+		return
+	}
 
-		for _, prevRange := range lastOther {
-			prevRange.End = snap.Time
+	windows := make(map[int]*Window)
+	for _, win := range snap.Windows {
+		windows[win.ID] = win
+	}
+
+	if snap.Idle {
+		if b.lastActive != nil {
+			b.lastActive.End = snap.Time
+			b.lastActive = nil
+		}
+		if b.lastIdle != nil {
+			b.lastIdle.End = snap.Time
+		} else {
+			b.lastIdle = &Range{Label: idleLabel, Start: snap.Time, End: snap.Time}
+			b.idle = append(b.idle, b.lastIdle)
+		}
+	} else {
+		if b.lastIdle != nil {
+			b.lastIdle.End = snap.Time
+			b.lastIdle = nil
 		}
-		nextOther := make(map[string]*Range)
-		for _, win := range snap.Windows {
+		if win := windows[snap.Active]; win != nil {
 			winLabel := labelFunc(win)
-			if existRng, exists := lastOther[winLabel]; !exists {
-				newRange := &Range{Label: winLabel, Start: snap.Time, End: snap.Time}
-				nextOther[winLabel] = newRange
-				other = append(other, newRange)
+			if b.lastActive != nil && b.lastActive.Label == winLabel {
+				b.lastActive.End = snap.Time
 			} else {
-				nextOther[winLabel] = existRng
+				if b.lastActive != nil {
+					b.lastActive.End = snap.Time
+				}
+				newRange := &Range{Label: winLabel, Start: snap.Time, End: snap.Time}
+				b.active = append(b.active, newRange)
+				b.lastActive = newRange
 			}
+		} else {
+			b.lastActive = nil
 		}
-		lastOther = nextOther
 	}
-	return &Timeline{
-		Start: stream.Snapshots[0].Time,
-		End:   stream.Snapshots[len(stream.Snapshots)-1].Time,
-		Rows:  map[string][]*Range{"Active": active, "Visible": visible, "All": other},
+
+	for _, prevRange := range b.lastVisible {
+		prevRange.End = snap.Time
 	}
+	nextVisible := make(map[string]*Range)
+	for _, v := range snap.Visible {
+		var winLabel string
+		if win := windows[v]; win != nil {
+			winLabel = labelFunc(win)
+		}
+		if existRng, exists := b.lastVisible[winLabel]; !exists {
+			newRange := &Range{Label: winLabel, Start: snap.Time, End: snap.Time}
+			nextVisible[winLabel] = newRange
+			b.visible = append(b.visible, newRange)
+		} else {
+			nextVisible[winLabel] = existRng
+		}
+	}
+	b.lastVisible = nextVisible
+
+	for _, prevRange := range b.lastOther {
+		prevRange.End = snap.Time
+	}
+	nextOther := make(map[string]*Range)
+	for _, win := range snap.Windows {
+		winLabel := labelFunc(win)
+		if existRng, exists := b.lastOther[winLabel]; !exists {
+			newRange := &Range{Label: winLabel, Start: snap.Time, End: snap.Time}
+			nextOther[winLabel] = newRange
+			b.other = append(b.other, newRange)
+		} else {
+			nextOther[winLabel] = existRng
+		}
+	}
+	b.lastOther = nextOther
 }
 
 // timeToJS is a template helper function that converts a time.Time to
@@ -262,6 +396,14 @@ var statsTmpl = template.Must(template.New("").Funcs(map[string]interface{}{
 				{{timeToJS .End}},
 			],
 		{{end}}
+		{{range .Rows.Idle}}
+			[
+				"Idle",
+				{{printf "%q" .Label}},
+				{{timeToJS .Start}},
+				{{timeToJS .End}},
+			],
+		{{end}}
 		{{range .Rows.Visible}}
 			[
 				"Visible",
@@ -295,7 +437,7 @@ var statsTmpl = template.Must(template.New("").Funcs(map[string]interface{}{
       var data = google.visualization.arrayToDataTable([
         ['Application', 'Number of samples'],
 		{{range $chart.OrderedBars}}
-		[{{printf "%q" .Label}}, {{.Count}}],
+		[{{printf "%q" .Label}}, {{.Weight}}],
 		{{end}}
       ]);
 
@@ -341,6 +483,14 @@ var statsTmpl = template.Must(template.New("").Funcs(map[string]interface{}{
 				{{timeToJS .End}},
 			],
 		{{end}}
+		{{range .Rows.Idle}}
+			[
+				"Idle",
+				{{printf "%q" .Label}},
+				{{timeToJS .Start}},
+				{{timeToJS .End}},
+			],
+		{{end}}
 		{{range .Rows.Visible}}
 			[
 				"Visible",
@@ -0,0 +1,136 @@
+package ultraViolet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewTimelineIdle(t *testing.T) {
+	at := func(m int) time.Time { return time.Date(2017, 1, 1, 9, m, 0, 0, time.UTC) }
+	windows := []*Window{&Window{ID: 1, Name: "a"}, &Window{ID: 2, Name: "b"}}
+	stream := &Stream{Snapshots: []*Snapshot{
+		{Time: at(0), Windows: windows, Active: 1},
+		{Time: at(1), Windows: windows, Active: 1},
+		{Time: at(2), Windows: windows, Active: 1, Idle: true},
+		{Time: at(3), Windows: windows, Active: 1, Idle: true},
+		{Time: at(4), Windows: windows, Active: 2},
+		{Time: at(5), Windows: windows, Active: 2},
+	}}
+	tl := NewTimeline(stream, func(w *Window) string { return w.Name })
+	expectedActive := []*Range{
+		{Label: "a", Start: at(0), End: at(2)},
+		{Label: "b", Start: at(4), End: at(5)},
+	}
+	if !reflect.DeepEqual(tl.Rows["Active"], expectedActive) {
+		t.Errorf("active: %v", tl.Rows["Active"])
+	}
+	expectedIdle := []*Range{{Label: "Idle", Start: at(2), End: at(4)}}
+	if !reflect.DeepEqual(tl.Rows["Idle"], expectedIdle) {
+		t.Errorf("idle: %v", tl.Rows["Idle"])
+	}
+
+	agg := NewAggTime(stream, func(w *Window) string { return w.Name })
+	if expected := map[string]int{"a": 2, "b": 2, "Idle": 2}; !reflect.DeepEqual(agg.Charts[0].Series, expected) {
+		t.Errorf("agg: %v", agg.Charts[0].Series)
+	}
+}
+
+func TestNewTimelineMarkers(t *testing.T) {
+	at := func(m int) time.Time { return time.Date(2017, 1, 1, 9, m, 0, 0, time.UTC) }
+	windows := []*Window{&Window{ID: 1, Name: "a"}, &Window{ID: 2, Name: "b"}}
+	stream := &Stream{Snapshots: []*Snapshot{
+		{Time: at(0), Windows: windows, Active: 1, Visible: []int{1}},
+		{Time: at(1), Windows: windows, Active: 1, Visible: []int{1}},
+		{Time: at(2), Event: EventSleep},
+		{Time: at(50), Event: EventResume},
+		{Time: at(51), Windows: windows, Active: 1, Visible: []int{1}},
+		{Time: at(52), Windows: windows, Active: 1, Visible: []int{1}, Locked: true},
+		{Time: at(53), Windows: windows, Active: 1, Visible: []int{1}},
+	}}
+	tl := NewTimeline(stream, func(w *Window) string { return w.Name })
+	expected := []*Range{
+		{Label: "a", Start: at(0), End: at(2)},
+		{Label: "a", Start: at(51), End: at(52)},
+		{Label: "a", Start: at(53), End: at(53)},
+	}
+	for _, row := range []string{"Active", "Visible"} {
+		if !reflect.DeepEqual(tl.Rows[row], expected) {
+			t.Errorf("%s: %v", row, tl.Rows[row])
+		}
+	}
+	if len(tl.Rows["All"]) != 6 {
+		t.Errorf("all: %v", tl.Rows["All"])
+	}
+
+	agg := NewAggTime(stream, func(w *Window) string { return w.Name })
+	if expected := map[string]int{"a": 4}; !reflect.DeepEqual(agg.Charts[0].Series, expected) {
+		t.Errorf("agg: %v", agg.Charts[0].Series)
+	}
+}
+
+func TestNewAggTimeVisibleArea(t *testing.T) {
+	windows := []*Window{
+		&Window{ID: 1, Name: "a", Geometry: &Rect{0, 0, 100, 100}, VisibleArea: 1},
+		&Window{ID: 2, Name: "b", Geometry: &Rect{50, 0, 100, 100}, VisibleArea: 0.25},
+		&Window{ID: 3, Name: "c"},
+	}
+	stream := &Stream{Snapshots: []*Snapshot{
+		{Windows: windows, Active: 1, Visible: []int{1, 2, 3}},
+		{Windows: windows, Active: 1, Visible: []int{1, 2, 3}},
+	}}
+	agg := NewAggTime(stream, func(w *Window) string { return w.Name })
+	if expected := map[string]int{"a": 2, "b": 2, "c": 2}; !reflect.DeepEqual(agg.Charts[1].Series, expected) {
+		t.Errorf("visible samples: %v", agg.Charts[1].Series)
+	}
+	if expected := []Bar{{"a", 2, 2}, {"c", 2, 2}, {"b", 2, 0.5}}; !reflect.DeepEqual(agg.Charts[1].OrderedBars(), expected) {
+		t.Errorf("visible: %v", agg.Charts[1].OrderedBars())
+	}
+	if expected := map[string]int{"a": 2, "b": 2, "c": 2}; !reflect.DeepEqual(agg.Charts[2].Series, expected) {
+		t.Errorf("all: %v", agg.Charts[2].Series)
+	}
+}
+
+func TestNewAggTimeDomains(t *testing.T) {
+	windows := []*Window{
+		&Window{ID: 1, Name: "Example - Mozilla Firefox", URL: "https://www.example.org/"},
+		&Window{ID: 2, Name: "vim"},
+	}
+	stream := &Stream{Snapshots: []*Snapshot{
+		{Windows: windows, Active: 1},
+		{Windows: windows, Active: 1},
+		{Windows: windows, Active: 1, Idle: true},
+		{Windows: windows, Active: 2},
+	}}
+	agg := NewAggTime(stream, DomainLabel)
+	if expected := map[string]int{"example.org": 2, "vim": 1, "Idle": 1}; !reflect.DeepEqual(agg.Charts[0].Series, expected) {
+		t.Errorf("active: %v", agg.Charts[0].Series)
+	}
+	if expected := map[string]int{"example.org": 2}; !reflect.DeepEqual(agg.Charts[3].Series, expected) {
+		t.Errorf("domains: %v", agg.Charts[3].Series)
+	}
+}
+
+func TestNewAggTimeEditors(t *testing.T) {
+	windows := []*Window{
+		&Window{ID: 1, Name: "show.go - uv - Visual Studio Code", Editor: &EditorContext{Editor: "vscode", Project: "uv", Language: "go"}},
+		&Window{ID: 2, Name: "README.md - uv - Visual Studio Code", Editor: &EditorContext{Editor: "vscode", Project: "uv", Language: "markdown"}},
+		&Window{ID: 3, Name: "vim"},
+	}
+	stream := &Stream{Snapshots: []*Snapshot{
+		{Windows: windows, Active: 1},
+		{Windows: windows, Active: 1},
+		{Windows: windows, Active: 2},
+		{Windows: windows, Active: 3},
+	}}
+	agg := NewAggTime(stream, ProjectLabel)
+	if expected := map[string]int{"uv": 3, "vim": 1}; !reflect.DeepEqual(agg.Charts[0].Series, expected) {
+		t.Errorf("active: %v", agg.Charts[0].Series)
+	}
+	if expected := map[string]int{"uv": 3}; !reflect.DeepEqual(agg.Charts[4].Series, expected) {
+		t.Errorf("projects: %v", agg.Charts[4].Series)
+	}
+	if expected := map[string]int{"go": 2, "markdown": 1}; !reflect.DeepEqual(agg.Charts[5].Series, expected) {
+		t.Errorf("languages: %v", agg.Charts[5].Series)
+	}
+}
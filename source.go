@@ -0,0 +1,139 @@
+package ultraViolet
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// SnapshotSource yields Snapshots one at a time, so that data too large
+// to be held in memory as a Stream can be read, filtered and summarized
+// as it goes. Decoders, Streams (through Source) and the Stores of the
+// storage package are SnapshotSources.
+type SnapshotSource interface {
+	// Next returns the next Snapshot, or io.EOF after the last one.
+	Next() (*Snapshot, error)
+}
+
+// Source returns a SnapshotSource yielding the Snapshots of s.
+func (s *Stream) Source() SnapshotSource {
+	return &streamSource{snaps: s.Snapshots}
+}
+
+type streamSource struct {
+	snaps []*Snapshot
+}
+
+func (s *streamSource) Next() (*Snapshot, error) {
+	if len(s.snaps) == 0 {
+		return nil, io.EOF
+	}
+	snap := s.snaps[0]
+	s.snaps = s.snaps[1:]
+	return snap, nil
+}
+
+// Next returns the next Snapshot, or io.EOF after the last one, as
+// Decode does.
+func (d *Decoder) Next() (*Snapshot, error) {
+	return d.Decode()
+}
+
+// Collect reads all the Snapshots of src into a Stream.
+func Collect(src SnapshotSource) (*Stream, error) {
+	stream := &Stream{}
+	err := each(src, func(snap *Snapshot) {
+		stream.Snapshots = append(stream.Snapshots, snap)
+	})
+	return stream, err
+}
+
+// each calls f with every Snapshot of src.
+func each(src SnapshotSource, f func(snap *Snapshot)) error {
+	for {
+		snap, err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		f(snap)
+	}
+}
+
+// Filter returns a SnapshotSource yielding the Snapshots of src for
+// which keep returns true.
+func Filter(src SnapshotSource, keep func(snap *Snapshot) bool) SnapshotSource {
+	return &filterSource{src: src, keep: keep}
+}
+
+type filterSource struct {
+	src  SnapshotSource
+	keep func(snap *Snapshot) bool
+}
+
+func (s *filterSource) Next() (*Snapshot, error) {
+	for {
+		snap, err := s.src.Next()
+		if err != nil || s.keep(snap) {
+			return snap, err
+		}
+	}
+}
+
+// Between returns a SnapshotSource yielding the Snapshots of src taken
+// from from until to (excluded). A zero from or to leaves the range open
+// on that side.
+func Between(src SnapshotSource, from, to time.Time) SnapshotSource {
+	if from.IsZero() && to.IsZero() {
+		return src
+	}
+	return Filter(src, func(snap *Snapshot) bool {
+		return (from.IsZero() || !snap.Time.Before(from)) && (to.IsZero() || snap.Time.Before(to))
+	})
+}
+
+// NewTimelineFrom returns a new Timeline of the Snapshots of src, as
+// NewTimeline does for a Stream.
+func NewTimelineFrom(src SnapshotSource, labelFunc func(*Window) string) (*Timeline, error) {
+	b := newTimelineBuilder(labelFunc)
+	err := each(src, b.add)
+	return b.timeline(), err
+}
+
+// NewAggTimeFrom returns a new AggTime of the Snapshots of src, as
+// NewAggTime does for a Stream.
+func NewAggTimeFrom(src SnapshotSource, labelFunc func(*Window) string) (*AggTime, error) {
+	agg := newAggTime()
+	err := each(src, func(snap *Snapshot) { agg.add(snap, labelFunc) })
+	return agg, err
+}
+
+// StatsFrom renders the HTML page of Stats from the Snapshots of src,
+// reading them once.
+func StatsFrom(src SnapshotSource, w io.Writer) error {
+	fine := newTimelineBuilder(func(w *Window) string { return w.Name })
+	coarse := newTimelineBuilder(appID)
+	agg := newAggTime()
+	if err := each(src, func(snap *Snapshot) {
+		fine.add(snap)
+		coarse.add(snap)
+		agg.add(snap, appID)
+	}); err != nil {
+		return err
+	}
+	return statsTmpl.Execute(w, &statsPage{
+		Fine:   fine.timeline(),
+		Coarse: coarse.timeline(),
+		Agg:    agg,
+	})
+}
+
+// ListFrom prints the Snapshots of src as List does, one at a time.
+func ListFrom(src SnapshotSource) error {
+	return each(src, func(snap *Snapshot) {
+		fmt.Fprintf(os.Stdout, "%s", snap.Print())
+	})
+}
@@ -0,0 +1,75 @@
+package ultraViolet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// failingSource yields the Snapshots of a Stream, then fails.
+type failingSource struct {
+	SnapshotSource
+}
+
+var errTestSource = errors.New("disk on fire")
+
+func (s failingSource) Next() (*Snapshot, error) {
+	snap, err := s.SnapshotSource.Next()
+	if err == io.EOF {
+		err = errTestSource
+	}
+	return snap, err
+}
+
+func TestSnapshotSource(t *testing.T) {
+	stream := &Stream{Snapshots: playAll(t, &SyntheticTracker{Config: syntheticTestConfig(), Seed: 3})}
+
+	collected, err := Collect(stream.Source())
+	if err != nil || !reflect.DeepEqual(collected, stream) {
+		t.Errorf("collected: %v", err)
+	}
+
+	from := time.Date(2017, 1, 6, 10, 0, 0, 0, time.UTC)
+	to := time.Date(2017, 1, 9, 0, 0, 0, 0, time.UTC)
+	between, err := Collect(Between(stream.Source(), from, to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Friday from lunch on: 2 markers and 45 samples.
+	if n := len(between.Snapshots); n != 47 || !between.Snapshots[0].Time.Equal(from) {
+		t.Errorf("between: %d %s", n, between.Snapshots[0].Time)
+	}
+
+	tl, err := NewTimelineFrom(stream.Source(), appID)
+	if err != nil || !reflect.DeepEqual(tl, NewTimeline(stream, appID)) {
+		t.Errorf("timeline: %v", err)
+	}
+	agg, err := NewAggTimeFrom(stream.Source(), appID)
+	if err != nil || !reflect.DeepEqual(agg, NewAggTime(stream, appID)) {
+		t.Errorf("agg: %v", err)
+	}
+	if tl, err := NewTimelineFrom((&Stream{}).Source(), appID); tl != nil || err != nil {
+		t.Errorf("empty timeline: %v %v", tl, err)
+	}
+
+	var fromSource, fromStream bytes.Buffer
+	if err := StatsFrom(stream.Source(), &fromSource); err != nil {
+		t.Fatal(err)
+	}
+	if err := Stats(stream, &fromStream); err != nil {
+		t.Fatal(err)
+	}
+	if fromSource.String() != fromStream.String() {
+		t.Error("stats differ")
+	}
+
+	if _, err := NewAggTimeFrom(failingSource{stream.Source()}, appID); err != errTestSource {
+		t.Errorf("failing agg: %v", err)
+	}
+	if err := StatsFrom(failingSource{stream.Source()}, &fromSource); err != errTestSource {
+		t.Errorf("failing stats: %v", err)
+	}
+}
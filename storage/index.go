@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aimof/ultra-violet"
+)
+
+// DefaultIndexInterval is the time between two entries of the index of a
+// segment by default.
+const DefaultIndexInterval = 10 * time.Minute
+
+// indexEntry locates a Snapshot that decoding can start at.
+type indexEntry struct {
+	Time   time.Time
+	Offset int64
+	// Format is the format of the lines of the segment at Offset.
+	Format string
+}
+
+// index is the sparse index of a segment, saved next to it in PATH.idx.
+// It is brought up to date whenever the segment is queried after
+// Snapshots were appended to it.
+type index struct {
+	// Size is how much of the segment is indexed.
+	Size int64
+	// Ordered reports whether the Snapshots of the segment are in
+	// chronological order; segments that aren't are read in full.
+	Ordered bool
+	// Last is the time of the latest Snapshot indexed.
+	Last    time.Time
+	Entries []indexEntry
+}
+
+// seek returns the entry to start decoding at to find the Snapshots
+// taken from from on.
+func (idx *index) seek(from time.Time) indexEntry {
+	start := indexEntry{Format: ultraViolet.FormatJSON}
+	if from.IsZero() || !idx.Ordered {
+		return start
+	}
+	// the Snapshots before an entry taken before from were too.
+	i := sort.Search(len(idx.Entries), func(i int) bool { return !idx.Entries[i].Time.Before(from) })
+	if i > 0 {
+		start = idx.Entries[i-1]
+	}
+	return start
+}
+
+// index returns the index of seg, updated to the end of the segment.
+func (s *Store) index(seg *Segment) (*index, error) {
+	fi, err := os.Stat(seg.Path)
+	if err != nil {
+		return nil, err
+	}
+	path := seg.Path + ".idx"
+	idx := readIndex(path)
+	if idx.Size == fi.Size() {
+		return idx, nil
+	}
+	if idx.Size > fi.Size() {
+		// the segment was rewritten.
+		idx = &index{Ordered: true}
+	}
+	ok, err := s.extendIndex(idx, seg.Path, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// the segment was rewritten, and has grown since.
+		idx = &index{Ordered: true}
+		if _, err := s.extendIndex(idx, seg.Path, fi.Size()); err != nil {
+			return nil, err
+		}
+	}
+	// the index only saves time; the segment can be read without it,
+	// e.g. in a directory of someone else's.
+	idx.save(path)
+	return idx, nil
+}
+
+// extendIndex indexes the segment in path from the last entry of idx on,
+// up to size. It returns false if that entry no longer locates the
+// Snapshot it did.
+func (s *Store) extendIndex(idx *index, path string, size int64) (bool, error) {
+	// carry on from the last entry, which is read again.
+	start := indexEntry{Format: ultraViolet.FormatJSON}
+	resumed := len(idx.Entries) > 0
+	if resumed {
+		n := len(idx.Entries)
+		start = idx.Entries[n-1]
+		idx.Entries = idx.Entries[:n-1]
+		idx.Last = start.Time
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(start.Offset, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	interval := s.IndexInterval
+	if interval <= 0 {
+		interval = DefaultIndexInterval
+	}
+	d := ultraViolet.NewDecoderAt(f, start.Offset, start.Format)
+	// broken lines are skipped, as Cursors skip them, but the index ends
+	// before a line being written until it can be read.
+	truncated := int64(-1)
+	d.Skip = func(err *ultraViolet.LineError) {
+		if err.Err == ultraViolet.ErrTruncatedLine {
+			truncated = err.Offset
+		}
+	}
+	for first := true; ; first = false {
+		offset := d.Offset()
+		snap, err := d.Decode()
+		if err != nil {
+			idx.Size = offset
+			if err == io.EOF {
+				idx.Size = size
+				if truncated >= 0 {
+					idx.Size = truncated
+				}
+			}
+			break
+		}
+		if first && resumed && (!d.Keyframe() || !snap.Time.Equal(start.Time)) {
+			return false, nil
+		}
+		if snap.Time.Before(idx.Last) {
+			idx.Ordered = false
+		} else {
+			idx.Last = snap.Time
+		}
+		n := len(idx.Entries)
+		if d.Keyframe() && (n == 0 || snap.Time.Sub(idx.Entries[n-1].Time) >= interval) {
+			idx.Entries = append(idx.Entries, indexEntry{Time: snap.Time, Offset: offset, Format: d.Format()})
+		}
+	}
+	return true, nil
+}
+
+// readIndex reads the index saved in path, or returns an empty one.
+func readIndex(path string) *index {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return &index{Ordered: true}
+	}
+	var idx index
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return &index{Ordered: true}
+	}
+	return &idx
+}
+
+// save saves idx in path, through a temporary file so that readers never
+// see half an index.
+func (idx *index) save(path string) error {
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
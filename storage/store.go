@@ -0,0 +1,209 @@
+// Package storage manages the data files of uv: one segment per day of
+// Snapshots under DIR/data/YYYY/MM/DD.json, as written by `uv daemon` and
+// `uv watch`, with a sparse index of the times in each, so that the
+// Snapshots of a time range can be read without reading all of them.
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aimof/ultra-violet"
+)
+
+// segmentLayout is the path of a segment below the data directory, as a
+// time layout.
+const segmentLayout = "2006/01/02.json"
+
+// Store is a directory of data files.
+type Store struct {
+	// Dir is the directory the segments are under, in Dir/data.
+	Dir string
+	// IndexInterval is the time between two entries of the index of a
+	// segment. If zero, DefaultIndexInterval is used.
+	IndexInterval time.Duration
+	// KeyframeInterval is the KeyframeInterval of the segments Writers
+	// write in ultraViolet.FormatDelta.
+	KeyframeInterval int
+	// Sync is when Writers sync the segments they append to; see Writer.
+	Sync ultraViolet.SyncPolicy
+}
+
+// Open returns the Store in dir, which must exist.
+func Open(dir string) (*Store, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	return &Store{Dir: dir}, nil
+}
+
+// Segment is the data file of a day.
+type Segment struct {
+	Path string
+	// Day is midnight of the day, in the local time zone.
+	Day time.Time
+}
+
+// SegmentPath returns the path of the segment of the day of t, creating
+// its parent directories if necessary.
+func (s *Store) SegmentPath(t time.Time) (string, error) {
+	path := filepath.Join(s.Dir, "data", filepath.FromSlash(t.Format(segmentLayout)))
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Segments returns the segments that may hold Snapshots taken from from
+// until to, in chronological order. A zero from or to leaves the range
+// open on that side.
+func (s *Store) Segments(from, to time.Time) ([]*Segment, error) {
+	paths, err := filepath.Glob(filepath.Join(s.Dir, "data", "[0-9]*", "[0-9]*", "[0-9]*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var segments []*Segment
+	for _, path := range paths {
+		rel, err := filepath.Rel(filepath.Join(s.Dir, "data"), path)
+		if err != nil {
+			return nil, err
+		}
+		day, err := time.ParseInLocation(segmentLayout, filepath.ToSlash(rel), time.Local)
+		if err != nil {
+			// not a segment.
+			continue
+		}
+		// Snapshots are filed under the day of their own time zone,
+		// which may be a day off the local one.
+		if !from.IsZero() && day.AddDate(0, 0, 2).Before(from) || !to.IsZero() && day.AddDate(0, 0, -1).After(to) {
+			continue
+		}
+		segments = append(segments, &Segment{Path: path, Day: day})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Day.Before(segments[j].Day) })
+	return segments, nil
+}
+
+// Query returns the Snapshots taken from from until to (excluded), in the
+// order they were written. A zero from or to leaves the range open on
+// that side. Query reads them all into memory; Cursor reads them one at
+// a time.
+func (s *Store) Query(from, to time.Time) (*ultraViolet.Stream, error) {
+	c, err := s.Cursor(from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return ultraViolet.Collect(c)
+}
+
+// Cursor returns a Cursor over the Snapshots Query returns.
+func (s *Store) Cursor(from, to time.Time) (*Cursor, error) {
+	segments, err := s.Segments(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return &Cursor{store: s, segments: segments, from: from, to: to}, nil
+}
+
+// Cursor is a SnapshotSource reading the Snapshots of a time range from
+// the segments of a Store, one segment after the other.
+type Cursor struct {
+	// Skip, if set, is called with the error of each line of a segment
+	// that can't be decoded, which is then skipped, as by the Skip of an
+	// ultraViolet.Decoder. Its File is the path of the segment.
+	Skip func(err *ultraViolet.LineError)
+
+	store    *Store
+	segments []*Segment
+	from, to time.Time
+
+	f       *os.File
+	d       *ultraViolet.Decoder
+	ordered bool
+}
+
+var _ ultraViolet.SnapshotSource = (*Cursor)(nil)
+
+// Next returns the next Snapshot of the range, or io.EOF after the last
+// one.
+func (c *Cursor) Next() (*ultraViolet.Snapshot, error) {
+	for {
+		if c.d == nil {
+			if len(c.segments) == 0 {
+				return nil, io.EOF
+			}
+			seg := c.segments[0]
+			c.segments = c.segments[1:]
+			if err := c.open(seg); err != nil {
+				return nil, err
+			}
+		}
+		snap, err := c.d.Decode()
+		if err == io.EOF {
+			c.closeSegment()
+			continue
+		}
+		if err, ok := err.(*ultraViolet.LineError); ok {
+			err.File = c.f.Name()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !c.to.IsZero() && !snap.Time.Before(c.to) {
+			if c.ordered {
+				// the rest of the segment is later.
+				c.closeSegment()
+			}
+			continue
+		}
+		if c.from.IsZero() || !snap.Time.Before(c.from) {
+			return snap, nil
+		}
+	}
+}
+
+// open starts reading seg, at the last Snapshot of its index taken
+// before c.from.
+func (c *Cursor) open(seg *Segment) error {
+	idx, err := c.store.index(seg)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(seg.Path)
+	if err != nil {
+		return err
+	}
+	entry := idx.seek(c.from)
+	if _, err := f.Seek(entry.Offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	c.f, c.d, c.ordered = f, ultraViolet.NewDecoderAt(f, entry.Offset, entry.Format), idx.Ordered
+	if c.Skip != nil {
+		c.d.Skip = func(err *ultraViolet.LineError) {
+			err.File = seg.Path
+			c.Skip(err)
+		}
+	}
+	return nil
+}
+
+// Close stops reading. Next returns io.EOF afterwards.
+func (c *Cursor) Close() error {
+	c.segments = nil
+	return c.closeSegment()
+}
+
+// closeSegment closes the segment being read, if any.
+func (c *Cursor) closeSegment() error {
+	if c.f == nil {
+		return nil
+	}
+	err := c.f.Close()
+	c.f, c.d = nil, nil
+	return err
+}
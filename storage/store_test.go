@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aimof/ultra-violet"
+)
+
+// testStore returns a Store holding three synthetic workdays, and their
+// Snapshots.
+func testStore(t *testing.T, format string) (*Store, []*ultraViolet.Snapshot) {
+	dir, err := ioutil.TempDir("", "uv-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a keyframe every hour.
+	s.KeyframeInterval = 12
+	c := ultraViolet.DefaultSyntheticConfig()
+	c.Start = time.Date(2017, 1, 4, 0, 0, 0, 0, time.Local)
+	c.Days = 3
+	c.Interval = 5 * time.Minute
+	tracker := &ultraViolet.SyntheticTracker{Config: c, Seed: 1}
+
+	w, err := s.NewWriter(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snaps []*ultraViolet.Snapshot
+	for {
+		snap, err := tracker.Snap()
+		if err == ultraViolet.ErrPlaybackDone {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(snap); err != nil {
+			t.Fatal(err)
+		}
+		snaps = append(snaps, snap)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return s, snaps
+}
+
+// sameSnapshots reports whether a and b hold the same Snapshots, which
+// may differ in the representation of their time zones.
+func sameSnapshots(a, b []*ultraViolet.Snapshot) bool {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		panic(err)
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		panic(err)
+	}
+	return bytes.Equal(ja, jb)
+}
+
+// between returns the Snapshots of snaps taken from from until to.
+func between(snaps []*ultraViolet.Snapshot, from, to time.Time) []*ultraViolet.Snapshot {
+	var in []*ultraViolet.Snapshot
+	for _, snap := range snaps {
+		if (from.IsZero() || !snap.Time.Before(from)) && (to.IsZero() || snap.Time.Before(to)) {
+			in = append(in, snap)
+		}
+	}
+	return in
+}
+
+func TestStoreQuery(t *testing.T) {
+	at := func(d, h, m int) time.Time { return time.Date(2017, 1, d, h, m, 0, 0, time.Local) }
+	cases := []struct {
+		from, to time.Time
+		segments int
+	}{
+		{time.Time{}, time.Time{}, 3},
+		{at(5, 0, 0), at(6, 0, 0), 1},
+		{at(5, 10, 7), at(5, 16, 0), 1},
+		{at(4, 17, 0), at(6, 9, 30), 3},
+		{at(6, 12, 0), time.Time{}, 1},
+		{time.Time{}, at(4, 9, 5), 1},
+		{at(9, 0, 0), at(10, 0, 0), 0},
+	}
+	for _, format := range ultraViolet.Formats {
+		s, snaps := testStore(t, format)
+		defer os.RemoveAll(s.Dir)
+		for i, c := range cases {
+			// the first query indexes the segments, the second uses
+			// the indexes.
+			for j := 0; j < 2; j++ {
+				stream, err := s.Query(c.from, c.to)
+				if err != nil {
+					t.Fatalf("%s case%d: %s", format, i, err)
+				}
+				if expected := between(snaps, c.from, c.to); !sameSnapshots(stream.Snapshots, expected) {
+					t.Errorf("%s case%d: %d snapshots instead of %d", format, i, len(stream.Snapshots), len(expected))
+				}
+			}
+			segments, err := s.Segments(c.from, c.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// days on either side may hold snapshots of another
+			// time zone.
+			if len(segments) < c.segments || len(segments) > c.segments+2 {
+				t.Errorf("%s case%d: %d segments", format, i, len(segments))
+			}
+		}
+	}
+}
+
+func TestStoreIndex(t *testing.T) {
+	s, snaps := testStore(t, ultraViolet.FormatDelta)
+	defer os.RemoveAll(s.Dir)
+	s.IndexInterval = time.Hour
+	seg := &Segment{Path: filepath.Join(s.Dir, "data", "2017", "01", "04.json")}
+	idx, err := s.index(seg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(seg.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a workday of 9 hours, with a keyframe every hour.
+	if !idx.Ordered || idx.Size != fi.Size() || len(idx.Entries) < 8 {
+		t.Errorf("index: %+v", idx)
+	}
+	for i := 1; i < len(idx.Entries); i++ {
+		if idx.Entries[i].Time.Sub(idx.Entries[i-1].Time) < time.Hour || idx.Entries[i].Format != ultraViolet.FormatDelta {
+			t.Errorf("entry%d: %+v", i, idx.Entries[i])
+		}
+	}
+	if saved := readIndex(seg.Path + ".idx"); !reflect.DeepEqual(saved, idx) {
+		t.Errorf("saved: %+v", saved)
+	}
+	last := idx.Entries[len(idx.Entries)-1]
+	if entry := idx.seek(last.Time); entry != idx.Entries[len(idx.Entries)-2] {
+		t.Errorf("seek: %+v", entry)
+	}
+	if entry := idx.seek(last.Time.Add(time.Second)); entry != last {
+		t.Errorf("seek after the last entry: %+v", entry)
+	}
+
+	// snapshots appended afterwards, out of order.
+	w, err := s.NewWriter(ultraViolet.FormatDelta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	late := *snaps[0]
+	late.Time = late.Time.Add(time.Minute)
+	if err := w.Write(&late); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if idx, err = s.index(seg); err != nil {
+		t.Fatal(err)
+	}
+	if idx.Ordered {
+		t.Error("ordered")
+	}
+	stream, err := s.Query(late.Time, late.Time.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.Snapshots) != 1 || !stream.Snapshots[0].Time.Equal(late.Time) {
+		t.Errorf("late: %s", stream.Print())
+	}
+}
+
+func TestStoreCursor(t *testing.T) {
+	s, snaps := testStore(t, ultraViolet.FormatDelta)
+	defer os.RemoveAll(s.Dir)
+
+	c, err := s.Cursor(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(snaps)/2; i++ {
+		snap, err := c.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !snap.Time.Equal(snaps[i].Time) {
+			t.Errorf("snap%d: %s", i, snap.Time)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Error(err)
+	}
+	if snap, err := c.Next(); err != io.EOF {
+		t.Errorf("after close: %v %v", snap, err)
+	}
+}
+
+func TestStoreBrokenLines(t *testing.T) {
+	s, snaps := testStore(t, ultraViolet.FormatDelta)
+	defer os.RemoveAll(s.Dir)
+	seg := &Segment{Path: filepath.Join(s.Dir, "data", "2017", "01", "04.json")}
+	if _, err := s.index(seg); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(seg.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a line cut short by a crash.
+	f, err := os.OpenFile(seg.Path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Time":"2017-01-04T23:00:00Z","Windows":[{"ID":1,"Na`)
+	f.Close()
+	idx, err := s.index(seg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Size != fi.Size() {
+		t.Errorf("indexed %d bytes of %d", idx.Size, fi.Size())
+	}
+
+	if _, err := s.Query(time.Time{}, time.Time{}); err == nil {
+		t.Error("no error without Skip")
+	} else if lineErr, ok := err.(*ultraViolet.LineError); !ok || lineErr.File != seg.Path || lineErr.Err != ultraViolet.ErrTruncatedLine {
+		t.Errorf("error: %v", err)
+	}
+	c, err := s.Cursor(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	var skipped []*ultraViolet.LineError
+	c.Skip = func(err *ultraViolet.LineError) { skipped = append(skipped, err) }
+	stream, err := ultraViolet.Collect(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameSnapshots(stream.Snapshots, snaps) || len(skipped) != 1 || skipped[0].File != seg.Path || skipped[0].Offset != fi.Size() {
+		t.Errorf("%d snapshots, skipped %v", len(stream.Snapshots), skipped)
+	}
+
+	// the segment rewritten in a larger format, as uv fsck --repair may
+	// do, with its old index left behind.
+	var b bytes.Buffer
+	enc := ultraViolet.NewJSONEncoder(&b)
+	for _, snap := range stream.Snapshots {
+		if snap.Time.Before(time.Date(2017, 1, 5, 0, 0, 0, 0, time.Local)) {
+			enc.Encode(snap)
+		}
+	}
+	if err := ioutil.WriteFile(seg.Path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if stream, err = s.Query(time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if !sameSnapshots(stream.Snapshots, snaps) {
+		t.Errorf("after a rewrite: %d snapshots", len(stream.Snapshots))
+	}
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aimof/ultra-violet"
+)
+
+// walName is the name of the write-ahead log of a Store, in Dir/data.
+const walName = "wal.json"
+
+// Writer appends Snapshots to the segments of a Store, in the segment of
+// the day each was taken on. The segment of the current day is kept open
+// between Snapshots.
+//
+// Unless the Sync of the Store is SyncNever, each Snapshot is first
+// written to the write-ahead log of the Store and synced there, and the
+// log is emptied whenever the segments are synced, as the Sync of the
+// Store says. The Snapshots of the log that didn't make it to their
+// segment, e.g. cut short by a crash, are appended to it by the next
+// Writer. Only one Writer writes to a Store at a time.
+type Writer struct {
+	store  *Store
+	format string
+	day    string
+	seg    *ultraViolet.AppendFile
+	wal    *os.File
+	synced time.Time
+}
+
+// NewWriter returns a Writer appending Snapshots to the segments of s in
+// format, which is one of ultraViolet.Formats, or "" to carry on in the
+// format of each segment, after recovering the Snapshots of the
+// write-ahead log.
+func (s *Store) NewWriter(format string) (*Writer, error) {
+	if format != "" {
+		if _, err := ultraViolet.NewEncoder(nil, format); err != nil {
+			return nil, err
+		}
+	}
+	w := &Writer{store: s, format: format}
+	if s.Sync == ultraViolet.SyncNever {
+		return w, nil
+	}
+	dir := filepath.Join(s.Dir, "data")
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(filepath.Join(dir, walName), os.O_APPEND|os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := ultraViolet.TryLockFile(wal); err != nil {
+		wal.Close()
+		if err == ultraViolet.ErrLocked {
+			err = fmt.Errorf("another uv daemon is writing to %s", s.Dir)
+		}
+		return nil, err
+	}
+	w.wal = wal
+	if err := w.recover(); err != nil {
+		wal.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// recover appends the Snapshots of the write-ahead log missing from their
+// segment, and empties the log.
+func (w *Writer) recover() error {
+	if _, err := w.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	d := ultraViolet.NewDecoder(w.wal)
+	// a line cut short never made it to a segment.
+	d.Skip = func(*ultraViolet.LineError) {}
+	written := make(map[string]map[int64]bool)
+	for {
+		snap, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		path, err := w.store.SegmentPath(snap.Time)
+		if err != nil {
+			return err
+		}
+		if written[path] == nil {
+			if written[path], err = segmentTimes(path); err != nil {
+				return err
+			}
+		}
+		if written[path][snap.Time.UnixNano()] {
+			continue
+		}
+		if err := w.write(snap); err != nil {
+			return err
+		}
+	}
+	return w.sync()
+}
+
+// segmentTimes returns the times of the Snapshots of the segment in path.
+func segmentTimes(path string) (map[int64]bool, error) {
+	times := make(map[int64]bool)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return times, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := ultraViolet.NewDecoder(f)
+	d.Skip = func(*ultraViolet.LineError) {}
+	for {
+		snap, err := d.Decode()
+		if err == io.EOF {
+			return times, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		times[snap.Time.UnixNano()] = true
+	}
+}
+
+// Write appends snap to the segment of the day snap was taken on.
+func (w *Writer) Write(snap *ultraViolet.Snapshot) error {
+	if w.wal != nil {
+		if err := ultraViolet.NewJSONEncoder(w.wal).Encode(snap); err != nil {
+			return err
+		}
+		if err := w.wal.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := w.write(snap); err != nil {
+		return err
+	}
+	if w.store.Sync.Due(w.synced) {
+		return w.sync()
+	}
+	return nil
+}
+
+// write appends snap to its segment.
+func (w *Writer) write(snap *ultraViolet.Snapshot) error {
+	day := snap.Time.Format("2006-01-02")
+	if w.seg == nil || w.day != day {
+		if err := w.closeSegment(); err != nil {
+			return err
+		}
+		path, err := w.store.SegmentPath(snap.Time)
+		if err != nil {
+			return err
+		}
+		seg, err := ultraViolet.OpenAppendFile(path, w.format)
+		if err != nil {
+			return err
+		}
+		// the Writer syncs it itself, before emptying the log.
+		seg.Fsync = ultraViolet.SyncNever
+		seg.KeyframeInterval = w.store.KeyframeInterval
+		w.seg, w.day = seg, day
+	}
+	return w.seg.Append(snap)
+}
+
+// sync syncs the open segment and empties the write-ahead log, whose
+// Snapshots are all in synced segments then.
+func (w *Writer) sync() error {
+	if w.seg != nil && w.store.Sync != ultraViolet.SyncNever {
+		if err := w.seg.Sync(); err != nil {
+			return err
+		}
+	}
+	w.synced = time.Now()
+	if w.wal == nil {
+		return nil
+	}
+	if err := w.wal.Truncate(0); err != nil {
+		return err
+	}
+	return w.wal.Sync()
+}
+
+// closeSegment syncs and closes the open segment, if any.
+func (w *Writer) closeSegment() error {
+	if w.seg == nil {
+		return nil
+	}
+	err := w.sync()
+	if cerr := w.seg.Close(); err == nil {
+		err = cerr
+	}
+	w.seg = nil
+	return err
+}
+
+// Close syncs and closes the open segment, if any, and the write-ahead
+// log. Unless the Sync of the Store is SyncNever, nothing is left to
+// recover from the log then.
+func (w *Writer) Close() error {
+	err := w.closeSegment()
+	if w.wal != nil {
+		if cerr := w.wal.Close(); err == nil {
+			err = cerr
+		}
+		w.wal = nil
+	}
+	return err
+}
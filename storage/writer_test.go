@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aimof/ultra-violet"
+)
+
+func TestWriterRecover(t *testing.T) {
+	s, snaps := testStore(t, ultraViolet.FormatDelta)
+	defer os.RemoveAll(s.Dir)
+	s.Sync = ultraViolet.SyncPolicy(time.Hour)
+	if b, err := ioutil.ReadFile(filepath.Join(s.Dir, "data", walName)); err != nil || len(b) != 0 {
+		t.Fatalf("log after Close: %q %v", b, err)
+	}
+
+	w, err := s.NewWriter(ultraViolet.FormatDelta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewWriter(ultraViolet.FormatDelta); err == nil {
+		t.Error("two Writers")
+	}
+	next := time.Date(2017, 1, 6, 23, 0, 0, 0, time.Local)
+	var late []*ultraViolet.Snapshot
+	for i := 0; i < 3; i++ {
+		snap := &ultraViolet.Snapshot{Time: next.Add(time.Duration(i) * time.Minute), Windows: []*ultraViolet.Window{{ID: 1, Name: "late"}}, Active: 1}
+		if err := w.Write(snap); err != nil {
+			t.Fatal(err)
+		}
+		late = append(late, snap)
+	}
+
+	// a crash cuts the last line short before the segment was synced.
+	path, err := s.SegmentPath(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, fi.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+	w.seg.Close()
+	w.wal.Close()
+
+	if w, err = s.NewWriter(ultraViolet.FormatDelta); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	c, err := s.Cursor(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	skipped := 0
+	c.Skip = func(*ultraViolet.LineError) { skipped++ }
+	stream, err := ultraViolet.Collect(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(snaps, late...); !sameSnapshots(stream.Snapshots, want) || skipped != 1 {
+		t.Errorf("%d snapshots of %d, %d skipped", len(stream.Snapshots), len(want), skipped)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(s.Dir, "data", walName)); err != nil || len(b) != 0 {
+		t.Errorf("log after recovery: %q %v", b, err)
+	}
+}
@@ -0,0 +1,368 @@
+package ultraViolet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+func init() {
+	RegisterTracker("synthetic", NewSyntheticTracker)
+}
+
+// SyntheticApp is an application of the workdays made up by the
+// SyntheticTracker.
+type SyntheticApp struct {
+	// Name is the name of the application, which ends the titles of
+	// its window, e.g. "Mozilla Firefox".
+	Name  string
+	Class string
+	// Titles are the titles the window shows in turn.
+	Titles []string
+	// Weight is how often the application gets the focus compared to
+	// the others.
+	Weight float64
+}
+
+// SyntheticConfig describes the workdays made up by the SyntheticTracker.
+// In JSON, times of day are written "09:00" and durations as in "30s".
+type SyntheticConfig struct {
+	// Start is the first day; Days workdays are made up from it on,
+	// skipping weekends.
+	Start time.Time
+	Days  int
+	// DayStart and DayEnd are the times of day work starts and ends.
+	DayStart, DayEnd time.Duration
+	// Lunch is the time of day the screen is locked for LunchLength.
+	Lunch, LunchLength time.Duration
+	// Interval is the time between two Snapshots.
+	Interval time.Duration
+	// Focus is how long an application keeps the focus on average.
+	Focus time.Duration
+	// Idle is the probability that the user is away from the keyboard
+	// instead of switching to another application.
+	Idle float64
+	Apps []SyntheticApp
+}
+
+// syntheticConfigJSON is how a SyntheticConfig is written in JSON.
+type syntheticConfigJSON struct {
+	Start       string
+	Days        int
+	DayStart    string
+	DayEnd      string
+	Lunch       string
+	LunchLength string
+	Interval    string
+	Focus       string
+	// Idle is a pointer so that 0 (no idle periods) can be told from a
+	// left out Idle.
+	Idle *float64
+	Apps []SyntheticApp
+}
+
+// UnmarshalJSON reads a SyntheticConfig. Fields left out keep their
+// value, so that a config can be read over DefaultSyntheticConfig().
+func (c *SyntheticConfig) UnmarshalJSON(b []byte) error {
+	var j syntheticConfigJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	if j.Start != "" {
+		start, err := time.ParseInLocation("2006-01-02", j.Start, time.Local)
+		if err != nil {
+			return err
+		}
+		c.Start = start
+	}
+	for _, clock := range []struct {
+		s string
+		d *time.Duration
+	}{{j.DayStart, &c.DayStart}, {j.DayEnd, &c.DayEnd}, {j.Lunch, &c.Lunch}} {
+		if clock.s == "" {
+			continue
+		}
+		t, err := time.Parse("15:04", clock.s)
+		if err != nil {
+			return err
+		}
+		*clock.d = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	for _, duration := range []struct {
+		s string
+		d *time.Duration
+	}{{j.LunchLength, &c.LunchLength}, {j.Interval, &c.Interval}, {j.Focus, &c.Focus}} {
+		if duration.s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(duration.s)
+		if err != nil {
+			return err
+		}
+		*duration.d = d
+	}
+	if j.Days != 0 {
+		c.Days = j.Days
+	}
+	if j.Idle != nil {
+		c.Idle = *j.Idle
+	}
+	if j.Apps != nil {
+		c.Apps = j.Apps
+	}
+	return nil
+}
+
+// DefaultSyntheticConfig returns the config of the SyntheticTracker when
+// none is given: the five workdays of last week, from 9:00 to 18:00, in
+// a terminal, a browser, an editor and a chat.
+func DefaultSyntheticConfig() SyntheticConfig {
+	today := time.Now()
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local)
+	monday := today.AddDate(0, 0, -7-(int(today.Weekday())+6)%7)
+	return SyntheticConfig{
+		Start:       monday,
+		Days:        5,
+		DayStart:    9 * time.Hour,
+		DayEnd:      18 * time.Hour,
+		Lunch:       12*time.Hour + 30*time.Minute,
+		LunchLength: 45 * time.Minute,
+		Interval:    30 * time.Second,
+		Focus:       8 * time.Minute,
+		Idle:        0.1,
+		Apps: []SyntheticApp{
+			{Name: "Terminal", Class: "xterm", Titles: []string{"vim show.go", "go test ./...", "git log", "ssh build"}, Weight: 4},
+			{Name: "Mozilla Firefox", Class: "firefox", Titles: []string{"aimof/ultra-violet", "Pull requests", "Stack Overflow", "The Go Programming Language"}, Weight: 3},
+			{Name: "Visual Studio Code", Class: "code", Titles: []string{"data.go - ultra-violet", "show.go - ultra-violet", "README.md - ultra-violet"}, Weight: 2},
+			{Name: "Slack", Class: "slack", Titles: []string{"#general", "#dev", "Direct messages"}, Weight: 1},
+		},
+	}
+}
+
+// validate checks that c can make up workdays.
+func (c *SyntheticConfig) validate() error {
+	switch {
+	case c.Days <= 0:
+		return errors.New("synthetic: no days")
+	case c.Interval <= 0:
+		return errors.New("synthetic: the interval must be positive")
+	case c.DayEnd <= c.DayStart:
+		return errors.New("synthetic: the day ends before it starts")
+	case len(c.Apps) == 0:
+		return errors.New("synthetic: no applications")
+	}
+	for _, app := range c.Apps {
+		if app.Name == "" || len(app.Titles) == 0 || app.Weight < 0 {
+			return fmt.Errorf("synthetic: application %q needs a name, titles and a weight", app.Name)
+		}
+	}
+	return nil
+}
+
+// SyntheticTracker makes up realistic workdays, one Snapshot per call to
+// Snap, so that reports can be produced without a display: the focus
+// moves between the applications of the Config at random, the user is
+// away now and then, and the screen is locked at lunch. The same Seed
+// makes up the same workdays.
+type SyntheticTracker struct {
+	Config SyntheticConfig
+	Seed   int64
+	// Speed is how many times faster than real time Snapshots are
+	// returned, as for the ReplayTracker.
+	Speed float64
+
+	gen  *syntheticWorkdays
+	pace pacer
+	err  error
+}
+
+var _ Tracker = (*SyntheticTracker)(nil)
+var _ ContextTracker = (*SyntheticTracker)(nil)
+var _ Watcher = (*SyntheticTracker)(nil)
+
+// NewSyntheticTracker returns a SyntheticTracker set up from the
+// environment: $UV_SYNTHETIC is a JSON file overriding fields of
+// DefaultSyntheticConfig(), $UV_SYNTHETIC_SEED the seed (1 by default),
+// and $UV_REPLAY_SPEED the speed.
+func NewSyntheticTracker() Tracker {
+	t := &SyntheticTracker{Config: DefaultSyntheticConfig(), Seed: 1}
+	if path := os.Getenv("UV_SYNTHETIC"); path != "" {
+		if t.err = readSyntheticConfig(path, &t.Config); t.err != nil {
+			return t
+		}
+	}
+	if seed := os.Getenv("UV_SYNTHETIC_SEED"); seed != "" {
+		if t.Seed, t.err = strconv.ParseInt(seed, 10, 64); t.err != nil {
+			return t
+		}
+	}
+	t.Speed, t.err = playbackSpeed()
+	return t
+}
+
+func readSyntheticConfig(path string, c *SyntheticConfig) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(c); err != nil {
+		return fmt.Errorf("synthetic: invalid config %s: %s", path, err)
+	}
+	return nil
+}
+
+func (t *SyntheticTracker) Deps() string {
+	return ""
+}
+
+func (t *SyntheticTracker) Snap() (*Snapshot, error) {
+	return t.SnapContext(context.Background())
+}
+
+// SnapContext returns the next Snapshot of the workdays once it is due.
+func (t *SyntheticTracker) SnapContext(ctx context.Context) (*Snapshot, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	if t.gen == nil {
+		if t.err = t.Config.validate(); t.err != nil {
+			return nil, t.err
+		}
+		t.gen = newSyntheticWorkdays(&t.Config, t.Seed)
+	}
+	snap := t.gen.peek()
+	if snap == nil {
+		return nil, ErrPlaybackDone
+	}
+	if err := t.pace.wait(ctx, snap.Time, t.Speed); err != nil {
+		return nil, err
+	}
+	t.gen.pop()
+	return snap, nil
+}
+
+// Watch sends the Snapshots of the workdays as they become due, and
+// returns ErrPlaybackDone after the last one. Snapshots are made up
+// every Config.Interval, so heartbeat is ignored.
+func (t *SyntheticTracker) Watch(snaps chan<- *Snapshot, heartbeat time.Duration, stop <-chan struct{}) error {
+	return watchPlayback(t, snaps, stop)
+}
+
+// syntheticWorkdays makes up the Snapshots of SyntheticTracker.
+type syntheticWorkdays struct {
+	c   *SyntheticConfig
+	rnd *rand.Rand
+
+	day     int
+	date    time.Time
+	t       time.Time
+	locked  bool
+	active  int
+	idle    time.Time
+	until   time.Time
+	titles  []string
+	pending *Snapshot
+}
+
+func newSyntheticWorkdays(c *SyntheticConfig, seed int64) *syntheticWorkdays {
+	g := &syntheticWorkdays{c: c, rnd: rand.New(rand.NewSource(seed)), titles: make([]string, len(c.Apps))}
+	for i, app := range c.Apps {
+		g.titles[i] = app.Titles[0]
+	}
+	g.date = time.Date(c.Start.Year(), c.Start.Month(), c.Start.Day(), 0, 0, 0, 0, c.Start.Location())
+	g.skipWeekend()
+	g.t = g.date.Add(c.DayStart)
+	return g
+}
+
+// skipWeekend moves g.date to the next Monday if it is on a weekend.
+func (g *syntheticWorkdays) skipWeekend() {
+	for g.date.Weekday() == time.Saturday || g.date.Weekday() == time.Sunday {
+		g.date = g.date.AddDate(0, 0, 1)
+	}
+}
+
+// peek returns the next Snapshot, or nil after the last workday.
+func (g *syntheticWorkdays) peek() *Snapshot {
+	if g.pending == nil {
+		g.pending = g.make()
+	}
+	return g.pending
+}
+
+// pop forgets the Snapshot returned by peek.
+func (g *syntheticWorkdays) pop() {
+	g.pending = nil
+}
+
+// make makes up the Snapshot at g.t and moves on to the next one.
+func (g *syntheticWorkdays) make() *Snapshot {
+	if g.t.Sub(g.date) >= g.c.DayEnd {
+		g.day++
+		g.date = g.date.AddDate(0, 0, 1)
+		g.skipWeekend()
+		g.t = g.date.Add(g.c.DayStart)
+		g.until = time.Time{}
+	}
+	if g.day >= g.c.Days {
+		return nil
+	}
+
+	lunch := g.date.Add(g.c.Lunch)
+	if g.c.LunchLength > 0 && !g.t.Before(lunch) && g.t.Before(lunch.Add(g.c.LunchLength)) {
+		if g.locked {
+			// unlocked after lunch.
+			g.locked = false
+			g.t = lunch.Add(g.c.LunchLength)
+			g.until = time.Time{}
+			return &Snapshot{Time: g.t, Event: EventUnlock}
+		}
+		g.locked = true
+		return &Snapshot{Time: g.t, Event: EventLock}
+	}
+
+	if !g.t.Before(g.until) {
+		g.switchFocus()
+	}
+	snap := &Snapshot{Time: g.t, Active: g.active + 1, Visible: []int{g.active + 1}}
+	for i, app := range g.c.Apps {
+		snap.Windows = append(snap.Windows, &Window{ID: i + 1, Name: g.titles[i] + defaultWindowTitleSeparator + app.Name, Class: app.Class})
+	}
+	if !g.idle.IsZero() {
+		snap.Idle = true
+		snap.IdleTime = g.t.Sub(g.idle)
+	}
+	g.t = g.t.Add(g.c.Interval)
+	return snap
+}
+
+// switchFocus decides what the user does until g.until: stay away from
+// the keyboard, or work in an application picked by weight.
+func (g *syntheticWorkdays) switchFocus() {
+	g.until = g.t.Add(time.Duration((0.2 + g.rnd.ExpFloat64()) * float64(g.c.Focus)))
+	if g.idle.IsZero() && g.rnd.Float64() < g.c.Idle {
+		g.idle = g.t
+		return
+	}
+	g.idle = time.Time{}
+
+	var total float64
+	for _, app := range g.c.Apps {
+		total += app.Weight
+	}
+	pick := g.rnd.Float64() * total
+	for i, app := range g.c.Apps {
+		if pick -= app.Weight; pick < 0 || i == len(g.c.Apps)-1 {
+			g.active = i
+			break
+		}
+	}
+	titles := g.c.Apps[g.active].Titles
+	g.titles[g.active] = titles[g.rnd.Intn(len(titles))]
+}
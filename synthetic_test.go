@@ -0,0 +1,125 @@
+package ultraViolet
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syntheticTestConfig makes up a short Friday and Monday.
+func syntheticTestConfig() SyntheticConfig {
+	c := DefaultSyntheticConfig()
+	c.Start = time.Date(2017, 1, 6, 0, 0, 0, 0, time.UTC)
+	c.Days = 2
+	c.DayStart, c.DayEnd = 9*time.Hour, 11*time.Hour
+	c.Lunch, c.LunchLength = 10*time.Hour, 15*time.Minute
+	c.Interval = time.Minute
+	c.Focus = 5 * time.Minute
+	return c
+}
+
+// playAll returns the Snapshots of t until ErrPlaybackDone.
+func playAll(t *testing.T, tracker Tracker) []*Snapshot {
+	var snaps []*Snapshot
+	for {
+		snap, err := tracker.Snap()
+		if err == ErrPlaybackDone {
+			return snaps
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		snaps = append(snaps, snap)
+	}
+}
+
+func TestSyntheticTracker(t *testing.T) {
+	snaps := playAll(t, &SyntheticTracker{Config: syntheticTestConfig(), Seed: 42})
+
+	days := make(map[time.Weekday]int)
+	var events []string
+	apps := make(map[string]bool)
+	idle := 0
+	for i, snap := range snaps {
+		if i > 0 && snap.Time.Before(snaps[i-1].Time) {
+			t.Errorf("snap%d: %s after %s", i, snap.Time, snaps[i-1].Time)
+		}
+		if snap.Time.Hour() < 9 || snap.Time.Hour() >= 11 {
+			t.Errorf("snap%d: outside work hours: %s", i, snap.Time)
+		}
+		days[snap.Time.Weekday()]++
+		if snap.IsMarker() {
+			events = append(events, snap.Time.Format("Mon 15:04 ")+snap.Event)
+			continue
+		}
+		if lunch := snap.Time.Hour() == 10 && snap.Time.Minute() < 15; lunch {
+			t.Errorf("snap%d: at lunch: %s", i, snap.Time)
+		}
+		if snap.Idle {
+			idle++
+		} else {
+			for _, w := range snap.Windows {
+				if w.ID == snap.Active {
+					apps[w.Info().App] = true
+				}
+			}
+		}
+	}
+	// 2 hours every minute, but for the quarter of an hour of lunch.
+	if expected := map[time.Weekday]int{time.Friday: 107, time.Monday: 107}; !reflect.DeepEqual(days, expected) {
+		t.Errorf("days: %v", days)
+	}
+	if expected := []string{"Fri 10:00 lock", "Fri 10:15 unlock", "Mon 10:00 lock", "Mon 10:15 unlock"}; !reflect.DeepEqual(events, expected) {
+		t.Errorf("events: %v", events)
+	}
+	if len(apps) < 3 || idle == 0 {
+		t.Errorf("apps: %v, idle: %d", apps, idle)
+	}
+
+	again := playAll(t, &SyntheticTracker{Config: syntheticTestConfig(), Seed: 42})
+	if !reflect.DeepEqual(again, snaps) {
+		t.Error("the same seed made up other workdays")
+	}
+
+	var b bytes.Buffer
+	if err := Stats(&Stream{Snapshots: snaps}, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "Mozilla Firefox") {
+		t.Error("report without the synthetic applications")
+	}
+}
+
+func TestSyntheticConfigJSON(t *testing.T) {
+	c := DefaultSyntheticConfig()
+	in := `{"Start": "2017-01-02", "Days": 1, "DayStart": "08:30", "LunchLength": "1h", "Apps": [{"Name": "Emacs", "Titles": ["*scratch*"], "Weight": 1}]}`
+	if err := json.Unmarshal([]byte(in), &c); err != nil {
+		t.Fatal(err)
+	}
+	expected := DefaultSyntheticConfig()
+	expected.Start = time.Date(2017, 1, 2, 0, 0, 0, 0, time.Local)
+	expected.Days = 1
+	expected.DayStart = 8*time.Hour + 30*time.Minute
+	expected.LunchLength = time.Hour
+	expected.Apps = []SyntheticApp{{Name: "Emacs", Titles: []string{"*scratch*"}, Weight: 1}}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("config: %+v", c)
+	}
+	if err := json.Unmarshal([]byte(`{"Idle": 0}`), &c); err != nil || c.Idle != 0 {
+		t.Errorf("no idle periods: %v %v", c.Idle, err)
+	}
+
+	for i, in := range []string{`{"DayEnd": "6pm"}`, `{"Interval": "often"}`, `{"Start": "monday"}`} {
+		if err := json.Unmarshal([]byte(in), &c); err == nil {
+			t.Errorf("case%d: no error", i)
+		}
+	}
+	c = syntheticTestConfig()
+	c.DayEnd = c.DayStart
+	if _, err := (&SyntheticTracker{Config: c}).Snap(); err == nil {
+		t.Error("day ending before it starts")
+	}
+}
@@ -0,0 +1,257 @@
+package ultraViolet
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// terminalEmulators are the executables and window classes (in lower
+// case) of the terminal emulators the TerminalCollector knows.
+var terminalEmulators = map[string]bool{
+	"alacritty":             true,
+	"eterm":                 true,
+	"foot":                  true,
+	"footclient":            true,
+	"gnome-terminal":        true,
+	"gnome-terminal-server": true,
+	"guake":                 true,
+	"kitty":                 true,
+	"konsole":               true,
+	"lxterminal":            true,
+	"mate-terminal":         true,
+	"qterminal":             true,
+	"rxvt":                  true,
+	"sakura":                true,
+	"st":                    true,
+	"st-256color":           true,
+	"terminator":            true,
+	"terminology":           true,
+	"tilix":                 true,
+	"urxvt":                 true,
+	"wezterm-gui":           true,
+	"xfce4-terminal":        true,
+	"xterm":                 true,
+	"yakuake":               true,
+}
+
+// TerminalCollector fills in the Foreground of terminal emulator windows
+// whose PID is known: the command in the foreground of the terminal's
+// pty, found by walking /proc from the terminal process. When that
+// command is a tmux or screen client, the command of the active pane
+// (or the title of the active screen window) is asked from tmux or
+// screen instead.
+//
+// Terminal emulators that serve several windows from one process (such
+// as gnome-terminal-server) don't tell which pty belongs to which
+// window; the collector then picks the most recently started foreground
+// command of all their ptys.
+type TerminalCollector struct{}
+
+var (
+	_ Collector        = (*TerminalCollector)(nil)
+	_ ContextCollector = (*TerminalCollector)(nil)
+)
+
+func (c *TerminalCollector) Collect(snap *Snapshot) error {
+	return c.CollectContext(context.Background(), snap)
+}
+
+func (c *TerminalCollector) CollectContext(ctx context.Context, snap *Snapshot) error {
+	var procs map[int]*procStat
+	for _, w := range snap.Windows {
+		if w.PID <= 0 {
+			continue
+		}
+		if procs == nil {
+			procs = readProcStats()
+		}
+		if !isTerminal(w, procs[w.PID]) {
+			continue
+		}
+		if fg := terminalForeground(w.PID, procs); fg != nil {
+			w.Foreground = fg.foreground(ctx)
+		}
+	}
+	return ctx.Err()
+}
+
+// isTerminal reports whether w, whose process is p, is the window of a
+// terminal emulator.
+func isTerminal(w *Window, p *procStat) bool {
+	for _, name := range []string{w.Class, w.Instance, filepath.Base(w.Exe)} {
+		if terminalEmulators[strings.ToLower(name)] {
+			return true
+		}
+	}
+	return p != nil && terminalEmulators[strings.ToLower(p.name())]
+}
+
+// procStat holds the fields of /proc/PID/stat the TerminalCollector uses.
+type procStat struct {
+	pid, ppid int
+	comm      string
+	// tty is the device number of the controlling terminal (0 for
+	// none) and tpgid the foreground process group of that terminal.
+	tty, tpgid int
+	// start is the time the process started, in clock ticks after boot.
+	start uint64
+
+	args []string
+}
+
+// readProcStats returns the processes of /proc by PID.
+func readProcStats() map[int]*procStat {
+	procs := make(map[int]*procStat)
+	dir, err := os.Open(procDir)
+	if err != nil {
+		return procs
+	}
+	names, _ := dir.Readdirnames(-1)
+	dir.Close()
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(procDir, name, "stat"))
+		if err != nil {
+			continue
+		}
+		if p := parseProcStat(pid, string(b)); p != nil {
+			procs[pid] = p
+		}
+	}
+	return procs
+}
+
+// parseProcStat parses the content of /proc/PID/stat, returning nil if it
+// is malformed.
+func parseProcStat(pid int, stat string) *procStat {
+	// the command name is in parentheses and may contain anything,
+	// including spaces and parentheses.
+	open, end := strings.IndexByte(stat, '('), strings.LastIndexByte(stat, ')')
+	if open == -1 || end < open {
+		return nil
+	}
+	fields := strings.Fields(stat[end+1:])
+	// fields[0] is the state, the 3rd field of the file.
+	if len(fields) < 20 {
+		return nil
+	}
+	p := &procStat{pid: pid, comm: stat[open+1 : end]}
+	var err error
+	if p.ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return nil
+	}
+	if p.tty, err = strconv.Atoi(fields[4]); err != nil {
+		return nil
+	}
+	if p.tpgid, err = strconv.Atoi(fields[5]); err != nil {
+		return nil
+	}
+	if p.start, err = strconv.ParseUint(fields[19], 10, 64); err != nil {
+		return nil
+	}
+	return p
+}
+
+// name returns the name of the command p runs: the base name of its
+// first argument, or else the command name of the kernel (which is cut
+// to 15 bytes).
+func (p *procStat) name() string {
+	if p.args == nil {
+		p.args = readProcess(p.pid).Args
+	}
+	if len(p.args) > 0 && p.args[0] != "" {
+		// login shells are started as e.g. "-bash".
+		return strings.TrimPrefix(filepath.Base(p.args[0]), "-")
+	}
+	return p.comm
+}
+
+// terminalForeground returns the foreground process of the ptys the
+// terminal emulator process pid is attached to through its children.
+func terminalForeground(pid int, procs map[int]*procStat) *procStat {
+	var fg *procStat
+	for _, child := range procs {
+		if child.ppid != pid || child.tty == 0 || child.tpgid <= 0 {
+			continue
+		}
+		// the foreground process group is named after its leader.
+		p := procs[child.tpgid]
+		if p == nil {
+			continue
+		}
+		if fg == nil || p.start > fg.start || p.start == fg.start && p.pid > fg.pid {
+			fg = p
+		}
+	}
+	return fg
+}
+
+// foreground returns the command p runs, or for tmux and screen clients
+// the command run in their active pane or window, which tmux and screen
+// are given until ctx is done or DefaultCommandTimeout to tell.
+func (p *procStat) foreground(ctx context.Context) string {
+	name := p.name()
+	var inner string
+	switch name {
+	case "tmux":
+		inner = tmuxForeground(ctx, p)
+	case "screen":
+		inner = screenForeground(ctx, p)
+	}
+	if inner != "" {
+		return inner
+	}
+	return name
+}
+
+// tmuxForeground asks the tmux server the client p is attached to for
+// the command of the client's active pane.
+func tmuxForeground(ctx context.Context, p *procStat) string {
+	args := socketArgs(p.args, map[string]string{"-L": "-L", "-S": "-S"})
+	// the client is told apart from others by its terminal.
+	if tty, err := os.Readlink(filepath.Join(procDir, strconv.Itoa(p.pid), "fd", "0")); err == nil && strings.HasPrefix(tty, "/dev/") {
+		args = append(args, "display-message", "-p", "-c", tty)
+	} else {
+		args = append(args, "display-message", "-p")
+	}
+	out, err := runCommand(ctx, 0, nil, nil, "tmux", append(args, "#{pane_current_command}")...)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// screenForeground asks screen for the title of the active window of the
+// session the client p is attached to, which shells and most programs
+// set to their command.
+func screenForeground(ctx context.Context, p *procStat) string {
+	// clients attach with -r, -x or -R; queries name the session with -S.
+	args := socketArgs(p.args, map[string]string{"-r": "-S", "-x": "-S", "-R": "-S", "-S": "-S"})
+	out, err := runCommand(ctx, 0, nil, nil, "screen", append(args, "-Q", "title")...)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// socketArgs returns the options of the command line args of a tmux or
+// screen client that select its server or session, such as "-L work",
+// renamed after flags, which maps the client's options to those of the
+// query.
+func socketArgs(args []string, flags map[string]string) []string {
+	var selected []string
+	for i := 1; i < len(args)-1; i++ {
+		if flag, ok := flags[args[i]]; ok && !strings.HasPrefix(args[i+1], "-") {
+			selected = append(selected, flag, args[i+1])
+			i++
+		}
+	}
+	return selected
+}
@@ -0,0 +1,144 @@
+package ultraViolet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTerminalCollector(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "uv-terminal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { procDir = d }(procDir)
+	procDir = filepath.Join(dir, "proc")
+
+	const tty = 34816
+	for _, p := range []struct {
+		pid, ppid, tty, tpgid int
+		start                 uint64
+		cmdline               string
+	}{
+		// vim in xterm.
+		{100, 1, 0, -1, 1, "xterm"},
+		{101, 100, tty, 110, 2, "bash"},
+		{110, 101, tty, 110, 3, "vim\x00data.go"},
+		// a build in the second tab of a gnome-terminal.
+		{200, 1, 0, -1, 1, "/usr/libexec/gnome-terminal-server"},
+		{201, 200, tty + 1, 201, 2, "bash"},
+		{202, 200, tty + 2, 210, 3, "-bash"},
+		{210, 202, tty + 2, 210, 4, "make\x00-j8"},
+		// tmux in kitty.
+		{300, 1, 0, -1, 1, "kitty"},
+		{301, 300, tty + 3, 310, 2, "zsh"},
+		{310, 301, tty + 3, 310, 3, "tmux\x00-L\x00work\x00attach"},
+		// screen in foot, which is only known by its executable.
+		{400, 1, 0, -1, 1, "foot"},
+		{401, 400, tty + 4, 410, 2, "bash"},
+		{410, 401, tty + 4, 410, 3, "screen\x00-d\x00-r\x00dev"},
+		// a shell at its prompt.
+		{500, 1, 0, -1, 1, "alacritty"},
+		{501, 500, tty + 5, 501, 2, "fish"},
+		// not a terminal.
+		{600, 1, 0, -1, 1, "firefox"},
+		{601, 600, tty + 6, 601, 2, "bash"},
+	} {
+		writeProc(t, p.pid, p.ppid, p.tty, p.tpgid, p.start, p.cmdline)
+	}
+
+	defer fakeCommands(t, map[string]string{
+		"tmux":   `[ "$*" = "-L work display-message -p #{pane_current_command}" ] && echo nvim`,
+		"screen": `[ "$*" = "-S dev -Q title" ] && echo htop`,
+	})()
+
+	snap := &Snapshot{Windows: []*Window{
+		&Window{ID: 1, PID: 100, Class: "XTerm"},
+		&Window{ID: 2, PID: 200, Class: "Gnome-terminal"},
+		&Window{ID: 3, PID: 300, Class: "kitty"},
+		&Window{ID: 4, PID: 400},
+		&Window{ID: 5, PID: 500, Class: "Alacritty"},
+		&Window{ID: 6, PID: 600, Class: "firefox"},
+		&Window{ID: 7, PID: 700, Class: "XTerm"},
+		&Window{ID: 8, Class: "XTerm"},
+	}}
+	if err := (&TerminalCollector{}).Collect(snap); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"vim", "make", "nvim", "htop", "fish", "", "", ""}
+	var actual []string
+	for _, w := range snap.Windows {
+		actual = append(actual, w.Foreground)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("foreground: %q", actual)
+	}
+}
+
+// fakeCommands puts shell scripts first in $PATH until the returned
+// function is called.
+func fakeCommands(t *testing.T, scripts map[string]string) func() {
+	dir, err := ioutil.TempDir("", "uv-bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	restore := setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return func() {
+		restore()
+		os.RemoveAll(dir)
+	}
+}
+
+// writeProc writes the stat and cmdline files of a process below procDir.
+func writeProc(t *testing.T, pid, ppid, tty, tpgid int, start uint64, cmdline string) {
+	dir := filepath.Join(procDir, strconv.Itoa(pid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	comm := strings.SplitN(filepath.Base(cmdline), "\x00", 2)[0]
+	stat := fmt.Sprintf("%d (%s) S %d %d %d %d %d 4194304 0 0 0 0 0 0 0 0 20 0 1 0 %d 0 0\n", pid, comm, ppid, pid, pid, tty, tpgid, start)
+	if err := ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cmdline"), []byte(cmdline+"\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseProcStat(t *testing.T) {
+	cases := []struct {
+		stat     string
+		expected *procStat
+	}{
+		{
+			"42 (vim) S 41 42 41 34816 42 4194304 0 0 0 0 0 0 0 0 20 0 1 0 12345 0 0",
+			&procStat{pid: 42, ppid: 41, comm: "vim", tty: 34816, tpgid: 42, start: 12345},
+		},
+		{
+			"42 (a) (b c) S 1 42 42 0 -1 4194304 0 0 0 0 0 0 0 0 20 0 1 0 7 0 0",
+			&procStat{pid: 42, ppid: 1, comm: "a) (b c", tty: 0, tpgid: -1, start: 7},
+		},
+		{"42 (vim) S 41 42", nil},
+		{"42 vim S 41 42 41 34816 42 4194304 0 0 0 0 0 0 0 0 20 0 1 0 12345 0 0", nil},
+	}
+	for i, c := range cases {
+		if actual := parseProcStat(42, c.stat); !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("case%d: %+v", i, actual)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package ultraViolet
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// TmuxPane is the active pane of a tmux client.
+type TmuxPane struct {
+	// Session is the name of the client's session.
+	Session string
+	// Window is the name of the session's current window and
+	// WindowIndex its number.
+	Window      string
+	WindowIndex int
+	// Command is the command running in the window's active pane.
+	Command string
+}
+
+// TmuxCollector records the session, window and pane command of the tmux
+// client shown in the focused window in its Tmux field. The client is
+// the one running below the process of the window, so the window's PID
+// must be known and local. Snapshots are left alone when no tmux server
+// is running.
+type TmuxCollector struct {
+	// Socket is the path of the socket of the tmux server. If empty, the
+	// default server is asked.
+	Socket string
+}
+
+var (
+	_ Collector        = (*TmuxCollector)(nil)
+	_ ContextCollector = (*TmuxCollector)(nil)
+)
+
+func (c *TmuxCollector) Collect(snap *Snapshot) error {
+	return c.CollectContext(context.Background(), snap)
+}
+
+func (c *TmuxCollector) CollectContext(ctx context.Context, snap *Snapshot) error {
+	var active *Window
+	for _, w := range snap.Windows {
+		if w.ID == snap.Active {
+			active = w
+		}
+	}
+	if active == nil || active.PID <= 0 {
+		return nil
+	}
+
+	out, err := c.tmux(ctx, "list-clients", "-F", "#{client_pid}\t#{client_tty}")
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			// no server is running.
+			return nil
+		}
+		return err
+	}
+	procs := readProcStats()
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil || !isDescendant(pid, active.PID, procs) {
+			continue
+		}
+		out, err := c.tmux(ctx, "display-message", "-p", "-c", fields[1], "#{session_name}\t#{window_index}\t#{window_name}\t#{pane_current_command}")
+		if err != nil {
+			return err
+		}
+		pane, err := parseTmuxPane(out)
+		if err != nil {
+			return err
+		}
+		active.Tmux = pane
+		return nil
+	}
+	return nil
+}
+
+// tmux runs a tmux command and returns its output. tmux is killed when
+// ctx is done or after DefaultCommandTimeout.
+func (c *TmuxCollector) tmux(ctx context.Context, args ...string) (string, error) {
+	if c.Socket != "" {
+		args = append([]string{"-S", c.Socket}, args...)
+	}
+	out, err := runCommand(ctx, 0, nil, nil, "tmux", args...)
+	return string(out), err
+}
+
+// parseTmuxPane parses the output of display-message for TmuxCollector.
+func parseTmuxPane(out string) (*TmuxPane, error) {
+	fields := strings.Split(strings.TrimRight(out, "\n"), "\t")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("tmux: invalid reply to display-message: %q", out)
+	}
+	index, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("tmux: invalid window index %q", fields[1])
+	}
+	return &TmuxPane{Session: fields[0], WindowIndex: index, Window: fields[2], Command: fields[3]}, nil
+}
+
+// isDescendant reports whether the process pid is ancestor or one of its
+// descendants.
+func isDescendant(pid, ancestor int, procs map[int]*procStat) bool {
+	// the depth is bounded in case of a loop in a changing process tree.
+	for i := 0; i < 64 && pid > 0; i++ {
+		if pid == ancestor {
+			return true
+		}
+		p := procs[pid]
+		if p == nil {
+			return false
+		}
+		pid = p.ppid
+	}
+	return false
+}
+
+// TmuxLabel is a label function for NewTimeline and NewAggTime that
+// labels windows showing tmux after their tmux session and window, e.g.
+// "tmux uv:editor", and other windows after their application.
+func TmuxLabel(w *Window) string {
+	if w != nil && w.Tmux != nil {
+		return fmt.Sprintf("tmux %s:%s", w.Tmux.Session, w.Tmux.Window)
+	}
+	return appID(w)
+}
@@ -0,0 +1,110 @@
+package ultraViolet
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTmuxCollector(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "uv-tmux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { procDir = d }(procDir)
+	procDir = dir
+
+	// a tmux client in kitty, and another one in a terminal that is
+	// not focused.
+	writeProc(t, 300, 1, 0, -1, 1, "kitty")
+	writeProc(t, 301, 300, 34816, 310, 2, "zsh")
+	writeProc(t, 310, 301, 34816, 310, 3, "tmux\x00attach")
+	writeProc(t, 400, 1, 0, -1, 1, "xterm")
+	writeProc(t, 401, 400, 34817, 410, 2, "bash")
+	writeProc(t, 410, 401, 34817, 410, 3, "tmux")
+
+	defer fakeCommands(t, map[string]string{
+		"tmux": `[ "$1" = -S ] && [ "$2" = /tmp/uv.sock ] || exit 1
+shift 2
+case "$*" in
+"list-clients -F #{client_pid}	#{client_tty}") printf '410\t/dev/pts/1\n310\t/dev/pts/0\n' ;;
+"display-message -p -c /dev/pts/0 #{session_name}	#{window_index}	#{window_name}	#{pane_current_command}") printf 'uv\t2\teditor\tnvim\n' ;;
+*) exit 1 ;;
+esac`,
+	})()
+
+	windows := []*Window{&Window{ID: 1, PID: 300, Class: "kitty"}, &Window{ID: 2, PID: 400, Class: "XTerm"}}
+	snap := &Snapshot{Windows: windows, Active: 1}
+	c := &TmuxCollector{Socket: "/tmp/uv.sock"}
+	if err := c.Collect(snap); err != nil {
+		t.Fatal(err)
+	}
+	expected := &TmuxPane{Session: "uv", Window: "editor", WindowIndex: 2, Command: "nvim"}
+	if !reflect.DeepEqual(windows[0].Tmux, expected) || windows[1].Tmux != nil {
+		t.Errorf("tmux: %+v %+v", windows[0].Tmux, windows[1].Tmux)
+	}
+	if label := TmuxLabel(windows[0]); label != "tmux uv:editor" {
+		t.Errorf("label: %s", label)
+	}
+	if label := TmuxLabel(&Window{Name: "Inbox - Mail"}); label != "Mail" {
+		t.Errorf("label: %s", label)
+	}
+
+	// a focused window without a tmux client.
+	snap = &Snapshot{Windows: []*Window{&Window{ID: 3, PID: 500}}, Active: 3}
+	if err := c.Collect(snap); err != nil || snap.Windows[0].Tmux != nil {
+		t.Errorf("no client: %v %+v", err, snap.Windows[0].Tmux)
+	}
+
+	// no server running.
+	c.Socket = filepath.Join(dir, "none")
+	snap = &Snapshot{Windows: []*Window{&Window{ID: 1, PID: 300}}, Active: 1}
+	if err := c.Collect(snap); err != nil || snap.Windows[0].Tmux != nil {
+		t.Errorf("no server: %v %+v", err, snap.Windows[0].Tmux)
+	}
+}
+
+func TestTmuxCollectorHung(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	defer fakeCommands(t, map[string]string{"tmux": "exec sleep 60"})()
+
+	snap := &Snapshot{Windows: []*Window{&Window{ID: 1, PID: os.Getpid()}}, Active: 1}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := (&TmuxCollector{}).CollectContext(ctx, snap); err != context.DeadlineExceeded {
+		t.Errorf("err: %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("returned after %s", d)
+	}
+}
+
+func TestParseTmuxPane(t *testing.T) {
+	cases := []struct {
+		out      string
+		expected *TmuxPane
+	}{
+		{"uv\t2\teditor\tnvim\n", &TmuxPane{Session: "uv", Window: "editor", WindowIndex: 2, Command: "nvim"}},
+		{"my session\t0\t\tbash", &TmuxPane{Session: "my session", Command: "bash"}},
+		{"uv\t2\teditor\n", nil},
+		{"uv\tx\teditor\tnvim\n", nil},
+	}
+	for i, c := range cases {
+		actual, err := parseTmuxPane(c.out)
+		if !reflect.DeepEqual(actual, c.expected) || (err == nil) != (c.expected != nil) {
+			t.Errorf("case%d: %+v %v", i, actual, err)
+		}
+	}
+}
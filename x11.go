@@ -0,0 +1,547 @@
+package ultraViolet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterTracker("x11", NewX11Tracker)
+}
+
+// X11Tracker tracks application usage by talking the X11 protocol
+// directly to the X server, instead of running command-line utilities.
+// It relies on the EWMH hints (_NET_CLIENT_LIST, _NET_ACTIVE_WINDOW, ...)
+// maintained by most window managers.
+type X11Tracker struct {
+	// Display is the X display to connect to. If empty, $DISPLAY is used.
+	Display string
+
+	mu   sync.Mutex
+	conn *x11Conn
+}
+
+var _ Tracker = (*X11Tracker)(nil)
+var _ DisplayTracker = (*X11Tracker)(nil)
+
+func NewX11Tracker() Tracker {
+	return &X11Tracker{}
+}
+
+// SetDisplays sets Display. The X11Tracker tracks a single display.
+func (t *X11Tracker) SetDisplays(displays []string) error {
+	if len(displays) > 1 {
+		return errors.New("x11: only one display can be tracked; use the linux tracker for several displays")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.close()
+	t.Display = ""
+	if len(displays) == 1 {
+		t.Display = displays[0]
+	}
+	return nil
+}
+
+func (t *X11Tracker) Deps() string {
+	return `
+No command-line utilities are needed, but the tracker needs:
+* a running X server reachable through $DISPLAY (and $XAUTHORITY if access control is enabled)
+* a window manager that supports the EWMH hints (_NET_CLIENT_LIST, _NET_ACTIVE_WINDOW, ...)
+
+Note: this command prints out this message regardless of whether the dependencies are already installed.
+`
+}
+
+// x11Atoms are the atoms the X11Tracker needs to resolve.
+var x11Atoms = []string{
+	"_NET_CLIENT_LIST",
+	"_NET_CLIENT_LIST_STACKING",
+	"_NET_ACTIVE_WINDOW",
+	"_NET_CURRENT_DESKTOP",
+	"_NET_WM_DESKTOP",
+	"_NET_WM_NAME",
+	"_NET_WM_PID",
+	"UTF8_STRING",
+}
+
+// x11BatchSize is the number of windows whose details are requested
+// before their replies are collected. The replies to the nine requests
+// per window must fit the reply buffer of the connection.
+const x11BatchSize = 256
+
+func (t *X11Tracker) Snap() (*Snapshot, error) {
+	return t.SnapContext(context.Background())
+}
+
+var _ ContextTracker = (*X11Tracker)(nil)
+
+// SnapContext is Snap that closes the connection to give up on a hung X
+// server when ctx is done.
+func (t *X11Tracker) SnapContext(ctx context.Context) (*Snapshot, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+	stop := make(chan struct{})
+	closed := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// fails the requests waiting for replies.
+			c.Close()
+			closed <- true
+		case <-stop:
+			closed <- false
+		}
+	}()
+	snap, err := x11Snap(c)
+	close(stop)
+	if <-closed || err != nil {
+		// the connection may be broken, so start afresh next time.
+		t.close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return snap, nil
+}
+
+// Close closes the connection to the X server, if any.
+func (t *X11Tracker) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.close()
+}
+
+var _ Watcher = (*X11Tracker)(nil)
+
+// Watch subscribes to PropertyNotify events on the root window and on
+// every client window, and sends a Snapshot whenever the focus, the
+// current desktop, the window list or a window title changes. Snap
+// calls block while Watch is running.
+func (t *X11Tracker) Watch(snaps chan<- *Snapshot, heartbeat time.Duration, stop <-chan struct{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, err := t.connect()
+	if err != nil {
+		return err
+	}
+	// the event selections belong to the connection, so don't reuse it.
+	defer t.close()
+	return x11Watch(c, snaps, heartbeat, stop)
+}
+
+func x11Watch(c *x11Conn, snaps chan<- *Snapshot, heartbeat time.Duration, stop <-chan struct{}) error {
+	if err := c.selectInput(c.root, x11PropertyChangeMask); err != nil {
+		return err
+	}
+	rootAtoms := map[uint32]bool{
+		c.atom("_NET_ACTIVE_WINDOW"):   true,
+		c.atom("_NET_CURRENT_DESKTOP"): true,
+		c.atom("_NET_CLIENT_LIST"):     true,
+	}
+	windowAtoms := map[uint32]bool{
+		c.atom("_NET_WM_NAME"):    true,
+		c.atom("_NET_WM_DESKTOP"): true,
+		x11AtomWMName:             true,
+	}
+	relevant := func(p []byte) bool {
+		ev, ok := parsePropertyEvent(p)
+		if !ok {
+			return false
+		}
+		if ev.Window == c.root {
+			return rootAtoms[ev.Atom]
+		}
+		return windowAtoms[ev.Atom]
+	}
+
+	watched := make(map[int]bool)
+	emit := func(at time.Time) (bool, error) {
+		snap, err := x11Snap(c)
+		if err != nil {
+			return false, err
+		}
+		snap.Time = at
+		current := make(map[int]bool, len(snap.Windows))
+		for _, w := range snap.Windows {
+			current[w.ID] = true
+			if !watched[w.ID] {
+				if err := c.selectInput(uint32(w.ID), x11PropertyChangeMask); err != nil {
+					return false, err
+				}
+			}
+		}
+		watched = current
+		select {
+		case snaps <- snap:
+			return true, nil
+		case <-stop:
+			return false, nil
+		}
+	}
+
+	for at := time.Now(); ; {
+		if ok, err := emit(at); !ok {
+			return err
+		}
+		beat := time.After(heartbeat)
+	wait:
+		for {
+			select {
+			case <-stop:
+				return nil
+			case <-c.done:
+				if c.readErr != nil {
+					return c.readErr
+				}
+				return io.EOF
+			case at = <-beat:
+				break wait
+			case p := <-c.events:
+				if relevant(p) {
+					at = time.Now()
+					x11Drain(c, watchSettle)
+					break wait
+				}
+			}
+		}
+	}
+}
+
+// x11Drain discards the events received within d.
+func x11Drain(c *x11Conn, d time.Duration) {
+	timeout := time.After(d)
+	for {
+		select {
+		case <-c.events:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func (t *X11Tracker) connect() (*x11Conn, error) {
+	if t.conn != nil {
+		return t.conn, nil
+	}
+	c, err := x11Dial(t.Display)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.internAtoms(x11Atoms...); err != nil {
+		c.Close()
+		return nil, err
+	}
+	t.conn = c
+	return c, nil
+}
+
+func (t *X11Tracker) close() error {
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+// x11Snap builds a Snapshot from the EWMH properties of the root window
+// and the client windows.
+func x11Snap(c *x11Conn) (*Snapshot, error) {
+	seqList, err := c.sendGetProperty(c.root, c.atom("_NET_CLIENT_LIST"), x11AtomWindow, 1<<16)
+	if err != nil {
+		return nil, err
+	}
+	seqStacking, err := c.sendGetProperty(c.root, c.atom("_NET_CLIENT_LIST_STACKING"), x11AtomWindow, 1<<16)
+	if err != nil {
+		return nil, err
+	}
+	seqActive, err := c.sendGetProperty(c.root, c.atom("_NET_ACTIVE_WINDOW"), x11AtomWindow, 1)
+	if err != nil {
+		return nil, err
+	}
+	seqDesktop, err := c.sendGetProperty(c.root, c.atom("_NET_CURRENT_DESKTOP"), x11AtomCardinal, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := c.propertyReply(seqList)
+	if err != nil {
+		return nil, err
+	}
+	stacking, err := c.propertyReply(seqStacking)
+	if err != nil {
+		return nil, err
+	}
+	activeProp, err := c.propertyReply(seqActive)
+	if err != nil {
+		return nil, err
+	}
+	desktopProp, err := c.propertyReply(seqDesktop)
+	if err != nil {
+		return nil, err
+	}
+	active, _ := activeProp.Uint32()
+	currentDesktop, _ := desktopProp.Uint32()
+
+	ids := list.Uint32s()
+	windows := make([]*Window, 0, len(ids))
+	visible := make([]int, 0, len(ids))
+	for start := 0; start < len(ids); start += x11BatchSize {
+		end := start + x11BatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch, err := x11Windows(c, ids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range batch {
+			windows = append(windows, w.Window)
+			if w.mapState == x11IsViewable && w.IsOnDesktop(int(currentDesktop)) {
+				visible = append(visible, w.ID)
+			}
+		}
+	}
+
+	monitors, err := x11Monitors(c)
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{Windows: windows, Active: int(active), Visible: visible, Monitors: monitors, Time: time.Now()}
+	order := make([]int, 0, len(ids))
+	for _, id := range stacking.Uint32s() {
+		order = append(order, int(id))
+	}
+	setGeometry(snap, order)
+	return snap, nil
+}
+
+// x11Monitors returns the monitors of the screen, as told by the RandR
+// extension (1.5 or later) or else by Xinerama. If neither is
+// available, the root window is the only monitor.
+func x11Monitors(c *x11Conn) ([]*Monitor, error) {
+	if major, ok, err := c.queryExtension("RANDR"); err != nil {
+		return nil, err
+	} else if ok {
+		monitors, err := x11RandRMonitors(c, major)
+		if _, isX11 := err.(*x11Error); err != nil && !isX11 {
+			return nil, err
+		}
+		if len(monitors) > 0 {
+			return monitors, nil
+		}
+	}
+	if major, ok, err := c.queryExtension("XINERAMA"); err != nil {
+		return nil, err
+	} else if ok {
+		monitors, err := x11XineramaMonitors(c, major)
+		if _, isX11 := err.(*x11Error); err != nil && !isX11 {
+			return nil, err
+		}
+		if len(monitors) > 0 {
+			return monitors, nil
+		}
+	}
+	seq, err := c.sendGetGeometry(c.root)
+	if err != nil {
+		return nil, err
+	}
+	width, height, err := c.geometryReply(seq)
+	if err != nil {
+		return nil, err
+	}
+	return []*Monitor{{Rect: Rect{Width: width, Height: height}}}, nil
+}
+
+// x11RandRMonitors sends a RRGetMonitors request, after the
+// RRQueryVersion request that announces RandR 1.5 support.
+func x11RandRMonitors(c *x11Conn, major byte) ([]*Monitor, error) {
+	req := make([]byte, 12)
+	req[0] = major
+	req[1] = 0 // RRQueryVersion
+	x11.PutUint32(req[4:], 1)
+	x11.PutUint32(req[8:], 5)
+	seqVersion, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	req = make([]byte, 12)
+	req[0] = major
+	req[1] = 42 // RRGetMonitors
+	x11.PutUint32(req[4:], c.root)
+	req[8] = 1 // active monitors only
+	seq, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.reply(seqVersion); err != nil {
+		return nil, err
+	}
+	p, err := c.reply(seq)
+	if err != nil {
+		return nil, err
+	}
+
+	n := int(x11.Uint32(p[12:]))
+	monitors := make([]*Monitor, 0, n)
+	for off := 32; len(monitors) < n; {
+		if len(p) < off+24 {
+			return nil, errors.New("x11: short RRGetMonitors reply")
+		}
+		m := p[off:]
+		name, err := c.atomName(x11.Uint32(m))
+		if err != nil {
+			return nil, err
+		}
+		monitors = append(monitors, &Monitor{Name: name, Rect: Rect{
+			X:      int(int16(x11.Uint16(m[8:]))),
+			Y:      int(int16(x11.Uint16(m[10:]))),
+			Width:  int(x11.Uint16(m[12:])),
+			Height: int(x11.Uint16(m[14:])),
+		}})
+		off += 24 + 4*int(x11.Uint16(m[6:]))
+	}
+	return monitors, nil
+}
+
+// x11XineramaMonitors sends a XineramaQueryScreens request. Xinerama
+// screens have no names, so monitors are named after their index.
+func x11XineramaMonitors(c *x11Conn, major byte) ([]*Monitor, error) {
+	req := make([]byte, 4)
+	req[0] = major
+	req[1] = 5 // XineramaQueryScreens
+	seq, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	p, err := c.reply(seq)
+	if err != nil {
+		return nil, err
+	}
+	n := int(x11.Uint32(p[8:]))
+	if len(p) < 32+8*n {
+		return nil, errors.New("x11: short XineramaQueryScreens reply")
+	}
+	monitors := make([]*Monitor, n)
+	for i := range monitors {
+		s := p[32+8*i:]
+		monitors[i] = &Monitor{Name: strconv.Itoa(i), Rect: Rect{
+			X:      int(int16(x11.Uint16(s))),
+			Y:      int(int16(x11.Uint16(s[2:]))),
+			Width:  int(x11.Uint16(s[4:])),
+			Height: int(x11.Uint16(s[6:])),
+		}}
+	}
+	return monitors, nil
+}
+
+// parseWMClass splits the value of a WM_CLASS property, two
+// NUL-terminated strings, into the instance and class names.
+func parseWMClass(value []byte) (instance, class string) {
+	parts := strings.SplitN(strings.TrimRight(string(value), "\x00"), "\x00", 2)
+	if len(parts) == 2 {
+		class = parts[1]
+	}
+	return parts[0], class
+}
+
+// x11Window is a Window along with its X11 map state.
+type x11Window struct {
+	*Window
+	mapState byte
+}
+
+// x11Windows fetches the details of the windows ids with one round trip.
+// Windows that are destroyed while their details are being fetched are
+// left out.
+func x11Windows(c *x11Conn, ids []uint32) ([]x11Window, error) {
+	type cookies struct {
+		desktop, netName, name, class, pid, machine, attrs, geometry, position uint16
+	}
+	sent := make([]cookies, len(ids))
+	for i, id := range ids {
+		var ck cookies
+		var err error
+		if ck.desktop, err = c.sendGetProperty(id, c.atom("_NET_WM_DESKTOP"), x11AtomCardinal, 1); err != nil {
+			return nil, err
+		}
+		if ck.netName, err = c.sendGetProperty(id, c.atom("_NET_WM_NAME"), c.atom("UTF8_STRING"), 1024); err != nil {
+			return nil, err
+		}
+		if ck.name, err = c.sendGetProperty(id, x11AtomWMName, x11AtomAny, 1024); err != nil {
+			return nil, err
+		}
+		if ck.class, err = c.sendGetProperty(id, x11AtomWMClass, x11AtomString, 256); err != nil {
+			return nil, err
+		}
+		if ck.pid, err = c.sendGetProperty(id, c.atom("_NET_WM_PID"), x11AtomCardinal, 1); err != nil {
+			return nil, err
+		}
+		if ck.machine, err = c.sendGetProperty(id, x11AtomWMClientMachine, x11AtomString, 256); err != nil {
+			return nil, err
+		}
+		if ck.attrs, err = c.sendGetWindowAttributes(id); err != nil {
+			return nil, err
+		}
+		if ck.geometry, err = c.sendGetGeometry(id); err != nil {
+			return nil, err
+		}
+		if ck.position, err = c.sendTranslateCoordinates(id); err != nil {
+			return nil, err
+		}
+		sent[i] = ck
+	}
+
+	windows := make([]x11Window, 0, len(ids))
+	for i, id := range ids {
+		ck := sent[i]
+		desktop, errDesktop := c.propertyReply(ck.desktop)
+		netName, errNetName := c.propertyReply(ck.netName)
+		name, errName := c.propertyReply(ck.name)
+		class, errClass := c.propertyReply(ck.class)
+		pid, errPID := c.propertyReply(ck.pid)
+		machine, errMachine := c.propertyReply(ck.machine)
+		mapState, errAttrs := c.mapStateReply(ck.attrs)
+		width, height, errGeometry := c.geometryReply(ck.geometry)
+		x, y, errPosition := c.translateReply(ck.position)
+		gone := false
+		for _, err := range []error{errDesktop, errNetName, errName, errClass, errPID, errMachine, errAttrs, errGeometry, errPosition} {
+			if _, isX11 := err.(*x11Error); isX11 {
+				gone = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if gone {
+			continue
+		}
+
+		w := &Window{ID: int(id), Desktop: -1, Name: string(netName.Value)}
+		if w.Name == "" {
+			w.Name = string(name.Value)
+		}
+		if d, ok := desktop.Uint32(); ok && d != 0xFFFFFFFF {
+			w.Desktop = int(d)
+		}
+		w.Instance, w.Class = parseWMClass(class.Value)
+		w.Geometry = &Rect{X: x, Y: y, Width: width, Height: height}
+		if p, ok := pid.Uint32(); ok && isLocalHost(string(machine.Value)) {
+			w.PID = int(p)
+		}
+		windows = append(windows, x11Window{Window: w, mapState: mapState})
+	}
+	return windows, nil
+}
@@ -0,0 +1,766 @@
+package ultraViolet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeX11Window is a window served by fakeX11Server.
+type fakeX11Window struct {
+	props    map[string][]byte
+	types    map[string]uint32
+	mapState byte
+	geometry Rect
+}
+
+// fakeX11Server answers the requests sent by the X11Tracker from an
+// in-memory set of windows.
+type fakeX11Server struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	root     uint32
+	windows  map[uint32]*fakeX11Window
+	atoms    map[string]uint32
+	selected map[uint32]uint32
+
+	// screenSaver enables the MIT-SCREEN-SAVER extension, which reports
+	// idle and saverState.
+	screenSaver bool
+	idle        time.Duration
+	saverState  byte
+
+	// monitors enables the RandR extension, which reports them.
+	monitors []Monitor
+
+	// hung makes the server stop replying, as a stuck X server does.
+	hung bool
+}
+
+// fakeX11ScreenSaver is the major opcode of the fake MIT-SCREEN-SAVER
+// extension.
+const fakeX11ScreenSaver = 140
+
+// fakeX11RandR is the major opcode of the fake RandR extension.
+const fakeX11RandR = 141
+
+func newFakeX11Server() *fakeX11Server {
+	s := &fakeX11Server{
+		root:     0x100,
+		windows:  make(map[uint32]*fakeX11Window),
+		selected: make(map[uint32]uint32),
+		atoms: map[string]uint32{
+			"CARDINAL": x11AtomCardinal,
+			"STRING":   x11AtomString,
+			"WINDOW":   x11AtomWindow,
+			"WM_NAME":  x11AtomWMName,
+
+			"WM_CLIENT_MACHINE": x11AtomWMClientMachine,
+			"WM_CLASS":          x11AtomWMClass,
+		},
+	}
+	s.windows[s.root] = &fakeX11Window{mapState: x11IsViewable, geometry: Rect{Width: 1920, Height: 1080}}
+	return s
+}
+
+func (s *fakeX11Server) atom(name string) uint32 {
+	if a, ok := s.atoms[name]; ok {
+		return a
+	}
+	a := uint32(100 + len(s.atoms))
+	s.atoms[name] = a
+	return a
+}
+
+func (s *fakeX11Server) window(id uint32) *fakeX11Window {
+	w, ok := s.windows[id]
+	if !ok {
+		w = &fakeX11Window{}
+		s.windows[id] = w
+	}
+	if w.props == nil {
+		w.props, w.types = make(map[string][]byte), make(map[string]uint32)
+	}
+	return w
+}
+
+func (s *fakeX11Server) setCardinals(id uint32, prop string, typ uint32, values ...uint32) {
+	w := s.window(id)
+	w.props[prop], w.types[prop] = cardinals(values...), typ
+}
+
+func (s *fakeX11Server) setString(id uint32, prop string, value string) {
+	w := s.window(id)
+	w.props[prop], w.types[prop] = []byte(value), s.atom("UTF8_STRING")
+}
+
+func (s *fakeX11Server) serve(conn net.Conn) {
+	defer conn.Close()
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	auth := make([]byte, pad4(int(x11.Uint16(head[6:])))+pad4(int(x11.Uint16(head[8:]))))
+	if _, err := io.ReadFull(conn, auth); err != nil {
+		return
+	}
+
+	body := make([]byte, 32, 76)
+	x11.PutUint32(body[4:], 0x200000)
+	x11.PutUint16(body[16:], 4)
+	body[20] = 1
+	body = append(body, "fake"...)
+	screen := make([]byte, 40)
+	x11.PutUint32(screen, s.root)
+	body = append(body, screen...)
+	reply := make([]byte, 8)
+	reply[0] = 1
+	x11.PutUint16(reply[2:], 11)
+	x11.PutUint16(reply[6:], uint16(len(body)/4))
+	if _, err := conn.Write(append(reply, body...)); err != nil {
+		return
+	}
+
+	var seq uint16
+	for {
+		req := make([]byte, 4)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		n := int(x11.Uint16(req[2:]))*4 - 4
+		req = append(req, make([]byte, n)...)
+		if _, err := io.ReadFull(conn, req[4:]); err != nil {
+			return
+		}
+		seq++
+		s.mu.Lock()
+		s.conn = conn
+		reply := s.handle(seq, req)
+		if reply != nil && !s.hung {
+			_, err := conn.Write(reply)
+			if err != nil {
+				s.mu.Unlock()
+				return
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// update runs f while holding the server lock.
+func (s *fakeX11Server) update(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+}
+
+// notify sends a PropertyNotify event for prop of window if the client
+// selected them.
+func (s *fakeX11Server) notify(window uint32, prop string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.selected[window]&x11PropertyChangeMask == 0 {
+		return
+	}
+	p := make([]byte, 32)
+	p[0] = x11PropertyNotify
+	x11.PutUint32(p[4:], window)
+	x11.PutUint32(p[8:], s.atom(prop))
+	s.conn.Write(p)
+}
+
+// waitSelected waits until a client selected the events of window. The
+// request has no reply, so a client may go on before it is handled.
+func (s *fakeX11Server) waitSelected(t *testing.T, window uint32) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		selected := s.selected[window] != 0
+		s.mu.Unlock()
+		if selected {
+			return
+		}
+	}
+	t.Fatalf("window %x never selected", window)
+}
+
+func (s *fakeX11Server) handle(seq uint16, req []byte) []byte {
+	reply := make([]byte, 32)
+	reply[0] = 1
+	x11.PutUint16(reply[2:], seq)
+	switch req[0] {
+	case x11OpChangeWindowAttributes:
+		if _, ok := s.windows[x11.Uint32(req[4:])]; !ok {
+			return fakeX11Error(seq, 3, req[0])
+		}
+		if x11.Uint32(req[8:]) == x11CWEventMask {
+			s.selected[x11.Uint32(req[4:])] = x11.Uint32(req[12:])
+		}
+		return nil
+	case x11OpInternAtom:
+		name := string(req[8 : 8+x11.Uint16(req[4:])])
+		x11.PutUint32(reply[8:], s.atom(name))
+	case x11OpGetProperty:
+		w, ok := s.windows[x11.Uint32(req[4:])]
+		if !ok {
+			return fakeX11Error(seq, 3, req[0])
+		}
+		var prop string
+		for name, a := range s.atoms {
+			if a == x11.Uint32(req[8:]) {
+				prop = name
+			}
+		}
+		value, exists := w.props[prop]
+		typ := w.types[prop]
+		if want := x11.Uint32(req[12:]); !exists || (want != x11AtomAny && want != typ) {
+			break
+		}
+		format := byte(8)
+		if typ == x11AtomCardinal || typ == x11AtomWindow {
+			format = 32
+		}
+		reply[1] = format
+		x11.PutUint32(reply[4:], uint32(pad4(len(value))/4))
+		x11.PutUint32(reply[8:], typ)
+		x11.PutUint32(reply[16:], uint32(len(value)*8/int(format)))
+		reply = appendPadded(reply, value)
+	case x11OpGetWindowAttributes:
+		w, ok := s.windows[x11.Uint32(req[4:])]
+		if !ok {
+			return fakeX11Error(seq, 3, req[0])
+		}
+		reply = append(reply, make([]byte, 12)...)
+		x11.PutUint32(reply[4:], 3)
+		reply[26] = w.mapState
+	case x11OpGetGeometry:
+		w, ok := s.windows[x11.Uint32(req[4:])]
+		if !ok {
+			return fakeX11Error(seq, 9, req[0])
+		}
+		x11.PutUint16(reply[16:], uint16(w.geometry.Width))
+		x11.PutUint16(reply[18:], uint16(w.geometry.Height))
+	case x11OpTranslateCoordinates:
+		w, ok := s.windows[x11.Uint32(req[4:])]
+		if !ok {
+			return fakeX11Error(seq, 3, req[0])
+		}
+		reply[1] = 1
+		x11.PutUint16(reply[12:], uint16(w.geometry.X))
+		x11.PutUint16(reply[14:], uint16(w.geometry.Y))
+	case x11OpGetAtomName:
+		for name, a := range s.atoms {
+			if a == x11.Uint32(req[4:]) {
+				x11.PutUint16(reply[8:], uint16(len(name)))
+				x11.PutUint32(reply[4:], uint32(pad4(len(name))/4))
+				return appendPadded(reply, []byte(name))
+			}
+		}
+		return fakeX11Error(seq, 5, req[0])
+	case x11OpQueryExtension:
+		name := string(req[8 : 8+x11.Uint16(req[4:])])
+		if name == "MIT-SCREEN-SAVER" && s.screenSaver {
+			reply[8] = 1
+			reply[9] = fakeX11ScreenSaver
+		}
+		if name == "RANDR" && s.monitors != nil {
+			reply[8] = 1
+			reply[9] = fakeX11RandR
+		}
+	case fakeX11RandR:
+		switch req[1] {
+		case 0:
+			x11.PutUint32(reply[8:], 1)
+			x11.PutUint32(reply[12:], 6)
+		case 42:
+			x11.PutUint32(reply[12:], uint32(len(s.monitors)))
+			for _, m := range s.monitors {
+				info := make([]byte, 24)
+				x11.PutUint32(info, s.atom(m.Name))
+				x11.PutUint16(info[8:], uint16(m.X))
+				x11.PutUint16(info[10:], uint16(m.Y))
+				x11.PutUint16(info[12:], uint16(m.Width))
+				x11.PutUint16(info[14:], uint16(m.Height))
+				reply = append(reply, info...)
+			}
+			x11.PutUint32(reply[4:], uint32(len(s.monitors)*6))
+		default:
+			return fakeX11Error(seq, 1, req[0])
+		}
+	case fakeX11ScreenSaver:
+		if !s.screenSaver || req[1] != 1 || x11.Uint32(req[4:]) != s.root {
+			return fakeX11Error(seq, 1, req[0])
+		}
+		reply[1] = s.saverState
+		x11.PutUint32(reply[16:], uint32(s.idle/time.Millisecond))
+	default:
+		return fakeX11Error(seq, 1, req[0])
+	}
+	return reply
+}
+
+func fakeX11Error(seq uint16, code, major byte) []byte {
+	p := make([]byte, 32)
+	p[1] = code
+	x11.PutUint16(p[2:], seq)
+	p[10] = major
+	return p
+}
+
+// dialFakeX11 connects to s through an in-memory pipe.
+func dialFakeX11(t *testing.T, s *fakeX11Server) *x11Conn {
+	client, server := net.Pipe()
+	go s.serve(server)
+	c, err := x11Setup(client, "", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.internAtoms(x11Atoms...); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestX11Snap(t *testing.T) {
+	s := newFakeX11Server()
+	s.setCardinals(s.root, "_NET_CLIENT_LIST", x11AtomWindow, 0x1000001, 0x1000002, 0x1000003, 0x1000004)
+	s.setCardinals(s.root, "_NET_ACTIVE_WINDOW", x11AtomWindow, 0x1000002)
+	s.setCardinals(s.root, "_NET_CURRENT_DESKTOP", x11AtomCardinal, 1)
+
+	s.setCardinals(0x1000001, "_NET_WM_DESKTOP", x11AtomCardinal, 0)
+	s.setString(0x1000001, "_NET_WM_NAME", "main.go - Visual Studio Code")
+	s.window(0x1000001).mapState = x11IsViewable
+	s.setCardinals(0x1000001, "_NET_WM_PID", x11AtomCardinal, 111)
+	s.window(0x1000001).props["WM_CLIENT_MACHINE"] = []byte("otherhost")
+	s.window(0x1000001).types["WM_CLIENT_MACHINE"] = x11AtomString
+
+	s.setCardinals(0x1000002, "_NET_WM_DESKTOP", x11AtomCardinal, 1)
+	s.setString(0x1000002, "_NET_WM_NAME", "Terminal")
+	s.window(0x1000002).mapState = x11IsViewable
+	s.setCardinals(0x1000002, "_NET_WM_PID", x11AtomCardinal, 222)
+	s.window(0x1000002).props["WM_CLASS"] = []byte("xterm\x00XTerm\x00")
+	s.window(0x1000002).types["WM_CLASS"] = x11AtomString
+
+	s.setCardinals(0x1000003, "_NET_WM_DESKTOP", x11AtomCardinal, 0xFFFFFFFF)
+	s.window(0x1000003).props["WM_NAME"] = []byte("xclock")
+	s.window(0x1000003).types["WM_NAME"] = x11AtomString
+	s.window(0x1000003).mapState = x11IsViewable
+
+	s.setCardinals(0x1000004, "_NET_WM_DESKTOP", x11AtomCardinal, 1)
+	s.setString(0x1000004, "_NET_WM_NAME", "minimized")
+	s.window(0x1000004).mapState = x11IsUnmapped
+
+	c := dialFakeX11(t, s)
+	defer c.Close()
+	snap, err := x11Snap(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedWindows := []*Window{
+		&Window{ID: 0x1000001, Desktop: 0, Name: "main.go - Visual Studio Code", Geometry: &Rect{}},
+		&Window{ID: 0x1000002, Desktop: 1, Name: "Terminal", Instance: "xterm", Class: "XTerm", Geometry: &Rect{}, PID: 222},
+		&Window{ID: 0x1000003, Desktop: -1, Name: "xclock", Geometry: &Rect{}},
+		&Window{ID: 0x1000004, Desktop: 1, Name: "minimized", Geometry: &Rect{}},
+	}
+	if !reflect.DeepEqual(snap.Windows, expectedWindows) {
+		t.Errorf("windows: %v", snap.Windows)
+	}
+	if snap.Active != 0x1000002 {
+		t.Errorf("active: %d", snap.Active)
+	}
+	if !reflect.DeepEqual(snap.Visible, []int{0x1000002, 0x1000003}) {
+		t.Errorf("visible: %v", snap.Visible)
+	}
+}
+
+func TestX11TrackerSnapContext(t *testing.T) {
+	s := newFakeX11Server()
+	s.setCardinals(s.root, "_NET_CLIENT_LIST", x11AtomWindow)
+	tracker := &X11Tracker{}
+	tracker.conn = dialFakeX11(t, s)
+	defer tracker.Close()
+	if _, err := tracker.SnapContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	s.update(func() { s.hung = true })
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := tracker.SnapContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("hung: %v", err)
+	}
+	if tracker.conn != nil {
+		t.Error("connection kept")
+	}
+}
+
+func TestX11SnapGeometry(t *testing.T) {
+	s := newFakeX11Server()
+	s.setCardinals(s.root, "_NET_CLIENT_LIST", x11AtomWindow, 0x1000001, 0x1000002, 0x1000003)
+	s.setCardinals(s.root, "_NET_CLIENT_LIST_STACKING", x11AtomWindow, 0x1000002, 0x1000001, 0x1000003)
+	s.setCardinals(s.root, "_NET_CURRENT_DESKTOP", x11AtomCardinal, 0)
+	for id, g := range map[uint32]Rect{
+		0x1000001: {X: 0, Y: 0, Width: 1920, Height: 1080},
+		0x1000002: {X: 1600, Y: 0, Width: 800, Height: 600},
+		0x1000003: {X: 960, Y: 540, Width: 960, Height: 540},
+	} {
+		s.setCardinals(id, "_NET_WM_DESKTOP", x11AtomCardinal, 0)
+		s.window(id).mapState = x11IsViewable
+		s.window(id).geometry = g
+	}
+
+	// without RandR, the root window is the only monitor.
+	c := dialFakeX11(t, s)
+	snap, err := x11Snap(c)
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snap.Monitors, []*Monitor{{Rect: Rect{Width: 1920, Height: 1080}}}) {
+		t.Errorf("root monitor: %v", snap.Monitors)
+	}
+	expected := []float64{0.75, 0, 1}
+	for i, w := range snap.Windows {
+		if w.VisibleArea != expected[i] {
+			t.Errorf("root monitor: window%d: %v", i, w.VisibleArea)
+		}
+	}
+
+	s.monitors = []Monitor{
+		{Name: "eDP-1", Rect: Rect{X: 0, Y: 0, Width: 1920, Height: 1080}},
+		{Name: "HDMI-1", Rect: Rect{X: 1920, Y: 0, Width: 1280, Height: 1024}},
+	}
+	c = dialFakeX11(t, s)
+	defer c.Close()
+	snap, err = x11Snap(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Monitors) != 2 || *snap.Monitors[1] != s.monitors[1] {
+		t.Errorf("monitors: %v", snap.Monitors)
+	}
+	expectedWindows := []*Window{
+		&Window{ID: 0x1000001, Desktop: 0, Geometry: &Rect{X: 0, Y: 0, Width: 1920, Height: 1080}, Monitor: "eDP-1", VisibleArea: 0.75},
+		&Window{ID: 0x1000002, Desktop: 0, Geometry: &Rect{X: 1600, Y: 0, Width: 800, Height: 600}, Monitor: "HDMI-1", VisibleArea: 0.6},
+		&Window{ID: 0x1000003, Desktop: 0, Geometry: &Rect{X: 960, Y: 540, Width: 960, Height: 540}, Monitor: "eDP-1", VisibleArea: 1},
+	}
+	if !reflect.DeepEqual(snap.Windows, expectedWindows) {
+		for _, w := range snap.Windows {
+			t.Errorf("window: %+v", w)
+		}
+	}
+}
+
+func TestX11SnapDestroyedWindow(t *testing.T) {
+	s := newFakeX11Server()
+	s.setCardinals(s.root, "_NET_CLIENT_LIST", x11AtomWindow, 0x1000001, 0x1000009)
+	s.setCardinals(s.root, "_NET_ACTIVE_WINDOW", x11AtomWindow, 0x1000001)
+	s.setString(0x1000001, "_NET_WM_NAME", "foo - bar")
+
+	c := dialFakeX11(t, s)
+	defer c.Close()
+	snap, err := x11Snap(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Windows) != 1 || snap.Windows[0].ID != 0x1000001 {
+		t.Errorf("windows: %v", snap.Windows)
+	}
+}
+
+func TestX11SnapManyWindows(t *testing.T) {
+	s := newFakeX11Server()
+	ids := make([]uint32, 2*x11BatchSize+3)
+	for i := range ids {
+		ids[i] = 0x1000000 + uint32(i)
+		s.setString(ids[i], "_NET_WM_NAME", strconv.Itoa(i))
+	}
+	s.setCardinals(s.root, "_NET_CLIENT_LIST", x11AtomWindow, ids...)
+
+	c := dialFakeX11(t, s)
+	defer c.Close()
+	snap, err := x11Snap(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Windows) != len(ids) {
+		t.Fatalf("got %d windows", len(snap.Windows))
+	}
+	for i, w := range snap.Windows {
+		if w.Name != strconv.Itoa(i) {
+			t.Errorf("case%d: %v", i, w)
+		}
+	}
+}
+
+func TestX11Watch(t *testing.T) {
+	s := newFakeX11Server()
+	s.setCardinals(s.root, "_NET_CLIENT_LIST", x11AtomWindow, 0x1000001, 0x1000002)
+	s.setCardinals(s.root, "_NET_ACTIVE_WINDOW", x11AtomWindow, 0x1000001)
+	s.setString(0x1000001, "_NET_WM_NAME", "foo - bar")
+	s.setString(0x1000002, "_NET_WM_NAME", "Terminal")
+
+	c := dialFakeX11(t, s)
+	defer c.Close()
+	snaps := make(chan *Snapshot)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- x11Watch(c, snaps, time.Hour, stop) }()
+
+	next := func() *Snapshot {
+		select {
+		case snap := <-snaps:
+			return snap
+		case err := <-done:
+			t.Fatalf("Watch returned early: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no snapshot")
+		}
+		return nil
+	}
+
+	snap := next()
+	if snap.Active != 0x1000001 || len(snap.Windows) != 2 {
+		t.Errorf("initial: %v", snap.Print())
+	}
+
+	// a focus switch
+	before := time.Now()
+	s.update(func() { s.setCardinals(s.root, "_NET_ACTIVE_WINDOW", x11AtomWindow, 0x1000002) })
+	s.notify(s.root, "_NET_ACTIVE_WINDOW")
+	snap = next()
+	if snap.Active != 0x1000002 {
+		t.Errorf("focus: %v", snap.Print())
+	}
+	if snap.Time.Before(before) {
+		t.Errorf("time: %v", snap.Time)
+	}
+
+	// a title change of a client window
+	s.update(func() { s.setString(0x1000002, "_NET_WM_NAME", "vim") })
+	s.notify(0x1000002, "_NET_WM_NAME")
+	snap = next()
+	if snap.Windows[1].Name != "vim" {
+		t.Errorf("title: %v", snap.Print())
+	}
+
+	// a new window is watched as soon as it appears
+	s.update(func() {
+		s.setString(0x1000003, "_NET_WM_NAME", "new")
+		s.setCardinals(s.root, "_NET_CLIENT_LIST", x11AtomWindow, 0x1000001, 0x1000002, 0x1000003)
+	})
+	s.notify(s.root, "_NET_CLIENT_LIST")
+	if snap = next(); len(snap.Windows) != 3 {
+		t.Errorf("new window: %v", snap.Print())
+	}
+	s.waitSelected(t, 0x1000003)
+	s.update(func() { s.setString(0x1000003, "_NET_WM_NAME", "renamed") })
+	s.notify(0x1000003, "_NET_WM_NAME")
+	if snap = next(); snap.Windows[2].Name != "renamed" {
+		t.Errorf("new window title: %v", snap.Print())
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestX11WatchHeartbeat(t *testing.T) {
+	s := newFakeX11Server()
+	s.setCardinals(s.root, "_NET_CLIENT_LIST", x11AtomWindow, 0x1000001)
+	s.setString(0x1000001, "_NET_WM_NAME", "foo - bar")
+
+	c := dialFakeX11(t, s)
+	defer c.Close()
+	snaps := make(chan *Snapshot)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- x11Watch(c, snaps, 10*time.Millisecond, stop) }()
+
+	// the first snapshot is sent right away, the others by the heartbeat.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-snaps:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("case%d: no snapshot", i)
+		}
+	}
+	close(stop)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseDisplay(t *testing.T) {
+	tests := []struct {
+		display string
+		host    string
+		number  string
+		screen  int
+		isError bool
+	}{
+		{":0", "", "0", 0, false},
+		{":1.2", "", "1", 2, false},
+		{"localhost:10.0", "localhost", "10", 0, false},
+		{"unix:3", "unix", "3", 0, false},
+		{"", "", "", 0, true},
+		{":x", "", "", 0, true},
+		{":0.x", "", "", 0, true},
+	}
+	for i, tt := range tests {
+		host, number, screen, err := parseDisplay(tt.display)
+		if (err != nil) != tt.isError {
+			t.Errorf("case%d: %v", i, err)
+			continue
+		}
+		if host != tt.host || number != tt.number || screen != tt.screen {
+			t.Errorf("case%d: %q %q %d", i, host, number, screen)
+		}
+	}
+}
+
+func TestReadXauthority(t *testing.T) {
+	var b bytes.Buffer
+	entry := func(family uint16, fields ...string) {
+		binary.Write(&b, binary.BigEndian, family)
+		for _, f := range fields {
+			binary.Write(&b, binary.BigEndian, uint16(len(f)))
+			b.WriteString(f)
+		}
+	}
+	entry(xauthFamilyLocal, "other", "0", "MIT-MAGIC-COOKIE-1", "nope")
+	entry(xauthFamilyLocal, "host", "1", "MIT-MAGIC-COOKIE-1", "one")
+	entry(xauthFamilyLocal, "host", "0", "XDM-AUTHORIZATION-1", "xdm")
+	entry(xauthFamilyLocal, "host", "0", "MIT-MAGIC-COOKIE-1", "zero")
+	entry(xauthFamilyWild, "", "2", "MIT-MAGIC-COOKIE-1", "wild")
+	xauth := b.Bytes()
+
+	tests := []struct {
+		host, number string
+		data         string
+	}{
+		{"host", "0", "zero"},
+		{"host", "1", "one"},
+		{"host", "2", "wild"},
+		{"host", "3", ""},
+	}
+	for i, tt := range tests {
+		name, data := readXauthority(bytes.NewReader(xauth), tt.host, tt.number)
+		if string(data) != tt.data || (tt.data != "" && name != "MIT-MAGIC-COOKIE-1") {
+			t.Errorf("case%d: %q %q", i, name, data)
+		}
+	}
+}
+
+// TestX11TrackerXvfb runs the X11Tracker against a real X server. It is
+// skipped unless Xvfb is installed.
+func TestX11TrackerXvfb(t *testing.T) {
+	if _, err := exec.LookPath("Xvfb"); err != nil {
+		t.Skip("Xvfb is not installed")
+	}
+	const display = ":93"
+	xvfb := exec.Command("Xvfb", display, "-nolisten", "tcp")
+	if err := xvfb.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer xvfb.Process.Kill()
+	for i := 0; ; i++ {
+		if _, err := os.Stat("/tmp/.X11-unix/X93"); err == nil {
+			break
+		}
+		if i == 50 {
+			t.Fatal("Xvfb did not start")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	c, err := x11Dial(display)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.internAtoms(x11Atoms...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Act as a tiny window manager: create two windows, map one of them
+	// and publish the EWMH hints the tracker reads.
+	mapped, unmapped := c.idBase|1, c.idBase|2
+	for _, id := range []uint32{mapped, unmapped} {
+		req := make([]byte, 32)
+		req[0] = 1 // CreateWindow
+		x11.PutUint32(req[4:], id)
+		x11.PutUint32(req[8:], c.root)
+		x11.PutUint16(req[16:], 100)
+		x11.PutUint16(req[18:], 100)
+		x11.PutUint16(req[22:], 1) // InputOutput
+		mustSend(t, c, req)
+	}
+	req := make([]byte, 8)
+	req[0] = 8 // MapWindow
+	x11.PutUint32(req[4:], mapped)
+	mustSend(t, c, req)
+	changeProperty(t, c, c.root, c.atom("_NET_CLIENT_LIST"), x11AtomWindow, 32, cardinals(mapped, unmapped))
+	changeProperty(t, c, c.root, c.atom("_NET_ACTIVE_WINDOW"), x11AtomWindow, 32, cardinals(mapped))
+	changeProperty(t, c, c.root, c.atom("_NET_CURRENT_DESKTOP"), x11AtomCardinal, 32, cardinals(0))
+	changeProperty(t, c, mapped, c.atom("_NET_WM_DESKTOP"), x11AtomCardinal, 32, cardinals(0))
+	changeProperty(t, c, unmapped, c.atom("_NET_WM_DESKTOP"), x11AtomCardinal, 32, cardinals(0))
+	changeProperty(t, c, mapped, c.atom("_NET_WM_NAME"), c.atom("UTF8_STRING"), 8, []byte("foo - bar"))
+	changeProperty(t, c, unmapped, x11AtomWMName, x11AtomString, 8, []byte("hidden"))
+
+	tracker := &X11Tracker{Display: display}
+	defer tracker.Close()
+	snap, err := tracker.Snap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(snap.Windows, func(i, j int) bool { return snap.Windows[i].ID < snap.Windows[j].ID })
+	expected := []*Window{
+		&Window{ID: int(mapped), Desktop: 0, Name: "foo - bar"},
+		&Window{ID: int(unmapped), Desktop: 0, Name: "hidden"},
+	}
+	if !reflect.DeepEqual(snap.Windows, expected) {
+		t.Errorf("windows: %v", snap.Windows)
+	}
+	if snap.Active != int(mapped) || !reflect.DeepEqual(snap.Visible, []int{int(mapped)}) {
+		t.Errorf("active: %d, visible: %v", snap.Active, snap.Visible)
+	}
+}
+
+func mustSend(t *testing.T, c *x11Conn, req []byte) {
+	if _, err := c.send(req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// changeProperty replaces a property of window with data.
+func changeProperty(t *testing.T, c *x11Conn, window, property, typ uint32, format byte, data []byte) {
+	req := make([]byte, 24)
+	req[0] = 18 // ChangeProperty
+	x11.PutUint32(req[4:], window)
+	x11.PutUint32(req[8:], property)
+	x11.PutUint32(req[12:], typ)
+	req[16] = format
+	x11.PutUint32(req[20:], uint32(len(data)*8/int(format)))
+	mustSend(t, c, appendPadded(req, data))
+}
+
+// cardinals encodes values as the data of a property with format 32.
+func cardinals(values ...uint32) []byte {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		x11.PutUint32(b[i*4:], v)
+	}
+	return b
+}
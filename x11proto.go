@@ -0,0 +1,612 @@
+package ultraViolet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// This file implements the tiny subset of the X11 core protocol needed by
+// the X11Tracker. Requests are written in little-endian byte order; replies,
+// errors and events are read by a single goroutine and handed out through
+// channels, so that many requests can be pipelined and their replies
+// collected afterwards.
+
+const (
+	x11OpChangeWindowAttributes = 2
+	x11OpGetWindowAttributes    = 3
+	x11OpGetGeometry            = 14
+	x11OpInternAtom             = 16
+	x11OpGetAtomName            = 17
+	x11OpGetProperty            = 20
+	x11OpTranslateCoordinates   = 40
+	x11OpQueryExtension         = 98
+)
+
+const (
+	x11CWEventMask        = 1 << 11
+	x11PropertyChangeMask = 1 << 22
+)
+
+// x11PropertyNotify is the code of the event sent when a property of a
+// window whose PropertyChangeMask is selected changes.
+const x11PropertyNotify = 28
+
+// x11 map states as reported by GetWindowAttributes.
+const (
+	x11IsUnmapped   = 0
+	x11IsUnviewable = 1
+	x11IsViewable   = 2
+)
+
+// predefined atoms.
+const (
+	x11AtomAny             = 0
+	x11AtomCardinal        = 6
+	x11AtomString          = 31
+	x11AtomWindow          = 33
+	x11AtomWMClientMachine = 36
+	x11AtomWMName          = 39
+	x11AtomWMClass         = 67
+)
+
+var x11 = binary.LittleEndian
+
+// x11Error is an error packet sent by the X server.
+type x11Error struct {
+	Code   byte
+	Major  byte
+	Value  uint32
+	Serial uint16
+}
+
+func (e *x11Error) Error() string {
+	return fmt.Sprintf("x11: error %d for request %d (value %d)", e.Code, e.Major, e.Value)
+}
+
+// x11Conn is a connection to an X server.
+type x11Conn struct {
+	conn   net.Conn
+	root   uint32
+	idBase uint32
+
+	mu      sync.Mutex
+	seq     uint16
+	atoms   map[string]uint32
+	exts    map[string]byte
+	replies chan []byte
+	events  chan []byte
+
+	readErr error
+	done    chan struct{}
+}
+
+// x11Dial connects to the X server of display, which uses the format of
+// the DISPLAY environment variable. If display is empty, $DISPLAY is used.
+func x11Dial(display string) (*x11Conn, error) {
+	if display == "" {
+		display = os.Getenv("DISPLAY")
+	}
+	host, number, screen, err := parseDisplay(display)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	if host == "" || host == "unix" {
+		conn, err = net.Dial("unix", "/tmp/.X11-unix/X"+number)
+	} else {
+		port, _ := strconv.Atoi(number)
+		conn, err = net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(6000+port)))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	authName, authData := x11Auth(host, number)
+	c, err := x11Setup(conn, authName, authData, screen)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseDisplay splits a display name of the form [host]:number[.screen].
+func parseDisplay(display string) (host, number string, screen int, err error) {
+	colon := strings.LastIndex(display, ":")
+	if colon == -1 {
+		return "", "", 0, fmt.Errorf("x11: invalid display %q", display)
+	}
+	host, number = display[:colon], display[colon+1:]
+	if dot := strings.Index(number, "."); dot != -1 {
+		screen, err = strconv.Atoi(number[dot+1:])
+		if err != nil {
+			return "", "", 0, fmt.Errorf("x11: invalid display %q", display)
+		}
+		number = number[:dot]
+	}
+	if _, err := strconv.Atoi(number); err != nil {
+		return "", "", 0, fmt.Errorf("x11: invalid display %q", display)
+	}
+	return host, number, screen, nil
+}
+
+// x11Auth looks up the MIT-MAGIC-COOKIE-1 for the display in the
+// Xauthority file. It returns empty values if no cookie is found.
+func x11Auth(host, number string) (name string, data []byte) {
+	path := os.Getenv("XAUTHORITY")
+	if path == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return "", nil
+		}
+		path = filepath.Join(home, ".Xauthority")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil
+	}
+	defer f.Close()
+
+	if host == "" || host == "unix" {
+		host, _ = os.Hostname()
+	}
+	return readXauthority(f, host, number)
+}
+
+const (
+	xauthFamilyLocal = 256
+	xauthFamilyWild  = 65535
+)
+
+// readXauthority returns the first MIT-MAGIC-COOKIE-1 in r that matches
+// host and the display number.
+func readXauthority(r io.Reader, host, number string) (name string, data []byte) {
+	readString := func() ([]byte, error) {
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	}
+	for {
+		var family uint16
+		if err := binary.Read(r, binary.BigEndian, &family); err != nil {
+			return "", nil
+		}
+		var fields [4][]byte
+		for i := range fields {
+			b, err := readString()
+			if err != nil {
+				return "", nil
+			}
+			fields[i] = b
+		}
+		addr, num, authName, authData := string(fields[0]), string(fields[1]), string(fields[2]), fields[3]
+		if authName != "MIT-MAGIC-COOKIE-1" || (num != "" && num != number) {
+			continue
+		}
+		if family == xauthFamilyWild || addr == host {
+			return authName, authData
+		}
+	}
+}
+
+// x11Setup performs the connection setup on conn and starts reading
+// packets from the server.
+func x11Setup(conn net.Conn, authName string, authData []byte, screen int) (*x11Conn, error) {
+	req := make([]byte, 12, 12+pad4(len(authName))+pad4(len(authData)))
+	req[0] = 'l'
+	x11.PutUint16(req[2:], 11)
+	x11.PutUint16(req[4:], 0)
+	x11.PutUint16(req[6:], uint16(len(authName)))
+	x11.PutUint16(req[8:], uint16(len(authData)))
+	req = appendPadded(req, []byte(authName))
+	req = appendPadded(req, authData)
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return nil, err
+	}
+	body := make([]byte, int(x11.Uint16(head[6:]))*4)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, err
+	}
+	switch head[0] {
+	case 1:
+	case 0:
+		n := int(head[1])
+		if n > len(body) {
+			n = len(body)
+		}
+		return nil, fmt.Errorf("x11: connection refused: %s", body[:n])
+	default:
+		return nil, errors.New("x11: server requires further authentication")
+	}
+
+	// body starts at offset 8 of the setup reply.
+	if len(body) < 32 {
+		return nil, errors.New("x11: short setup reply")
+	}
+	idBase := x11.Uint32(body[4:])
+	vendorLen := int(x11.Uint16(body[16:]))
+	numScreens := int(body[20])
+	numFormats := int(body[21])
+	off := 32 + pad4(vendorLen) + 8*numFormats
+	if screen >= numScreens {
+		return nil, fmt.Errorf("x11: screen %d does not exist", screen)
+	}
+	var root uint32
+	for i := 0; i <= screen; i++ {
+		if off+40 > len(body) {
+			return nil, errors.New("x11: short setup reply")
+		}
+		root = x11.Uint32(body[off:])
+		numDepths := int(body[off+39])
+		off += 40
+		for d := 0; d < numDepths; d++ {
+			if off+8 > len(body) {
+				return nil, errors.New("x11: short setup reply")
+			}
+			numVisuals := int(x11.Uint16(body[off+2:]))
+			off += 8 + 24*numVisuals
+		}
+	}
+
+	c := &x11Conn{
+		conn:    conn,
+		root:    root,
+		idBase:  idBase,
+		atoms:   make(map[string]uint32),
+		replies: make(chan []byte, 4096),
+		events:  make(chan []byte, 64),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// readLoop reads packets from the server until the connection fails.
+// Replies and errors are sent to c.replies in the order they arrive;
+// events are sent to c.events, or dropped if nobody keeps up with them.
+func (c *x11Conn) readLoop() {
+	defer close(c.done)
+	for {
+		p := make([]byte, 32)
+		if _, err := io.ReadFull(c.conn, p); err != nil {
+			c.readErr = err
+			return
+		}
+		switch p[0] {
+		case 0:
+			c.replies <- p
+		case 1:
+			if n := x11.Uint32(p[4:]); n > 0 {
+				p = append(p, make([]byte, int(n)*4)...)
+				if _, err := io.ReadFull(c.conn, p[32:]); err != nil {
+					c.readErr = err
+					return
+				}
+			}
+			c.replies <- p
+		default:
+			select {
+			case c.events <- p:
+			default:
+			}
+		}
+	}
+}
+
+// Close closes the connection.
+func (c *x11Conn) Close() error {
+	return c.conn.Close()
+}
+
+// send writes a request and returns its sequence number. The length
+// field of req is filled in by send.
+func (c *x11Conn) send(req []byte) (uint16, error) {
+	x11.PutUint16(req[2:], uint16(len(req)/4))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.conn.Write(req); err != nil {
+		return 0, err
+	}
+	c.seq++
+	return c.seq, nil
+}
+
+// reply waits for the reply to the request with sequence number seq.
+// Requests must be collected in the order they were sent.
+func (c *x11Conn) reply(seq uint16) ([]byte, error) {
+	for {
+		var p []byte
+		select {
+		case p = <-c.replies:
+		case <-c.done:
+			select {
+			case p = <-c.replies:
+			default:
+				if c.readErr != nil {
+					return nil, c.readErr
+				}
+				return nil, io.EOF
+			}
+		}
+		s := x11.Uint16(p[2:])
+		if p[0] == 0 {
+			e := &x11Error{Code: p[1], Serial: s, Value: x11.Uint32(p[4:]), Major: p[10]}
+			if s == seq {
+				return nil, e
+			}
+			// an error for a request without reply; nobody waits for it.
+			continue
+		}
+		if s != seq {
+			return nil, fmt.Errorf("x11: got reply %d while waiting for %d", s, seq)
+		}
+		return p, nil
+	}
+}
+
+// internAtoms resolves names to atoms with a single round trip, caching
+// the results.
+func (c *x11Conn) internAtoms(names ...string) error {
+	seqs := make([]uint16, 0, len(names))
+	pending := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, ok := c.atoms[name]; ok {
+			continue
+		}
+		req := make([]byte, 8, 8+pad4(len(name)))
+		req[0] = x11OpInternAtom
+		x11.PutUint16(req[4:], uint16(len(name)))
+		req = appendPadded(req, []byte(name))
+		seq, err := c.send(req)
+		if err != nil {
+			return err
+		}
+		seqs = append(seqs, seq)
+		pending = append(pending, name)
+	}
+	for i, seq := range seqs {
+		p, err := c.reply(seq)
+		if err != nil {
+			return err
+		}
+		c.atoms[pending[i]] = x11.Uint32(p[8:])
+	}
+	return nil
+}
+
+// atom returns an atom previously resolved by internAtoms.
+func (c *x11Conn) atom(name string) uint32 {
+	return c.atoms[name]
+}
+
+// sendGetProperty requests up to maxLen 32-bit units of a property.
+func (c *x11Conn) sendGetProperty(window, property, typ uint32, maxLen uint32) (uint16, error) {
+	req := make([]byte, 24)
+	req[0] = x11OpGetProperty
+	x11.PutUint32(req[4:], window)
+	x11.PutUint32(req[8:], property)
+	x11.PutUint32(req[12:], typ)
+	x11.PutUint32(req[16:], 0)
+	x11.PutUint32(req[20:], maxLen)
+	return c.send(req)
+}
+
+// x11Property is the value of a window property.
+type x11Property struct {
+	Type   uint32
+	Format byte
+	Value  []byte
+}
+
+// Uint32s returns the value of a property with format 32.
+func (p *x11Property) Uint32s() []uint32 {
+	if p.Format != 32 {
+		return nil
+	}
+	v := make([]uint32, len(p.Value)/4)
+	for i := range v {
+		v[i] = x11.Uint32(p.Value[i*4:])
+	}
+	return v
+}
+
+// Uint32 returns the first value of a property with format 32 and
+// whether there was one.
+func (p *x11Property) Uint32() (uint32, bool) {
+	v := p.Uint32s()
+	if len(v) == 0 {
+		return 0, false
+	}
+	return v[0], true
+}
+
+// propertyReply reads the reply of a GetProperty request.
+func (c *x11Conn) propertyReply(seq uint16) (*x11Property, error) {
+	p, err := c.reply(seq)
+	if err != nil {
+		return nil, err
+	}
+	format := p[1]
+	n := int(x11.Uint32(p[16:])) * int(format) / 8
+	if 32+n > len(p) {
+		return nil, errors.New("x11: short GetProperty reply")
+	}
+	return &x11Property{Type: x11.Uint32(p[8:]), Format: format, Value: p[32 : 32+n]}, nil
+}
+
+// selectInput sets the events the connection wants to receive for
+// window. Errors, e.g. for windows that no longer exist, are ignored.
+func (c *x11Conn) selectInput(window, mask uint32) error {
+	req := make([]byte, 16)
+	req[0] = x11OpChangeWindowAttributes
+	x11.PutUint32(req[4:], window)
+	x11.PutUint32(req[8:], x11CWEventMask)
+	x11.PutUint32(req[12:], mask)
+	_, err := c.send(req)
+	return err
+}
+
+// x11PropertyEvent is a decoded PropertyNotify event.
+type x11PropertyEvent struct {
+	Window uint32
+	Atom   uint32
+}
+
+// parsePropertyEvent decodes p if it is a PropertyNotify event.
+func parsePropertyEvent(p []byte) (x11PropertyEvent, bool) {
+	// the most significant bit is set for events sent by other clients.
+	if p[0]&0x7f != x11PropertyNotify {
+		return x11PropertyEvent{}, false
+	}
+	return x11PropertyEvent{Window: x11.Uint32(p[4:]), Atom: x11.Uint32(p[8:])}, true
+}
+
+// sendGetWindowAttributes requests the attributes of window.
+func (c *x11Conn) sendGetWindowAttributes(window uint32) (uint16, error) {
+	req := make([]byte, 8)
+	req[0] = x11OpGetWindowAttributes
+	x11.PutUint32(req[4:], window)
+	return c.send(req)
+}
+
+// mapStateReply reads the map state from a GetWindowAttributes reply.
+func (c *x11Conn) mapStateReply(seq uint16) (byte, error) {
+	p, err := c.reply(seq)
+	if err != nil {
+		return 0, err
+	}
+	if len(p) < 44 {
+		return 0, errors.New("x11: short GetWindowAttributes reply")
+	}
+	return p[26], nil
+}
+
+// queryExtension returns the major opcode of the extension called name,
+// or false if the server doesn't support it. Answers are cached.
+func (c *x11Conn) queryExtension(name string) (byte, bool, error) {
+	if major, ok := c.exts[name]; ok {
+		return major, major != 0, nil
+	}
+	major, present, err := c.sendQueryExtension(name)
+	if err != nil {
+		return 0, false, err
+	}
+	if !present {
+		major = 0
+	}
+	if c.exts == nil {
+		c.exts = make(map[string]byte)
+	}
+	c.exts[name] = major
+	return major, present, nil
+}
+
+func (c *x11Conn) sendQueryExtension(name string) (byte, bool, error) {
+	req := make([]byte, 8, 8+pad4(len(name)))
+	req[0] = x11OpQueryExtension
+	x11.PutUint16(req[4:], uint16(len(name)))
+	req = appendPadded(req, []byte(name))
+	seq, err := c.send(req)
+	if err != nil {
+		return 0, false, err
+	}
+	p, err := c.reply(seq)
+	if err != nil {
+		return 0, false, err
+	}
+	return p[9], p[8] != 0, nil
+}
+
+// sendGetGeometry requests the geometry of a window relative to its
+// parent.
+func (c *x11Conn) sendGetGeometry(window uint32) (uint16, error) {
+	req := make([]byte, 8)
+	req[0] = x11OpGetGeometry
+	x11.PutUint32(req[4:], window)
+	return c.send(req)
+}
+
+// geometryReply reads the size of the window from a GetGeometry reply.
+func (c *x11Conn) geometryReply(seq uint16) (width, height int, err error) {
+	p, err := c.reply(seq)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(x11.Uint16(p[16:])), int(x11.Uint16(p[18:])), nil
+}
+
+// sendTranslateCoordinates requests the position of the top left corner
+// of window on the root window.
+func (c *x11Conn) sendTranslateCoordinates(window uint32) (uint16, error) {
+	req := make([]byte, 16)
+	req[0] = x11OpTranslateCoordinates
+	x11.PutUint32(req[4:], window)
+	x11.PutUint32(req[8:], c.root)
+	return c.send(req)
+}
+
+// translateReply reads the coordinates from a TranslateCoordinates reply.
+func (c *x11Conn) translateReply(seq uint16) (x, y int, err error) {
+	p, err := c.reply(seq)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(int16(x11.Uint16(p[12:]))), int(int16(x11.Uint16(p[14:]))), nil
+}
+
+// atomName returns the name of atom, which is looked up in the atoms
+// interned so far before asking the server.
+func (c *x11Conn) atomName(atom uint32) (string, error) {
+	for name, a := range c.atoms {
+		if a == atom {
+			return name, nil
+		}
+	}
+	req := make([]byte, 8)
+	req[0] = x11OpGetAtomName
+	x11.PutUint32(req[4:], atom)
+	seq, err := c.send(req)
+	if err != nil {
+		return "", err
+	}
+	p, err := c.reply(seq)
+	if err != nil {
+		return "", err
+	}
+	n := int(x11.Uint16(p[8:]))
+	if len(p) < 32+n {
+		return "", errors.New("x11: short GetAtomName reply")
+	}
+	name := string(p[32 : 32+n])
+	c.atoms[name] = atom
+	return name, nil
+}
+
+// pad4 returns n rounded up to a multiple of 4.
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// appendPadded appends b to req followed by padding to a multiple of 4.
+func appendPadded(req, b []byte) []byte {
+	req = append(req, b...)
+	return append(req, make([]byte, pad4(len(b))-len(b))...)
+}